@@ -0,0 +1,12 @@
+// Package frontend bundles the production build of the React app into the
+// server binary. Run `npm run build` in this folder before `go build` to
+// include the UI.
+package frontend
+
+import "embed"
+
+// Dist holds the contents of frontend/dist. dist/.gitkeep is tracked so the
+// package still compiles before the UI has been built.
+//
+//go:embed all:dist
+var Dist embed.FS
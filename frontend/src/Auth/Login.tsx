@@ -13,6 +13,9 @@ export default function Login({ onLogin }: LoginProps) {
   const [password, setPassword] = useState("");
   const [error, setError] = useState("");
   const [loading, setLoading] = useState(false);
+  // Set when the account has two-factor authentication enabled
+  const [challenge, setChallenge] = useState("");
+  const [code, setCode] = useState("");
 
   // Get dynamic styles
   const { accentStyles } = useTheme();
@@ -23,11 +26,23 @@ export default function Login({ onLogin }: LoginProps) {
     setLoading(true);
 
     try {
-      await api.post("/login", { username, password });
+      if (challenge) {
+        await api.post("/login/2fa", { challenge, code });
+        onLogin();
+        return;
+      }
+
+      const res = await api.post("/login", { username, password });
+      if (res.data?.two_factor_required) {
+        setChallenge(res.data.challenge);
+        return;
+      }
       onLogin();
     } catch (err: any) {
       if (err.response?.status === 423) {
         setError("System setup required. Please use the Setup API first.");
+      } else if (challenge) {
+        setError("Invalid code. Please try again.");
       } else {
         setError("Invalid credentials. Please try again.");
       }
@@ -62,38 +77,58 @@ export default function Login({ onLogin }: LoginProps) {
         )}
 
         <form onSubmit={handleSubmit} className="space-y-5">
-          <div>
-            <label className="block text-sm font-semibold text-gray-700 dark:text-gray-300 mb-2">
-              Username
-            </label>
-            <input
-              type="text"
-              value={username}
-              onChange={(e) => setUsername(e.target.value)}
-              className={`w-full px-4 py-3 bg-gray-50 dark:bg-gray-950 border border-gray-200 dark:border-gray-800 rounded-xl focus:outline-none focus:ring-2 focus:border-transparent transition-all text-gray-900 dark:text-white ${accentStyles.ring}`}
-              placeholder="Enter username"
-            />
-          </div>
+          {challenge ? (
+            <div>
+              <label className="block text-sm font-semibold text-gray-700 dark:text-gray-300 mb-2">
+                Authentication Code
+              </label>
+              <input
+                type="text"
+                inputMode="numeric"
+                autoComplete="one-time-code"
+                autoFocus
+                value={code}
+                onChange={(e) => setCode(e.target.value)}
+                className={`w-full px-4 py-3 bg-gray-50 dark:bg-gray-950 border border-gray-200 dark:border-gray-800 rounded-xl focus:outline-none focus:ring-2 focus:border-transparent transition-all text-gray-900 dark:text-white ${accentStyles.ring}`}
+                placeholder="6-digit code or recovery code"
+              />
+            </div>
+          ) : (
+            <>
+              <div>
+                <label className="block text-sm font-semibold text-gray-700 dark:text-gray-300 mb-2">
+                  Username
+                </label>
+                <input
+                  type="text"
+                  value={username}
+                  onChange={(e) => setUsername(e.target.value)}
+                  className={`w-full px-4 py-3 bg-gray-50 dark:bg-gray-950 border border-gray-200 dark:border-gray-800 rounded-xl focus:outline-none focus:ring-2 focus:border-transparent transition-all text-gray-900 dark:text-white ${accentStyles.ring}`}
+                  placeholder="Enter username"
+                />
+              </div>
 
-          <div>
-            <label className="block text-sm font-semibold text-gray-700 dark:text-gray-300 mb-2">
-              Password
-            </label>
-            <input
-              type="password"
-              value={password}
-              onChange={(e) => setPassword(e.target.value)}
-              className={`w-full px-4 py-3 bg-gray-50 dark:bg-gray-950 border border-gray-200 dark:border-gray-800 rounded-xl focus:outline-none focus:ring-2 focus:border-transparent transition-all text-gray-900 dark:text-white ${accentStyles.ring}`}
-              placeholder="••••••••"
-            />
-          </div>
+              <div>
+                <label className="block text-sm font-semibold text-gray-700 dark:text-gray-300 mb-2">
+                  Password
+                </label>
+                <input
+                  type="password"
+                  value={password}
+                  onChange={(e) => setPassword(e.target.value)}
+                  className={`w-full px-4 py-3 bg-gray-50 dark:bg-gray-950 border border-gray-200 dark:border-gray-800 rounded-xl focus:outline-none focus:ring-2 focus:border-transparent transition-all text-gray-900 dark:text-white ${accentStyles.ring}`}
+                  placeholder="••••••••"
+                />
+              </div>
+            </>
+          )}
 
           <button
             type="submit"
             disabled={loading}
             className={`w-full py-3.5 font-bold text-white rounded-xl shadow-lg hover:shadow-xl hover:-translate-y-0.5 transition-all disabled:opacity-70 disabled:cursor-not-allowed disabled:transform-none ${accentStyles.bg} ${accentStyles.bgHover}`}
           >
-            {loading ? "Authenticating..." : "Sign In"}
+            {loading ? "Authenticating..." : challenge ? "Verify" : "Sign In"}
           </button>
         </form>
       </div>
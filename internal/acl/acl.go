@@ -0,0 +1,136 @@
+package acl
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"GoFiles/internal/storage"
+	"GoFiles/internal/types"
+)
+
+// Permissions
+const PermRead = "read"
+const PermWrite = "write"
+const PermDelete = "delete"
+
+var ErrInvalidRule = errors.New("invalid access rule")
+
+// Allowed reports whether the user holds perm on relPath (relative to the root).
+// Admins and users without any rules have full access. Otherwise the rule
+// with the longest matching path prefix decides; no match means no access.
+func Allowed(user types.User, relPath, perm string) bool {
+	if user.Role == "admin" || len(user.Rules) == 0 {
+		return true
+	}
+	rule, ok := matchRule(user.Rules, Clean(relPath))
+	if !ok {
+		return false
+	}
+	for _, p := range rule.Permissions {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowedTree is Allowed for relPath and everything below it, as found in
+// the tree at src in fsys (src is relPath itself, unless that tree is being
+// copied or moved to relPath). A deeper rule that withholds perm denies the
+// whole tree as soon as the tree holds something at its path, so acting on
+// a parent folder can't get around it.
+func AllowedTree(user types.User, fsys storage.FS, relPath, src, perm string) bool {
+	if !Allowed(user, relPath, perm) {
+		return false
+	}
+	if user.Role == "admin" {
+		return true
+	}
+	p := Clean(relPath)
+	for _, rule := range user.Rules {
+		rp := Clean(rule.Path)
+		if rp == p || !isUnder(rp, p) || hasPerm(rule, perm) {
+			continue
+		}
+		name, ok := storage.Clean(path.Join(src, strings.TrimPrefix(rp, p)))
+		if !ok {
+			return false
+		}
+		if _, err := fsys.Stat(name); !errors.Is(err, fs.ErrNotExist) {
+			return false
+		}
+	}
+	return true
+}
+
+// CanSee reports whether relPath should appear in listings: either it is
+// readable, or it is a parent folder of something readable (so the user
+// can navigate down to it).
+func CanSee(user types.User, relPath string) bool {
+	if Allowed(user, relPath, PermRead) {
+		return true
+	}
+	p := Clean(relPath)
+	for _, rule := range user.Rules {
+		if isUnder(Clean(rule.Path), p) && hasPerm(rule, PermRead) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateRules normalizes rule paths and rejects unknown permissions
+func ValidateRules(rules []types.ACLRule) ([]types.ACLRule, error) {
+	out := make([]types.ACLRule, 0, len(rules))
+	for _, rule := range rules {
+		if rule.Path == "" {
+			return nil, ErrInvalidRule
+		}
+		for _, p := range rule.Permissions {
+			if p != PermRead && p != PermWrite && p != PermDelete {
+				return nil, ErrInvalidRule
+			}
+		}
+		out = append(out, types.ACLRule{Path: Clean(rule.Path), Permissions: rule.Permissions})
+	}
+	return out, nil
+}
+
+// Clean turns a request path into the canonical "/a/b" form used by rules
+func Clean(relPath string) string {
+	return path.Clean("/" + filepath.ToSlash(relPath))
+}
+
+// matchRule finds the most specific rule covering p
+func matchRule(rules []types.ACLRule, p string) (types.ACLRule, bool) {
+	var best types.ACLRule
+	found := false
+	for _, rule := range rules {
+		rp := Clean(rule.Path)
+		if isUnder(p, rp) && (!found || len(rp) > len(Clean(best.Path))) {
+			best = rule
+			found = true
+		}
+	}
+	return best, found
+}
+
+// isUnder reports whether p equals prefix or lies inside it
+func isUnder(p, prefix string) bool {
+	if prefix == "/" || p == prefix {
+		return true
+	}
+	return strings.HasPrefix(p, prefix+"/")
+}
+
+func hasPerm(rule types.ACLRule, perm string) bool {
+	for _, p := range rule.Permissions {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
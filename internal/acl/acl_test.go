@@ -0,0 +1,134 @@
+package acl
+
+import (
+	"path"
+	"testing"
+
+	"GoFiles/internal/storage"
+	"GoFiles/internal/types"
+)
+
+func TestAllowed(t *testing.T) {
+	bob := types.User{Username: "bob", Role: "user", Rules: []types.ACLRule{
+		{Path: "/projects", Permissions: []string{PermRead}},
+		{Path: "/projects/shared", Permissions: []string{PermRead, PermWrite, PermDelete}},
+		{Path: "/projects/shared/locked", Permissions: nil},
+		{Path: "/inbox/", Permissions: []string{PermWrite}},
+	}}
+	admin := types.User{Username: "root", Role: "admin", Rules: bob.Rules}
+	open := types.User{Username: "carol", Role: "user"}
+
+	tests := []struct {
+		name string
+		user types.User
+		path string
+		perm string
+		want bool
+	}{
+		{"admin ignores rules", admin, "/elsewhere", PermDelete, true},
+		{"no rules means full access", open, "/anything", PermDelete, true},
+		{"exact rule", bob, "/projects", PermRead, true},
+		{"inherited by children", bob, "/projects/a/b.txt", PermRead, true},
+		{"permission not granted", bob, "/projects/a.txt", PermWrite, false},
+		{"longer prefix wins", bob, "/projects/shared/a.txt", PermWrite, true},
+		{"longest prefix can take away", bob, "/projects/shared/locked/a.txt", PermRead, false},
+		{"sibling with same prefix", bob, "/projects-old/a.txt", PermRead, false},
+		{"trailing slash in rule", bob, "/inbox/a.txt", PermWrite, true},
+		{"write does not imply read", bob, "/inbox/a.txt", PermRead, false},
+		{"no matching rule", bob, "/other.txt", PermRead, false},
+		{"root not covered", bob, "/", PermRead, false},
+		{"dot segments cleaned", bob, "/projects/shared/../secret.txt", PermWrite, false},
+		{"escape cleaned to root", bob, "/../projects/a.txt", PermRead, true},
+		{"relative path", bob, "projects/shared/a.txt", PermDelete, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Allowed(tt.user, tt.path, tt.perm); got != tt.want {
+				t.Errorf("Allowed(%q, %s) = %v, want %v", tt.path, tt.perm, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllowedTree(t *testing.T) {
+	fsys := storage.NewMemory()
+	for _, name := range []string{"projects/a.txt", "projects/locked/b.txt", "other/locked/c.txt"} {
+		fsys.MkdirAll(path.Dir(name), 0755)
+		if err := storage.WriteFile(fsys, name, []byte("x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bob := types.User{Username: "bob", Role: "user", Rules: []types.ACLRule{
+		{Path: "/", Permissions: []string{PermRead, PermWrite, PermDelete}},
+		{Path: "/projects/locked", Permissions: []string{PermRead}},
+		{Path: "/projects/missing", Permissions: []string{PermRead}},
+		{Path: "/inbox/locked", Permissions: []string{PermRead}},
+	}}
+	admin := types.User{Username: "root", Role: "admin", Rules: bob.Rules}
+
+	tests := []struct {
+		name string
+		user types.User
+		path string
+		src  string
+		perm string
+		want bool
+	}{
+		{"protected folder inside", bob, "projects", "projects", PermDelete, false},
+		{"protected folder itself", bob, "projects/locked", "projects/locked", PermDelete, false},
+		{"granted inside", bob, "projects", "projects", PermRead, true},
+		{"file next to it", bob, "projects/a.txt", "projects/a.txt", PermDelete, true},
+		{"whole root", bob, ".", ".", PermDelete, false},
+		{"rule for a missing path", bob, "other", "other", PermDelete, true},
+		{"moved onto a protected path", bob, "inbox", "other", PermWrite, false},
+		{"moved elsewhere", bob, "inbox", "projects/locked", PermWrite, true},
+		{"admin ignores rules", admin, "projects", "projects", PermDelete, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := AllowedTree(tt.user, fsys, tt.path, tt.src, tt.perm); got != tt.want {
+				t.Errorf("AllowedTree(%q, %q, %s) = %v, want %v", tt.path, tt.src, tt.perm, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCanSee(t *testing.T) {
+	bob := types.User{Username: "bob", Role: "user", Rules: []types.ACLRule{
+		{Path: "/projects/shared", Permissions: []string{PermRead}},
+		{Path: "/inbox", Permissions: []string{PermWrite}},
+	}}
+
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/", true},                      // Parent of a readable folder
+		{"/projects", true},              // Parent of a readable folder
+		{"/projects/shared/a.txt", true}, // Readable
+		{"/projects/other", false},
+		{"/inbox", false}, // Write-only
+		{"/proj", false},
+	}
+	for _, tt := range tests {
+		if got := CanSee(bob, tt.path); got != tt.want {
+			t.Errorf("CanSee(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestValidateRules(t *testing.T) {
+	rules, err := ValidateRules([]types.ACLRule{{Path: "docs/../shared/", Permissions: []string{PermRead}}})
+	if err != nil || len(rules) != 1 || rules[0].Path != "/shared" {
+		t.Errorf("ValidateRules = %v, %v; want the path cleaned to /shared", rules, err)
+	}
+	for _, bad := range []types.ACLRule{
+		{Path: "", Permissions: []string{PermRead}},
+		{Path: "/docs", Permissions: []string{"admin"}},
+		{Path: "/docs", Permissions: []string{"share"}},
+	} {
+		if _, err := ValidateRules([]types.ACLRule{bad}); err != ErrInvalidRule {
+			t.Errorf("ValidateRules(%v) = %v, want %v", bad, err, ErrInvalidRule)
+		}
+	}
+}
@@ -1,25 +1,32 @@
 package auth
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"GoFiles/internal/config"
+	"GoFiles/internal/ratelimit"
+	"GoFiles/internal/seclog"
+	"GoFiles/internal/security"
+	"GoFiles/internal/session"
+	"GoFiles/internal/storage"
+	"GoFiles/internal/tokens"
 	"GoFiles/internal/types"
+	"GoFiles/internal/users"
 	"GoFiles/internal/utils"
-
-	"github.com/google/uuid"
 )
 
-var sessions = map[string]string{}
-
 // HandleSystemStatus tells the Frontend if we need Setup or Login
 func HandleSystemStatus(w http.ResponseWriter, r *http.Request) {
-	utils.EnableCors(&w)
 	w.Header().Set("Content-Type", "application/json")
-	if config.IsConfigured {
+	if config.IsConfigured() {
 		w.Write([]byte(`{"status": "ready"}`)) // Show Login Screen
 	} else {
 		w.Write([]byte(`{"status": "setup_required"}`)) // Show Setup Screen
@@ -28,12 +35,19 @@ func HandleSystemStatus(w http.ResponseWriter, r *http.Request) {
 
 // HandleSetup is the "First Run" wizard
 func HandleSetup(w http.ResponseWriter, r *http.Request) {
-	utils.EnableCors(&w)
 	if r.Method != http.MethodPost {
 		return
 	}
 
-	if config.IsConfigured {
+	ip := utils.ClientIP(r)
+	if wait := loginAttempts.Blocked("ip:" + ip); wait > 0 {
+		tooManyAttempts(w, wait)
+		return
+	}
+
+	if config.IsConfigured() {
+		loginAttempts.Fail("ip:" + ip)
+		seclog.Log("setup_rejected", "ip", ip)
 		http.Error(w, "System is already configured", http.StatusForbidden)
 		return
 	}
@@ -49,29 +63,36 @@ func HandleSetup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Save to gofiles.json
-	if err := config.SaveConfig(req.Username, req.Password); err != nil {
-		http.Error(w, "Failed to save config", http.StatusInternalServerError)
+	// Create the first account as admin (saved to gofiles.json)
+	if _, err := users.Setup(req.Username, req.Password); err != nil {
+		switch err {
+		case users.ErrAlreadySetup:
+			http.Error(w, "System is already configured", http.StatusForbidden)
+		case users.ErrInvalidName:
+			http.Error(w, "Invalid username", http.StatusBadRequest)
+		default:
+			http.Error(w, "Failed to save config", http.StatusInternalServerError)
+		}
 		return
 	}
 
-	config.IsConfigured = true // Switch to Normal Mode
-
 	// Auto-login the user
-	createSession(w, req.Username)
+	if err := createSession(w, r, req.Username); err != nil {
+		http.Error(w, "Failed to create session", http.StatusInternalServerError)
+		return
+	}
 
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(`{"message": "Setup complete"}`))
 }
 
 func HandleLogin(w http.ResponseWriter, r *http.Request) {
-	utils.EnableCors(&w)
 	if r.Method != http.MethodPost {
 		return
 	}
 
 	// If setup isn't done, we can't login!
-	if !config.IsConfigured {
+	if !config.IsConfigured() {
 		http.Error(w, "Setup required first", http.StatusLocked)
 		return
 	}
@@ -82,77 +103,374 @@ func HandleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check credentials against Loaded Config
-	if req.Username == config.AppConfig.Username && req.Password == config.AppConfig.Password {
-		createSession(w, req.Username)
+	// Throttle guessing, per client and per targeted account
+	ip := utils.ClientIP(r)
+	keys := []string{"ip:" + ip, "user:" + req.Username}
+	if wait := loginAttempts.Blocked(keys...); wait > 0 {
+		seclog.Log("login_blocked", "ip", ip, "user", req.Username)
+		tooManyAttempts(w, wait)
+		return
+	}
+
+	// Check credentials against the user store. The account's lockout is only
+	// lifted once the whole login succeeds, so knowing the password doesn't
+	// give unlimited tries at the code.
+	if user, ok := users.Authenticate(req.Username, req.Password); ok {
+		// Second step required: hand out a short-lived challenge instead of a session
+		if users.HasTwoFactor(user) {
+			challenge, err := createChallenge(user.Username)
+			if err != nil {
+				http.Error(w, "Failed to create session", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"two_factor_required": true,
+				"challenge":           challenge,
+			})
+			return
+		}
+
+		loginAttempts.Reset("user:" + req.Username)
+		if err := createSession(w, r, req.Username); err != nil {
+			http.Error(w, "Failed to create session", http.StatusInternalServerError)
+			return
+		}
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte(`{"message": "Login successful"}`))
 	} else {
+		lockout := loginAttempts.Fail(keys...)
+		seclog.Log("login_failed", "ip", ip, "user", req.Username, "lockout", lockout.String())
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 	}
 }
 
+// HandleLoginTwoFactor completes a login with a TOTP or recovery code
+func HandleLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		return
+	}
+
+	var req types.LoginTwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid Request", http.StatusBadRequest)
+		return
+	}
+
+	username, ok := useChallenge(req.Challenge)
+	if !ok {
+		http.Error(w, "Login expired, please sign in again", http.StatusUnauthorized)
+		return
+	}
+
+	ip := utils.ClientIP(r)
+	keys := []string{"ip:" + ip, "user:" + username}
+	if wait := loginAttempts.Blocked(keys...); wait > 0 {
+		seclog.Log("login_blocked", "ip", ip, "user", username)
+		tooManyAttempts(w, wait)
+		return
+	}
+	if !users.VerifySecondFactor(username, req.Code) {
+		lockout := loginAttempts.Fail(keys...)
+		seclog.Log("two_factor_failed", "ip", ip, "user", username, "lockout", lockout.String())
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+	finishChallenge(req.Challenge)
+	loginAttempts.Reset("user:" + username)
+
+	if err := createSession(w, r, username); err != nil {
+		http.Error(w, "Failed to create session", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"message": "Login successful"}`))
+}
+
 func HandleLogout(w http.ResponseWriter, r *http.Request) {
-	utils.EnableCors(&w)
 	c, err := r.Cookie("session_token")
 	if err == nil {
-		delete(sessions, c.Value)
+		session.Destroy(c.Value)
 	}
-	http.SetCookie(w, &http.Cookie{
-		Name: "session_token", Value: "", Expires: time.Now().Add(-1 * time.Hour), HttpOnly: true, Path: "/",
-	})
+	http.SetCookie(w, sessionCookie("", time.Now().Add(-1*time.Hour)))
 	w.WriteHeader(http.StatusOK)
 }
 
 func HandleCheckAuth(w http.ResponseWriter, r *http.Request) {
-	utils.EnableCors(&w)
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(`{"authenticated": true}`))
+	user := CurrentUser(r)
+
+	// Read-only status, so the UI can disable actions that would fail
+	fsys := users.Files(user)
+	readOnlyPaths := []string{}
+	for _, root := range storage.MountRoots(fsys) {
+		if root != "." && storage.IsReadOnly(fsys, root) {
+			readOnlyPaths = append(readOnlyPaths, "/"+root)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"authenticated":   true,
+		"username":        user.Username,
+		"role":            user.Role,
+		"two_factor":      users.HasTwoFactor(user),
+		"read_only":       storage.IsReadOnly(fsys, "."),
+		"read_only_paths": readOnlyPaths, // Read-only mounts when only some are
+	})
+}
+
+// CurrentUser returns the user resolved by AuthMiddleware
+func CurrentUser(r *http.Request) types.User {
+	user, _ := r.Context().Value(userContextKey).(types.User)
+	return user
+}
+
+// CurrentSessionID returns the ID of the session used for this request
+func CurrentSessionID(r *http.Request) string {
+	id, _ := r.Context().Value(sessionContextKey).(string)
+	return id
+}
+
+// CurrentScope returns the API token scope of this request,
+// or "" when the caller is logged in with a session cookie
+func CurrentScope(r *http.Request) string {
+	scope, _ := r.Context().Value(scopeContextKey).(string)
+	return scope
 }
 
+type contextKey string
+
+const userContextKey contextKey = "user"
+const sessionContextKey contextKey = "session"
+const scopeContextKey contextKey = "scope"
+
 // --- HELPER ---
-func createSession(w http.ResponseWriter, username string) {
-	token := uuid.New().String()
-	sessions[token] = username
-	http.SetCookie(w, &http.Cookie{
-		Name: "session_token", Value: token, Expires: time.Now().Add(24 * time.Hour), HttpOnly: true, Path: "/",
-	})
+// createSession issues a session cookie. The cookie lives as long as the
+// absolute session lifetime; idle expiry is enforced server-side.
+func createSession(w http.ResponseWriter, r *http.Request, username string) error {
+	token, s, err := session.Create(username, utils.ClientIP(r), r.UserAgent())
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, sessionCookie(token, s.CreatedAt.Add(config.SessionMaxLifetime)))
+	return nil
+}
+
+// sessionCookie builds the session_token cookie. Over HTTPS it is marked
+// Secure so it never leaks over plain HTTP, and SameSite=Strict.
+func sessionCookie(value string, expires time.Time) *http.Cookie {
+	c := &http.Cookie{Name: "session_token", Value: value, Expires: expires, HttpOnly: true, Path: "/"}
+	if config.TLSEnabled() {
+		c.Secure = true
+		c.SameSite = http.SameSiteStrictMode
+	}
+	return c
+}
+
+// --- BRUTE-FORCE PROTECTION ---
+
+var loginAttempts *ratelimit.Attempts
+
+// apiLimiter caps requests to the protected API (nil when disabled)
+var apiLimiter *ratelimit.Bucket
+
+// Init sets up brute-force protection and rate limiting from the loaded settings
+func Init() {
+	loginAttempts = ratelimit.NewAttempts(config.LoginFreeAttempts, config.LoginBaseLockout, config.LoginMaxLockout)
+	if config.APIRateLimit > 0 {
+		apiLimiter = ratelimit.NewBucket(config.APIRateLimit, config.APIRateBurst)
+	}
+}
+
+// Recheck runs check, which verifies the signed-in user's password or code
+// again before a sensitive change (e.g. turning off 2FA), throttled like a
+// login. Returns true if check passed; otherwise the response is written:
+// 429 while the client is locked out, 403 with check's error if it failed.
+func Recheck(w http.ResponseWriter, r *http.Request, check func() error) bool {
+	ip := utils.ClientIP(r)
+	username := CurrentUser(r).Username
+	keys := []string{"ip:" + ip, "user:" + username}
+	if wait := loginAttempts.Blocked(keys...); wait > 0 {
+		seclog.Log("login_blocked", "ip", ip, "user", username)
+		tooManyAttempts(w, wait)
+		return false
+	}
+	if err := check(); err != nil {
+		lockout := loginAttempts.Fail(keys...)
+		seclog.Log("recheck_failed", "ip", ip, "user", username, "lockout", lockout.String(), "path", r.URL.Path)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// tooManyAttempts rejects a locked-out client and tells it when to retry
+func tooManyAttempts(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, "Too many failed attempts, try again later", http.StatusTooManyRequests)
+}
+
+// --- TWO-FACTOR CHALLENGES ---
+// A challenge is a password-verified login waiting for its second factor
+
+const challengeTTL = 5 * time.Minute
+const maxChallengeAttempts = 5
+
+type challenge struct {
+	username  string
+	expiresAt time.Time
+	attempts  int
+}
+
+var challenges = map[string]*challenge{}
+var challengesMu sync.Mutex
+
+func createChallenge(username string) (string, error) {
+	token, err := security.RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	challengesMu.Lock()
+	defer challengesMu.Unlock()
+	now := time.Now()
+	for t, c := range challenges {
+		if now.After(c.expiresAt) {
+			delete(challenges, t)
+		}
+	}
+	challenges[token] = &challenge{username: username, expiresAt: now.Add(challengeTTL)}
+	return token, nil
+}
+
+// useChallenge counts an attempt against a challenge and returns its user.
+// Challenges are dropped once expired or out of attempts.
+func useChallenge(token string) (string, bool) {
+	challengesMu.Lock()
+	defer challengesMu.Unlock()
+	c, ok := challenges[token]
+	if !ok {
+		return "", false
+	}
+	c.attempts++
+	if time.Now().After(c.expiresAt) || c.attempts > maxChallengeAttempts {
+		delete(challenges, token)
+		return "", false
+	}
+	return c.username, true
+}
+
+func finishChallenge(token string) {
+	challengesMu.Lock()
+	defer challengesMu.Unlock()
+	delete(challenges, token)
+}
+
+// authenticate resolves the caller from an "Authorization: Bearer" API token
+// or the session cookie. Returns http.StatusOK on success.
+func authenticate(r *http.Request) (username, sessionID, scope string, status int) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		secret, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok {
+			return "", "", "", http.StatusUnauthorized
+		}
+		token, valid := tokens.Validate(strings.TrimSpace(secret))
+		if !valid {
+			return "", "", "", http.StatusUnauthorized
+		}
+		return token.Username, "", token.Scope, http.StatusOK
+	}
+
+	c, err := r.Cookie("session_token")
+	if err != nil {
+		return "", "", "", http.StatusUnauthorized
+	}
+	s, valid := session.Validate(c.Value)
+	if !valid {
+		return "", "", "", http.StatusUnauthorized
+	}
+	return s.Username, s.ID, "", http.StatusOK
 }
 
 // --- MIDDLEWARE ---
 func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		utils.EnableCors(&w)
-		if r.Method == http.MethodOptions {
-			next(w, r)
+		// 1. If Setup is NOT done, block everything except setup/status endpoints
+		if !config.IsConfigured() {
+			http.Error(w, "Setup Required", http.StatusLocked) // 423 Locked
 			return
 		}
 
-		// 1. If Setup is NOT done, block everything except setup/status endpoints
-		if !config.IsConfigured {
-			http.Error(w, "Setup Required", http.StatusLocked) // 423 Locked
+		// 2. Protect small hardware from request floods
+		if apiLimiter != nil && !apiLimiter.Allow() {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
 			return
 		}
 
-		// 2. Normal Auth Check
-		c, err := r.Cookie("session_token")
-		if err != nil {
-			if err == http.ErrNoCookie {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
+		// 3. Authenticate with an API token or the session cookie
+		ip := utils.ClientIP(r)
+		if wait := loginAttempts.Blocked("ip:" + ip); wait > 0 {
+			tooManyAttempts(w, wait)
+			return
+		}
+		username, sessionID, scope, status := authenticate(r)
+		if status != http.StatusOK {
+			// Guessing API tokens counts like guessing passwords
+			if r.Header.Get("Authorization") != "" {
+				loginAttempts.Fail("ip:" + ip)
+				seclog.Log("token_rejected", "ip", ip, "path", r.URL.Path)
 			}
-			http.Error(w, "Bad Request", http.StatusBadRequest)
+			http.Error(w, http.StatusText(status), status)
 			return
 		}
 
-		sessionToken := c.Value
-		user, exists := sessions[sessionToken]
-		if !exists {
+		// 4. Resolve the account (it may have been deleted since login)
+		user, found := users.Get(username)
+		if !found {
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
 
-		fmt.Printf("User %s accessed %s\n", user, r.URL.Path)
-		next(w, r)
+		// 5. Read-only tokens can't change anything
+		if scope == tokens.ScopeRead && r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Token scope does not allow this action", http.StatusForbidden)
+			return
+		}
+
+		fmt.Printf("User %s accessed %s\n", user.Username, r.URL.Path)
+		ctx := context.WithValue(r.Context(), userContextKey, user)
+		ctx = context.WithValue(ctx, sessionContextKey, sessionID)
+		ctx = context.WithValue(ctx, scopeContextKey, scope)
+		next(w, r.WithContext(ctx))
 	}
 }
+
+// CredentialsMiddleware guards endpoints that add ways to log in (API
+// tokens, SSH keys, two-factor settings). Only sessions and admin tokens
+// may use them, so a leaked token can't leave new credentials behind that
+// outlive its revocation.
+func CredentialsMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if scope := CurrentScope(r); scope != "" && scope != tokens.ScopeAdmin {
+			http.Error(w, "API tokens can't change login credentials, sign in instead", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	})
+}
+
+// AdminMiddleware only lets authenticated admins through
+func AdminMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if CurrentUser(r).Role != users.RoleAdmin {
+			http.Error(w, "Admin access required", http.StatusForbidden)
+			return
+		}
+		if scope := CurrentScope(r); scope != "" && scope != tokens.ScopeAdmin {
+			http.Error(w, "Token scope does not allow this action", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	})
+}
@@ -0,0 +1,151 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"GoFiles/internal/config"
+	"GoFiles/internal/session"
+	"GoFiles/internal/tokens"
+	"GoFiles/internal/totp"
+	"GoFiles/internal/types"
+	"GoFiles/internal/users"
+)
+
+// setup starts a test with no accounts and two free login attempts
+func setup(t *testing.T) {
+	t.Chdir(t.TempDir()) // The config and key files live in the working directory
+	config.AppConfig = types.ConfigFile{}
+	saved := config.LoginFreeAttempts
+	config.LoginFreeAttempts = 2
+	t.Cleanup(func() { config.LoginFreeAttempts = saved })
+	Init()
+}
+
+// post sends a JSON body to handler from the client at ip
+func post(handler http.HandlerFunc, ip string, body any) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(body)
+	r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data))
+	r.RemoteAddr = ip + ":1234"
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+// TestLoginLockoutCoversSecondFactor checks that knowing the password
+// doesn't lift the account's lockout, so wrong codes add up even when every
+// attempt comes from another address
+func TestLoginLockoutCoversSecondFactor(t *testing.T) {
+	setup(t)
+	if _, err := users.Setup("alice", "password1"); err != nil {
+		t.Fatal(err)
+	}
+	enroll, err := users.EnrollTOTP("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ := totp.CodeAt(enroll.Secret, totp.Step(time.Now()))
+	if _, err := users.ConfirmTOTP("alice", code); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; ; i++ {
+		ip := fmt.Sprintf("192.0.2.%d", i+1)
+		w := post(HandleLogin, ip, types.LoginRequest{Username: "alice", Password: "password1"})
+		if w.Code == http.StatusTooManyRequests {
+			if i <= config.LoginFreeAttempts {
+				t.Fatalf("locked out after %d wrong codes", i)
+			}
+			return
+		}
+		if i > config.LoginFreeAttempts {
+			t.Fatalf("still not locked out after %d wrong codes", i)
+		}
+		var resp struct{ Challenge string }
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || resp.Challenge == "" {
+			t.Fatalf("login %d: status %d, no challenge", i, w.Code)
+		}
+		w = post(HandleLoginTwoFactor, ip, types.LoginTwoFactorRequest{Challenge: resp.Challenge, Code: "000000"})
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("wrong code: status %d, want %d", w.Code, http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestRecheck(t *testing.T) {
+	setup(t)
+	r := httptest.NewRequest(http.MethodPost, "/api/2fa/disable", nil)
+	r = r.WithContext(context.WithValue(r.Context(), userContextKey, types.User{Username: "alice"}))
+	errWrong := errors.New("wrong password")
+
+	tests := []struct {
+		name   string
+		err    error // Returned by the check
+		ok     bool
+		status int
+		called bool
+	}{
+		{"correct", nil, true, http.StatusOK, true},
+		{"first failure", errWrong, false, http.StatusForbidden, true},
+		{"second failure", errWrong, false, http.StatusForbidden, true},
+		{"third failure", errWrong, false, http.StatusForbidden, true},
+		{"locked out", nil, false, http.StatusTooManyRequests, false},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		called := false
+		ok := Recheck(w, r, func() error { called = true; return tt.err })
+		if ok != tt.ok || w.Code != tt.status || called != tt.called {
+			t.Errorf("%s: ok %v, status %d, called %v; want %v, %d, %v", tt.name, ok, w.Code, called, tt.ok, tt.status, tt.called)
+		}
+	}
+}
+
+// TestCredentialsMiddleware checks that only sessions and admin tokens can
+// add login credentials
+func TestCredentialsMiddleware(t *testing.T) {
+	setup(t)
+	if err := tokens.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.Setup("admin", "password1"); err != nil {
+		t.Fatal(err)
+	}
+	cookie, _, err := session.Create("admin", "127.0.0.1", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := map[string]string{}
+	for _, scope := range []string{tokens.ScopeRead, tokens.ScopeWrite, tokens.ScopeAdmin} {
+		if secret[scope], _, err = tokens.Create("admin", scope, scope, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		auth   func(r *http.Request)
+		status int
+	}{
+		{"session", func(r *http.Request) { r.AddCookie(&http.Cookie{Name: "session_token", Value: cookie}) }, http.StatusOK},
+		{"read token", func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+secret[tokens.ScopeRead]) }, http.StatusForbidden},
+		{"write token", func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+secret[tokens.ScopeWrite]) }, http.StatusForbidden},
+		{"admin token", func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+secret[tokens.ScopeAdmin]) }, http.StatusOK},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/api/tokens/create", nil)
+		tt.auth(r)
+		w := httptest.NewRecorder()
+		CredentialsMiddleware(func(w http.ResponseWriter, r *http.Request) {})(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.status)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"GoFiles/internal/config"
+	"GoFiles/internal/seclog"
+	"GoFiles/internal/tokens"
+	"GoFiles/internal/types"
+	"GoFiles/internal/users"
+	"GoFiles/internal/utils"
+)
+
+// basicCacheTTL is how long a checked Basic password is trusted. Clients like
+// WebDAV send it with every request and bcrypt is deliberately slow.
+const basicCacheTTL = 5 * time.Minute
+
+type basicCacheEntry struct {
+	passwordHash string // Changing the password invalidates the entry
+	expires      time.Time
+}
+
+var basicCache = map[[32]byte]basicCacheEntry{}
+var basicCacheMu sync.Mutex
+
+// BasicMiddleware protects endpoints for clients that can only send HTTP
+// Basic credentials (WebDAV). The password is the account password, or an
+// API token; accounts with two-factor authentication must use a token.
+// Account passwords are only accepted over HTTPS, or from this machine
+// (e.g. a reverse proxy terminating TLS), since Basic sends them with every
+// request.
+func BasicMiddleware(realm string, safeMethods []string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 1. Nothing to log in to before setup
+		if !config.IsConfigured() {
+			http.Error(w, "Setup Required", http.StatusLocked)
+			return
+		}
+		if apiLimiter != nil && !apiLimiter.Allow() {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			return
+		}
+
+		// 2. Ask for credentials
+		username, password, ok := r.BasicAuth()
+		if !ok {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm="%s", charset="UTF-8"`, realm))
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// 3. Check them, throttled like the login form
+		if r.TLS == nil && !strings.HasPrefix(password, tokens.Prefix) && !isLoopback(utils.ClientIP(r)) {
+			http.Error(w, "Passwords are only accepted over HTTPS; use an API token instead", http.StatusForbidden)
+			return
+		}
+		user, scope, wait, ok := CheckCredentials(utils.ClientIP(r), username, password, "basic")
+		if wait > 0 {
+			tooManyAttempts(w, wait)
+			return
+		}
+		if !ok {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm="%s", charset="UTF-8"`, realm))
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// 4. Read-only tokens can't change anything
+		if scope == tokens.ScopeRead && !contains(safeMethods, r.Method) {
+			http.Error(w, "Token scope does not allow this action", http.StatusForbidden)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), userContextKey, user)
+		ctx = context.WithValue(ctx, scopeContextKey, scope)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// CheckCredentials verifies a username with a password or an API token for
+// clients that can't use the login form (WebDAV, SFTP). Guessing is throttled
+// per IP and username; wait is set while the client is locked out. via names
+// the protocol in the security log.
+func CheckCredentials(ip, username, password, via string) (user types.User, scope string, wait time.Duration, ok bool) {
+	keys := []string{"ip:" + ip, "user:" + username}
+	if wait := loginAttempts.Blocked(keys...); wait > 0 {
+		seclog.Log("login_blocked", "ip", ip, "user", username)
+		return types.User{}, "", wait, false
+	}
+	user, scope, ok = checkBasic(username, password)
+	if !ok {
+		lockout := loginAttempts.Fail(keys...)
+		seclog.Log("login_failed", "ip", ip, "user", username, "lockout", lockout.String(), "via", via)
+	}
+	return user, scope, 0, ok
+}
+
+// checkBasic verifies a username with a password or an API token
+func checkBasic(username, password string) (types.User, string, bool) {
+	if strings.HasPrefix(password, tokens.Prefix) {
+		token, valid := tokens.Validate(password)
+		if !valid || token.Username != username {
+			return types.User{}, "", false
+		}
+		user, found := users.Get(username)
+		return user, token.Scope, found
+	}
+
+	// A cached check is only valid while the stored password hash is unchanged
+	key := sha256.Sum256([]byte(username + "\x00" + password))
+	if user, found := users.Get(username); found && !users.HasTwoFactor(user) {
+		basicCacheMu.Lock()
+		entry, cached := basicCache[key]
+		basicCacheMu.Unlock()
+		if cached && entry.passwordHash == user.Password && time.Now().Before(entry.expires) {
+			return user, "", true
+		}
+	}
+
+	user, ok := users.Authenticate(username, password)
+	if !ok || users.HasTwoFactor(user) {
+		return types.User{}, "", false // A password alone is not enough with 2FA
+	}
+	basicCacheMu.Lock()
+	for k, e := range basicCache {
+		if time.Now().After(e.expires) {
+			delete(basicCache, k)
+		}
+	}
+	basicCache[key] = basicCacheEntry{passwordHash: user.Password, expires: time.Now().Add(basicCacheTTL)}
+	basicCacheMu.Unlock()
+	return user, "", true
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// isLoopback reports whether ip is this machine
+func isLoopback(ip string) bool {
+	parsed := net.ParseIP(ip)
+	return parsed != nil && parsed.IsLoopback()
+}
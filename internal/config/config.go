@@ -2,46 +2,162 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"GoFiles/internal/security"
 	"GoFiles/internal/types"
 )
 
-// Global Config
-const RootFolder = "."
-const TrashFolder = ".trash"
-const ThumbsFolder = ".thumbs" // NEW: Hidden folder for thumbnails
-const TrashRetention = 30 * 24 * time.Hour
-const ConfigFileName = "gofiles.json"
-
 // Runtime State
 var AppConfig types.ConfigFile
-var IsConfigured = false
+
+// configured is set once the first account exists. It's checked on every
+// request, so it's kept outside AppConfig and its lock.
+var configured atomic.Bool
+
+// configMu guards AppConfig and the config file on disk
+var configMu sync.RWMutex
+
+// homes lists the home folders of all accounts. It's kept in step with
+// AppConfig so that storage can check it on every path without the lock.
+var homes atomic.Pointer[[]string]
 
 // InitConfig tries to load gofiles.json
 func InitConfig() {
 	file, err := os.Open(ConfigFileName)
 	if err != nil {
-		IsConfigured = false
+		configured.Store(false)
 		return
 	}
 	defer file.Close()
 	json.NewDecoder(file).Decode(&AppConfig)
-	IsConfigured = true
+
+	if migrateConfig() {
+		if err := writeConfig(); err != nil {
+			fmt.Println("⚠️  Failed to upgrade config file:", err)
+		} else {
+			fmt.Println("🔒 Upgraded config file to the current format.")
+		}
+	}
+	configured.Store(len(AppConfig.Users) > 0)
+	updateHomes()
+}
+
+// migrateConfig upgrades configs written by older versions in memory.
+// Returns true if anything changed and the file should be rewritten.
+func migrateConfig() bool {
+	changed := false
+
+	// 1. Single-user configs become the first (admin) account
+	if AppConfig.Username != "" {
+		AppConfig.Users = append(AppConfig.Users, types.User{
+			Username:  AppConfig.Username,
+			Password:  AppConfig.Password,
+			Role:      "admin",
+			CreatedAt: AppConfig.CreatedAt,
+		})
+		AppConfig.Username = ""
+		AppConfig.Password = ""
+		changed = true
+	}
+
+	// 2. Hash any password still stored in plaintext
+	for i, u := range AppConfig.Users {
+		if security.IsHashed(u.Password) {
+			continue
+		}
+		hash, err := security.HashPassword(u.Password)
+		if err != nil {
+			fmt.Println("⚠️  Failed to hash stored password:", err)
+			continue
+		}
+		AppConfig.Users[i].Password = hash
+		changed = true
+	}
+	return changed
+}
+
+// ReadConfig gives fn read-only access to AppConfig under the config lock
+func ReadConfig(fn func(cfg *types.ConfigFile)) {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	fn(&AppConfig)
+}
+
+// UpdateConfig lets fn modify AppConfig under the config lock and saves
+// the result to gofiles.json. Nothing is saved if fn returns an error.
+func UpdateConfig(fn func(cfg *types.ConfigFile) error) error {
+	configMu.Lock()
+	defer configMu.Unlock()
+	if err := fn(&AppConfig); err != nil {
+		return err
+	}
+	if AppConfig.CreatedAt.IsZero() {
+		AppConfig.CreatedAt = time.Now()
+	}
+	configured.Store(len(AppConfig.Users) > 0)
+	updateHomes()
+	return writeConfig()
 }
 
-// SaveConfig saves the configuration to gofiles.json
-func SaveConfig(username, password string) error {
-	AppConfig = types.ConfigFile{Username: username, Password: password, CreatedAt: time.Now()}
-	file, err := os.Create(ConfigFileName)
+// IsConfigured reports whether setup has created the first account
+func IsConfigured() bool {
+	return configured.Load()
+}
+
+// Homes returns the home folders set for accounts, relative to the root
+func Homes() []string {
+	if list := homes.Load(); list != nil {
+		return *list
+	}
+	return nil
+}
+
+func updateHomes() {
+	var list []string
+	for _, u := range AppConfig.Users {
+		if u.Home != "" {
+			list = append(list, u.Home)
+		}
+	}
+	homes.Store(&list)
+}
+
+// writeConfig persists AppConfig, readable by the owner only
+func writeConfig() error {
+	data, err := json.MarshalIndent(AppConfig, "", "  ")
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", "  ")
-	return encoder.Encode(AppConfig)
+	return WriteFileAtomic(ConfigFileName, append(data, '\n'))
+}
+
+// WriteFileAtomic replaces the file name with data, readable by the owner
+// only. The data is written to a temporary file that replaces name once
+// flushed to disk, so a crash or a full disk never leaves it truncated.
+func WriteFileAtomic(name string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".gofiles-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // Fails harmlessly once renamed
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
 }
 
 // GetEnv helper to get env variables
@@ -0,0 +1,47 @@
+package config
+
+import (
+	"sync"
+	"testing"
+
+	"GoFiles/internal/types"
+)
+
+// TestIsConfigured checks the flag follows the accounts, and can be read
+// while they change (run with -race)
+func TestIsConfigured(t *testing.T) {
+	t.Chdir(t.TempDir())
+	AppConfig = types.ConfigFile{}
+	InitConfig()
+	if IsConfigured() {
+		t.Fatal("configured without a config file")
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			IsConfigured()
+		}
+	}()
+	err := UpdateConfig(func(cfg *types.ConfigFile) error {
+		cfg.Users = append(cfg.Users, types.User{Username: "admin", Role: "admin"})
+		return nil
+	})
+	wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsConfigured() {
+		t.Error("not configured after adding an account")
+	}
+
+	// Loaded from the file on the next start
+	AppConfig = types.ConfigFile{}
+	configured.Store(false)
+	InitConfig()
+	if !IsConfigured() {
+		t.Error("not configured after loading the config file")
+	}
+}
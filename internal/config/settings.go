@@ -0,0 +1,574 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Global Config (defaults; overridden by LoadSettings)
+var RootFolder = "."
+var StorageBackend = "local" // "local" (RootFolder on disk), "memory" (lost on restart) or "s3"
+var TrashFolder = ".trash"
+var ThumbsFolder = ".thumbs"   // Hidden folder for thumbnails
+var UploadsFolder = ".uploads" // Hidden folder for unfinished uploads (tus, S3 multipart)
+var TrashRetention = 30 * 24 * time.Hour
+var UploadExpiry = 24 * time.Hour          // Unfinished uploads without activity are removed after this
+var ConfigFileName = "gofiles.json"        // Users and API tokens
+var SecretsKeyFile = "gofiles-secrets.key" // Seals the S3 secret keys of API tokens; generated on first run
+var ListenAddr = ":8080"
+
+// Limits (0 disables each)
+var MaxUploadSize Size = 0           // Largest file that can be uploaded or saved
+var DefaultQuota Size = 0            // Storage quota of users without their own
+var MinFreeSpace = []string{"100MB"} // Kept free on each disk: "1GB", or "1GB,photos=5GB" per mount
+
+// Named mounts ("photos=/mnt/photos"). When set they replace root/storage and
+// the top level of the file tree lists the mounts. See ParseMounts.
+var Mounts = []string{}
+
+// Read-only mode: nothing can be uploaded, changed or deleted
+var ReadOnly = false
+var ReadOnlyMounts = []string{} // Names of mounts that are read-only
+
+// WebDAV at /dav/ (HTTP Basic with the account password over HTTPS or from
+// this machine, or with an API token)
+var WebDAV = false
+
+// SFTP server (same users and files; password, API token or registered public key)
+var SFTPListen = ""                      // e.g. ":2022", empty disables
+var SFTPHostKey = "gofiles-ssh-host-key" // Generated on first run
+
+// S3-compatible API (buckets are the top-level folders, credentials come from API tokens)
+var S3APIListen = "" // e.g. ":9000", empty disables
+
+// S3-compatible object storage (storage = "s3")
+var S3Endpoint = "" // e.g. "https://s3.eu-west-1.amazonaws.com" or "http://localhost:9000"
+var S3Bucket = ""
+var S3Prefix = "" // Keep files below this key prefix, empty uses the whole bucket
+var S3Region = ""
+var S3AccessKey = ""
+var S3SecretKey = ""
+
+// HTTP server limits (0 disables a timeout). Read/write timeouts cover the
+// whole body, so they are off by default to allow large uploads and downloads.
+var ReadHeaderTimeout = 10 * time.Second
+var ReadTimeout = time.Duration(0)
+var WriteTimeout = time.Duration(0)
+var IdleTimeout = 2 * time.Minute
+var MaxHeaderBytes = 1 << 20
+var ShutdownTimeout = 30 * time.Second // How long in-flight requests may take to finish on shutdown
+
+// HTTPS
+var TLSMode = "off" // "off", "files" (tls_cert/tls_key) or "self-signed" (generated on first run)
+var TLSCert = "gofiles-cert.pem"
+var TLSKey = "gofiles-key.pem"
+var HTTPRedirectAddr = "" // e.g. ":80" to redirect plain HTTP to HTTPS, empty disables
+
+// CORS (an empty origin list disables CORS, e.g. when the UI is served from the same origin)
+var CORSOrigins = []string{"http://localhost:5173"} // "*" allows any origin
+var CORSMethods = []string{"GET", "POST", "DELETE", "OPTIONS", "HEAD", "PATCH"}
+var CORSHeaders = []string{"Content-Type", "Authorization", "Tus-Resumable", "Upload-Length", "Upload-Offset", "Upload-Metadata", "If-Match"}
+var CORSMaxAge = 10 * time.Minute // How long browsers may cache a preflight
+
+// Sessions
+var SessionStore = "file" // "file" survives restarts, "memory" does not
+var SessionsFileName = "gofiles-sessions.json"
+var SessionTTL = 24 * time.Hour              // Idle timeout, renewed on activity
+var SessionMaxLifetime = 30 * 24 * time.Hour // Absolute limit, even when active
+
+// Brute-force protection for login/setup
+var LoginFreeAttempts = 5               // Failures allowed before lockouts start
+var LoginBaseLockout = 30 * time.Second // First lockout, doubled on every further failure
+var LoginMaxLockout = 15 * time.Minute  // Longest lockout
+var SecurityLogFile = "gofiles-security.log"
+
+// Global rate limit for the protected API (requests per second, 0 disables)
+var APIRateLimit = 0.0
+var APIRateBurst = 50
+
+// SettingsFileName is the optional settings file (JSON), set with -config or GOFILES_CONFIG
+var SettingsFileName = "gofiles.config.json"
+
+// StateFiles lists the files the server keeps its own data in. They must
+// never be served, even when they sit inside the root folder.
+func StateFiles() []string {
+	return []string{ConfigFileName, SecretsKeyFile, SecretsKeyFile + ".old", SettingsFileName, SessionsFileName,
+		SecurityLogFile, TLSCert, TLSKey, SFTPHostKey}
+}
+
+// EnvPrefix is prepended to setting names for environment variables (root -> GOFILES_ROOT)
+const EnvPrefix = "GOFILES_"
+
+// setting describes one configurable value. The same name is used in the
+// settings file ("trash_retention"), as a flag (-trash-retention) and as an
+// environment variable (GOFILES_TRASH_RETENTION).
+type setting struct {
+	name  string
+	usage string
+	ptr   interface{} // *string, *[]string, *int, *float64, *bool or *time.Duration
+}
+
+func settings() []setting {
+	return []setting{
+		{"root", "Folder to serve files from", &RootFolder},
+		{"storage", `Where files are kept: "local" (the root folder), "memory" (lost on restart) or "s3"`, &StorageBackend},
+		{"mounts", `Named mounts replacing root/storage, e.g. "photos=/mnt/photos,scratch=memory:,backup=s3://bucket/prefix"`, &Mounts},
+		{"read_only", "Refuse every change to the files", &ReadOnly},
+		{"read_only_mounts", "Mounts that can't be changed (comma separated names)", &ReadOnlyMounts},
+		{"webdav", "Serve the files over WebDAV at /dav/ (passwords need TLS, except from this machine)", &WebDAV},
+		{"sftp_listen", `Address for the SFTP server, e.g. ":2022" (empty disables)`, &SFTPListen},
+		{"sftp_host_key", "SSH host key file for the SFTP server (generated on first run)", &SFTPHostKey},
+		{"s3_api_listen", `Address for the S3-compatible API, e.g. ":9000" (empty disables)`, &S3APIListen},
+		{"s3_endpoint", "S3 endpoint URL (https://... or http://...)", &S3Endpoint},
+		{"s3_bucket", "S3 bucket name", &S3Bucket},
+		{"s3_prefix", "Key prefix inside the bucket", &S3Prefix},
+		{"s3_region", "S3 region (optional for most S3-compatible servers)", &S3Region},
+		{"s3_access_key", "S3 access key ID", &S3AccessKey},
+		{"s3_secret_key", "S3 secret access key", &S3SecretKey},
+		{"listen", "Address to listen on", &ListenAddr},
+		{"read_header_timeout", "Time allowed to read request headers (0 disables)", &ReadHeaderTimeout},
+		{"read_timeout", "Time allowed to read a whole request, including the body (0 disables)", &ReadTimeout},
+		{"write_timeout", "Time allowed to write a whole response (0 disables)", &WriteTimeout},
+		{"idle_timeout", "How long idle keep-alive connections stay open (0 disables)", &IdleTimeout},
+		{"max_header_bytes", "Maximum size of request headers in bytes", &MaxHeaderBytes},
+		{"shutdown_timeout", "How long to wait for in-flight requests on shutdown", &ShutdownTimeout},
+		{"tls", `HTTPS mode: "off", "files" (use tls_cert/tls_key) or "self-signed" (generated and kept in tls_cert/tls_key)`, &TLSMode},
+		{"tls_cert", "Certificate file (PEM)", &TLSCert},
+		{"tls_key", "Private key file (PEM)", &TLSKey},
+		{"http_redirect", `Address redirecting plain HTTP to HTTPS, e.g. ":80" (empty disables)`, &HTTPRedirectAddr},
+		{"cors_origins", "Origins allowed to call the API from a browser (comma separated, empty disables CORS)", &CORSOrigins},
+		{"cors_methods", "Methods allowed in cross-origin requests", &CORSMethods},
+		{"cors_headers", "Request headers allowed in cross-origin requests", &CORSHeaders},
+		{"cors_max_age", "How long browsers may cache a preflight response", &CORSMaxAge},
+		{"trash_folder", "Name of the hidden trash folder", &TrashFolder},
+		{"thumbs_folder", "Name of the hidden thumbnail cache folder", &ThumbsFolder},
+		{"uploads_folder", "Name of the hidden folder for unfinished uploads", &UploadsFolder},
+		{"trash_retention", "How long deleted files stay in the trash", &TrashRetention},
+		{"upload_expiry", "How long unfinished uploads are kept without activity", &UploadExpiry},
+		{"max_upload_size", `Largest file that can be uploaded, e.g. "10GB" (0 = no limit)`, &MaxUploadSize},
+		{"default_quota", `Storage quota of users without their own, e.g. "50GB" (0 = unlimited)`, &DefaultQuota},
+		{"min_free_space", `Free space kept on each disk, e.g. "1GB" or "1GB,photos=5GB" per mount`, &MinFreeSpace},
+		{"users_file", "File storing users and API tokens", &ConfigFileName},
+		{"secrets_key", "Key file sealing the S3 secret keys of API tokens (generated on first run)", &SecretsKeyFile},
+		{"session_store", `Session storage: "file" or "memory"`, &SessionStore},
+		{"sessions_file", "File storing sessions (file store)", &SessionsFileName},
+		{"session_ttl", "Idle time before a session expires", &SessionTTL},
+		{"session_max_lifetime", "Absolute session lifetime", &SessionMaxLifetime},
+		{"login_free_attempts", "Failed logins allowed before lockouts start", &LoginFreeAttempts},
+		{"login_base_lockout", "First lockout after too many failures", &LoginBaseLockout},
+		{"login_max_lockout", "Longest lockout", &LoginMaxLockout},
+		{"security_log", "File receiving security events", &SecurityLogFile},
+		{"api_rate_limit", "Requests per second for the protected API (0 disables)", &APIRateLimit},
+		{"api_rate_burst", "Burst size for the API rate limit", &APIRateBurst},
+	}
+}
+
+// LoadSettings applies, from lowest to highest precedence: built-in defaults,
+// the settings file, GOFILES_* environment variables and command-line flags.
+func LoadSettings(args []string) error {
+	all := settings()
+
+	// 1. Parse flags first (they may point at another settings file), but apply them last
+	fs := flag.NewFlagSet("gofiles", flag.ContinueOnError)
+	configFlag := fs.String("config", "", "Settings file (JSON)")
+	flagValues := map[string]*string{}
+	for _, s := range all {
+		usage := fmt.Sprintf("%s (default %s)", s.usage, format(s.ptr))
+		if _, ok := s.ptr.(*bool); ok {
+			// Allow "-read-only" as well as "-read-only=false"
+			value := new(string)
+			fs.Var(boolFlag{value}, flagName(s.name), usage)
+			flagValues[s.name] = value
+			continue
+		}
+		flagValues[s.name] = fs.String(flagName(s.name), "", usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	// 2. Settings file (only required when chosen explicitly)
+	path, explicit := SettingsFileName, false
+	if env := GetEnv(EnvPrefix+"CONFIG", ""); env != "" {
+		path, explicit = env, true
+	}
+	if *configFlag != "" {
+		path, explicit = *configFlag, true
+	}
+	if err := loadSettingsFile(path, explicit); err != nil {
+		return err
+	}
+	SettingsFileName = path
+
+	// 3. Environment variables (set but empty counts, e.g. GOFILES_CORS_ORIGINS= disables CORS)
+	for _, s := range all {
+		key := EnvPrefix + strings.ToUpper(s.name)
+		if value, exists := os.LookupEnv(key); exists {
+			if err := parseInto(s.ptr, value); err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+		}
+	}
+
+	// 4. Flags that were actually passed
+	var flagErr error
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range all {
+			if f.Name == flagName(s.name) && flagErr == nil {
+				if err := parseInto(s.ptr, *flagValues[s.name]); err != nil {
+					flagErr = fmt.Errorf("-%s: %w", f.Name, err)
+				}
+			}
+		}
+	})
+	if flagErr != nil {
+		return flagErr
+	}
+
+	return validateSettings()
+}
+
+// loadSettingsFile reads the JSON settings file, e.g. {"root": "/srv/files", "listen": ":9000"}
+func loadSettingsFile(path string, required bool) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) && !required {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	known := map[string]interface{}{}
+	for _, s := range settings() {
+		known[s.name] = s.ptr
+	}
+	for key, value := range raw {
+		ptr, ok := known[key]
+		if !ok {
+			return fmt.Errorf("%s: unknown setting %q", path, key)
+		}
+		// Accept both "30s" and 30 style values
+		text := string(value)
+		var str string
+		if json.Unmarshal(value, &str) == nil {
+			text = str
+		}
+		if err := parseInto(ptr, text); err != nil {
+			return fmt.Errorf("%s: %s: %w", path, key, err)
+		}
+	}
+	return nil
+}
+
+// validateSettings rejects values the server can't run with
+func validateSettings() error {
+	info, err := os.Stat(RootFolder)
+	if err != nil || !info.IsDir() {
+		return fmt.Errorf("root %q is not a directory", RootFolder)
+	}
+	if StorageBackend != "local" && StorageBackend != "memory" && StorageBackend != "s3" {
+		return fmt.Errorf(`storage must be "local", "memory" or "s3", got %q`, StorageBackend)
+	}
+	if StorageBackend == "s3" && (S3Endpoint == "" || S3Bucket == "") {
+		return errors.New("s3_endpoint and s3_bucket are required when storage is s3")
+	}
+	mounts, err := ParseMounts()
+	if err != nil {
+		return err
+	}
+	for _, name := range ReadOnlyMounts {
+		found := false
+		for _, mount := range mounts {
+			found = found || mount.Name == name
+		}
+		if !found {
+			return fmt.Errorf("read_only_mounts: no mount named %q", name)
+		}
+	}
+	if _, _, err := net.SplitHostPort(ListenAddr); err != nil {
+		return fmt.Errorf("invalid listen address %q", ListenAddr)
+	}
+	if SFTPListen != "" {
+		if _, _, err := net.SplitHostPort(SFTPListen); err != nil {
+			return fmt.Errorf("invalid sftp_listen address %q", SFTPListen)
+		}
+		if SFTPHostKey == "" {
+			return errors.New("sftp_host_key is required when sftp_listen is set")
+		}
+	}
+	if S3APIListen != "" {
+		if _, _, err := net.SplitHostPort(S3APIListen); err != nil {
+			return fmt.Errorf("invalid s3_api_listen address %q", S3APIListen)
+		}
+	}
+	if MaxUploadSize < 0 || DefaultQuota < 0 {
+		return errors.New("max_upload_size and default_quota can't be negative")
+	}
+	if _, err := parseMinFreeSpace(); err != nil {
+		return err
+	}
+	if TLSMode != "off" && TLSMode != "files" && TLSMode != "self-signed" {
+		return fmt.Errorf(`tls must be "off", "files" or "self-signed", got %q`, TLSMode)
+	}
+	if TLSMode != "off" && (TLSCert == "" || TLSKey == "") {
+		return errors.New("tls_cert and tls_key are required when tls is enabled")
+	}
+	if HTTPRedirectAddr != "" {
+		if TLSMode == "off" {
+			return errors.New("http_redirect requires tls to be enabled")
+		}
+		if _, _, err := net.SplitHostPort(HTTPRedirectAddr); err != nil {
+			return fmt.Errorf("invalid http_redirect address %q", HTTPRedirectAddr)
+		}
+	}
+	if ReadHeaderTimeout < 0 || ReadTimeout < 0 || WriteTimeout < 0 || IdleTimeout < 0 || ShutdownTimeout < 0 {
+		return errors.New("server timeouts must not be negative")
+	}
+	if MaxHeaderBytes < 1024 {
+		return errors.New("max_header_bytes must be at least 1024")
+	}
+	for name, folder := range map[string]string{"trash_folder": TrashFolder, "thumbs_folder": ThumbsFolder, "uploads_folder": UploadsFolder} {
+		if folder == "" || folder != filepath.Base(folder) || folder == "." || folder == ".." {
+			return fmt.Errorf("%s must be a plain folder name, got %q", name, folder)
+		}
+	}
+	if SessionStore != "file" && SessionStore != "memory" {
+		return fmt.Errorf(`session_store must be "file" or "memory", got %q`, SessionStore)
+	}
+	if TrashRetention <= 0 || UploadExpiry <= 0 || SessionTTL <= 0 || SessionMaxLifetime <= 0 {
+		return errors.New("trash_retention, upload_expiry, session_ttl and session_max_lifetime must be positive")
+	}
+	if LoginFreeAttempts < 0 || LoginBaseLockout <= 0 || LoginMaxLockout < LoginBaseLockout {
+		return errors.New("login lockout settings are invalid")
+	}
+	if APIRateLimit < 0 || (APIRateLimit > 0 && APIRateBurst < 1) {
+		return errors.New("api_rate_limit must be >= 0 and api_rate_burst >= 1")
+	}
+	return nil
+}
+
+// parseInto converts text to the type behind ptr
+func parseInto(ptr interface{}, text string) error {
+	text = strings.TrimSpace(text)
+	switch p := ptr.(type) {
+	case *string:
+		*p = text
+	case *[]string:
+		// Either a JSON array (settings file) or a comma separated list
+		var list []string
+		if strings.HasPrefix(text, "[") {
+			if err := json.Unmarshal([]byte(text), &list); err != nil {
+				return fmt.Errorf("expected a list, got %s", text)
+			}
+		} else {
+			list = strings.Split(text, ",")
+		}
+		*p = []string{}
+		for _, item := range list {
+			if item = strings.TrimSpace(item); item != "" {
+				*p = append(*p, item)
+			}
+		}
+	case *int:
+		v, err := strconv.Atoi(text)
+		if err != nil {
+			return fmt.Errorf("expected a whole number, got %q", text)
+		}
+		*p = v
+	case *float64:
+		v, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return fmt.Errorf("expected a number, got %q", text)
+		}
+		*p = v
+	case *bool:
+		v, err := strconv.ParseBool(text)
+		if err != nil {
+			return fmt.Errorf("expected true or false, got %q", text)
+		}
+		*p = v
+	case *time.Duration:
+		v, err := time.ParseDuration(text)
+		if err != nil {
+			return fmt.Errorf(`expected a duration like "30s" or "720h", got %q`, text)
+		}
+		*p = v
+	case *Size:
+		v, err := ParseSize(text)
+		if err != nil {
+			return err
+		}
+		*p = v
+	}
+	return nil
+}
+
+// format prints the current value of a setting (used for flag defaults)
+func format(ptr interface{}) string {
+	switch p := ptr.(type) {
+	case *string:
+		return strconv.Quote(*p)
+	case *[]string:
+		return strconv.Quote(strings.Join(*p, ","))
+	case *int:
+		return strconv.Itoa(*p)
+	case *float64:
+		return strconv.FormatFloat(*p, 'g', -1, 64)
+	case *bool:
+		return strconv.FormatBool(*p)
+	case *time.Duration:
+		return p.String()
+	case *Size:
+		return p.String()
+	}
+	return ""
+}
+
+// Size is a number of bytes, written like "500MB" or "10GB" in settings
+type Size int64
+
+var sizeUnits = []struct {
+	suffix string
+	bytes  int64
+}{{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1}}
+
+// ParseSize reads a size like "1.5GB", "500M" or "1048576" (units are powers of 1024)
+func ParseSize(text string) (Size, error) {
+	number, unit := strings.ToUpper(strings.TrimSpace(text)), int64(1)
+	for _, u := range sizeUnits {
+		if rest, ok := strings.CutSuffix(number, u.suffix); ok {
+			number, unit = strings.TrimSpace(rest), u.bytes
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(number, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf(`expected a size like "500MB" or "10GB", got %q`, text)
+	}
+	return Size(v * float64(unit)), nil
+}
+
+func (s Size) String() string {
+	for _, u := range sizeUnits[:4] {
+		if s >= Size(u.bytes) && int64(s)%u.bytes == 0 {
+			return strconv.FormatInt(int64(s)/u.bytes, 10) + u.suffix
+		}
+	}
+	return strconv.FormatInt(int64(s), 10)
+}
+
+// MinFreeSpaceFor returns the free space to keep on the disk of a mount
+// ("." when there are no mounts)
+func MinFreeSpaceFor(mount string) int64 {
+	sizes, _ := parseMinFreeSpace()
+	if size, ok := sizes[mount]; ok {
+		return int64(size)
+	}
+	return int64(sizes[""])
+}
+
+// parseMinFreeSpace reads min_free_space into sizes by mount name, with the
+// default under ""
+func parseMinFreeSpace() (map[string]Size, error) {
+	sizes := map[string]Size{}
+	for _, entry := range MinFreeSpace {
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			name, value = "", entry
+		}
+		size, err := ParseSize(value)
+		if err != nil {
+			return nil, fmt.Errorf("min_free_space: %w", err)
+		}
+		sizes[strings.TrimSpace(name)] = size
+	}
+	return sizes, nil
+}
+
+// Mount is one entry of the mounts setting
+type Mount struct {
+	Name     string
+	Backend  string // "local", "memory" or "s3"
+	Path     string // Folder on disk (local) or "bucket/prefix" (s3)
+	ReadOnly bool   // Listed in read_only_mounts
+}
+
+// ParseMounts reads the mounts setting. Each entry is "name=target" where the
+// target is a folder on disk, "memory:" or "s3://bucket/prefix" (using the
+// s3_* connection settings).
+func ParseMounts() ([]Mount, error) {
+	var mounts []Mount
+	seen := map[string]bool{}
+	for _, entry := range Mounts {
+		name, target, ok := strings.Cut(entry, "=")
+		name, target = strings.TrimSpace(name), strings.TrimSpace(target)
+		if !ok || target == "" {
+			return nil, fmt.Errorf(`mount %q must look like "name=target"`, entry)
+		}
+		if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") || strings.ContainsAny(name, `/\`) {
+			return nil, fmt.Errorf("mount name %q must be a plain folder name", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("mount %q is defined twice", name)
+		}
+		seen[name] = true
+
+		mount := Mount{Name: name}
+		for _, ro := range ReadOnlyMounts {
+			mount.ReadOnly = mount.ReadOnly || ro == name
+		}
+		switch {
+		case target == "memory:":
+			mount.Backend = "memory"
+		case strings.HasPrefix(target, "s3://"):
+			mount.Backend, mount.Path = "s3", strings.TrimPrefix(target, "s3://")
+			if mount.Path == "" || strings.HasPrefix(mount.Path, "/") {
+				return nil, fmt.Errorf("mount %q: expected s3://bucket or s3://bucket/prefix", name)
+			}
+			if S3Endpoint == "" {
+				return nil, fmt.Errorf("mount %q: s3_endpoint is required for S3 mounts", name)
+			}
+		default:
+			mount.Backend, mount.Path = "local", target
+			if info, err := os.Stat(target); err != nil || !info.IsDir() {
+				return nil, fmt.Errorf("mount %q: %q is not a directory", name, target)
+			}
+		}
+		mounts = append(mounts, mount)
+	}
+	return mounts, nil
+}
+
+// TLSEnabled reports whether the server speaks HTTPS
+func TLSEnabled() bool {
+	return TLSMode != "off"
+}
+
+func flagName(name string) string {
+	return strings.ReplaceAll(name, "_", "-")
+}
+
+// boolFlag is a flag that may be given without a value
+type boolFlag struct{ value *string }
+
+func (b boolFlag) String() string {
+	if b.value == nil {
+		return ""
+	}
+	return *b.value
+}
+func (b boolFlag) Set(s string) error { *b.value = s; return nil }
+func (b boolFlag) IsBoolFlag() bool   { return true }
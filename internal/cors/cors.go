@@ -0,0 +1,72 @@
+package cors
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"GoFiles/internal/config"
+)
+
+// exposedHeaders are the response headers scripts may read: the ETag sent
+// back in If-Match, and the ones resumable upload clients need
+const exposedHeaders = "ETag, Location, Tus-Resumable, Tus-Version, Tus-Extension, Upload-Offset, Upload-Length, Upload-Metadata, Upload-Expires"
+
+// Middleware applies the configured CORS policy to every response and
+// answers preflight requests itself, so handlers never see them.
+// With no allowed origins it does nothing (same-origin deployments).
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(config.CORSOrigins) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		origin := r.Header.Get("Origin")
+		allowOrigin := ""
+		if origin != "" {
+			allowOrigin = allowedOrigin(origin)
+		}
+		allowed := allowOrigin != ""
+		w.Header().Add("Vary", "Origin")
+
+		if allowed {
+			w.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+			if allowOrigin != "*" {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+			w.Header().Set("Access-Control-Expose-Headers", exposedHeaders)
+		}
+
+		// Preflight: answer directly
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			if allowed {
+				w.Header().Set("Access-Control-Allow-Methods", strings.Join(config.CORSMethods, ", "))
+				w.Header().Set("Access-Control-Allow-Headers", strings.Join(config.CORSHeaders, ", "))
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(config.CORSMaxAge.Seconds())))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowedOrigin returns what to send as Access-Control-Allow-Origin, or ""
+// if the origin isn't allowed. Listed origins are echoed and may send
+// cookies. Any other origin only gets "*", which browsers never combine
+// with credentials: a site anyone can open must not act as a logged in user.
+func allowedOrigin(origin string) string {
+	wildcard := false
+	for _, o := range config.CORSOrigins {
+		if strings.EqualFold(o, origin) {
+			return origin
+		}
+		wildcard = wildcard || o == "*"
+	}
+	if wildcard {
+		return "*"
+	}
+	return ""
+}
@@ -0,0 +1,72 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"GoFiles/internal/config"
+)
+
+func TestMiddleware(t *testing.T) {
+	saved := config.CORSOrigins
+	defer func() { config.CORSOrigins = saved }()
+
+	tests := []struct {
+		name        string
+		origins     []string
+		origin      string
+		preflight   bool
+		allow       string // Access-Control-Allow-Origin
+		credentials bool
+		reached     bool // The handler ran
+	}{
+		{"disabled", nil, "https://app.example", false, "", false, true},
+		{"listed origin", []string{"https://app.example"}, "https://app.example", false, "https://app.example", true, true},
+		{"origin case", []string{"https://App.example"}, "https://app.example", false, "https://app.example", true, true},
+		{"other origin", []string{"https://app.example"}, "https://evil.example", false, "", false, true},
+		{"wildcard", []string{"*"}, "https://evil.example", false, "*", false, true},
+		{"listed beats wildcard", []string{"*", "https://app.example"}, "https://app.example", false, "https://app.example", true, true},
+		{"preflight", []string{"https://app.example"}, "https://app.example", true, "https://app.example", true, false},
+		{"refused preflight", []string{"https://app.example"}, "https://evil.example", true, "", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.CORSOrigins = tt.origins
+			reached := false
+			handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				reached = true
+			}))
+
+			method := http.MethodGet
+			if tt.preflight {
+				method = http.MethodOptions
+			}
+			r := httptest.NewRequest(method, "/api/files", nil)
+			r.Header.Set("Origin", tt.origin)
+			if tt.preflight {
+				r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.allow {
+				t.Errorf("Allow-Origin = %q, want %q", got, tt.allow)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Credentials") == "true"; got != tt.credentials {
+				t.Errorf("credentials allowed = %v, want %v", got, tt.credentials)
+			}
+			if reached != tt.reached {
+				t.Errorf("handler reached = %v, want %v", reached, tt.reached)
+			}
+			if tt.preflight {
+				if w.Code != http.StatusNoContent {
+					t.Errorf("preflight status = %d, want %d", w.Code, http.StatusNoContent)
+				}
+				if got := w.Header().Get("Access-Control-Allow-Methods") != ""; got != (tt.allow != "") {
+					t.Errorf("methods sent = %v for allowed origin %q", got, tt.allow)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,396 @@
+package dav
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"GoFiles/internal/acl"
+	"GoFiles/internal/auth"
+	"GoFiles/internal/quota"
+	"GoFiles/internal/storage"
+	"GoFiles/internal/trash"
+	"GoFiles/internal/types"
+	"GoFiles/internal/users"
+
+	"golang.org/x/net/webdav"
+)
+
+// Prefix is where the WebDAV tree is served
+const Prefix = "/dav"
+
+// safeMethods don't change anything, so read-only API tokens may use them
+var safeMethods = []string{"GET", "HEAD", "OPTIONS", "PROPFIND"}
+
+// One lock system per home folder, since lock names are paths inside it
+var lockSystems = map[string]webdav.LockSystem{}
+var lockSystemsMu sync.Mutex
+
+// Handler serves each user's files (their home, if one is set) over WebDAV,
+// authenticated with HTTP Basic
+func Handler() http.Handler {
+	return auth.BasicMiddleware("GoFiles", safeMethods, http.HandlerFunc(serveDAV))
+}
+
+func serveDAV(w http.ResponseWriter, r *http.Request) {
+	user := auth.CurrentUser(r)
+	fsys := users.Files(user)
+
+	// Refuse writes up front with a clear status; the FS checks again for
+	// operations webdav performs on its own (e.g. recursive copies)
+	if status := checkRequest(r, user, fsys); status != http.StatusOK {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	budget, err := writeBudget(r, user)
+	if err != nil {
+		status := http.StatusInsufficientStorage
+		if errors.Is(err, quota.ErrTooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	var body *bodyReader
+	if r.Method == "PUT" {
+		body = &bodyReader{ReadCloser: r.Body}
+		r.Body = body
+	}
+
+	h := &webdav.Handler{
+		Prefix:     Prefix,
+		FileSystem: &davFS{user: user, fsys: fsys, budget: budget, body: body},
+		LockSystem: lockSystem(user.Home),
+		Logger: func(r *http.Request, err error) {
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				fmt.Printf("WebDAV %s %s: %v\n", r.Method, r.URL.Path, err)
+			}
+		},
+	}
+	h.ServeHTTP(w, r)
+}
+
+// check is a permission a request needs on a path. For a folder that is
+// deleted, moved or copied, src is where its tree comes from (the path
+// itself for a delete), so that deeper rules apply as well.
+type check struct {
+	name, perm, src string
+}
+
+// checkRequest applies the access rules and read-only mode to the paths a
+// modifying request touches
+func checkRequest(r *http.Request, user types.User, fsys storage.FS) int {
+	name := strings.TrimPrefix(r.URL.Path, Prefix)
+	var checks []check
+	switch r.Method {
+	case "PUT", "MKCOL", "PROPPATCH", "LOCK":
+		checks = append(checks, check{name: name, perm: acl.PermWrite})
+	case "DELETE":
+		checks = append(checks, check{name: name, perm: acl.PermDelete, src: name})
+	case "MOVE", "COPY":
+		perm := acl.PermRead
+		if r.Method == "MOVE" {
+			perm = acl.PermDelete
+		}
+		checks = append(checks, check{name: name, perm: perm, src: name})
+		if dest, err := url.Parse(r.Header.Get("Destination")); err == nil {
+			checks = append(checks, check{name: strings.TrimPrefix(dest.Path, Prefix), perm: acl.PermWrite, src: name})
+		}
+	}
+
+	for _, c := range checks {
+		p, ok := storage.Clean(c.name)
+		if !ok || !acl.Allowed(user, p, c.perm) {
+			return http.StatusForbidden
+		}
+		if src, ok := storage.Clean(c.src); c.src != "" && (!ok || !acl.AllowedTree(user, fsys, p, src, c.perm)) {
+			return http.StatusForbidden
+		}
+		if c.perm != acl.PermRead && storage.IsReadOnly(fsys, p) {
+			return http.StatusForbidden
+		}
+	}
+	return http.StatusOK
+}
+
+// writeBudget returns what a PUT or COPY may write, failing up front when
+// the size is known and doesn't fit. Nil for other methods.
+func writeBudget(r *http.Request, user types.User) (*quota.Budget, error) {
+	name := strings.TrimPrefix(r.URL.Path, Prefix)
+	switch r.Method {
+	case "PUT":
+	case "COPY":
+		dest, err := url.Parse(r.Header.Get("Destination"))
+		if err != nil {
+			return nil, nil // webdav rejects it
+		}
+		name = strings.TrimPrefix(dest.Path, Prefix)
+	default:
+		return nil, nil
+	}
+	p, _ := storage.Clean(name)
+	budget, err := quota.NewBudget(user, p, r.Method == "PUT")
+	if err == nil && r.Method == "PUT" && r.ContentLength > 0 {
+		err = budget.Check(r.ContentLength)
+	}
+	return budget, err
+}
+
+func lockSystem(home string) webdav.LockSystem {
+	lockSystemsMu.Lock()
+	defer lockSystemsMu.Unlock()
+	ls, ok := lockSystems[home]
+	if !ok {
+		ls = webdav.NewMemLS()
+		lockSystems[home] = ls
+	}
+	return ls
+}
+
+// davFS adapts a user's storage tree to webdav.FileSystem
+type davFS struct {
+	user   types.User
+	fsys   storage.FS
+	budget *quota.Budget // What a PUT or COPY may write
+	body   *bodyReader   // The body of a PUT
+}
+
+// bodyReader remembers why reading a request body failed. webdav closes the
+// file of a PUT whose client went away like any other, and it must not be
+// committed half written.
+type bodyReader struct {
+	io.ReadCloser
+	err error
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		b.err = err
+	}
+	return n, err
+}
+
+// name cleans a WebDAV path and checks a permission on it
+func (d *davFS) name(op, name, perm string) (string, error) {
+	p, ok := storage.Clean(name)
+	if !ok {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if storage.IsInternal(d.fsys, p) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	if perm == acl.PermDelete && storage.HoldsStateFile(d.fsys, p) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrPermission}
+	}
+	allowed := acl.Allowed(d.user, p, perm)
+	if perm == acl.PermRead {
+		allowed = acl.CanSee(d.user, p) // Folders on the way to readable files
+	}
+	if !allowed {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrPermission}
+	}
+	return p, nil
+}
+
+func (d *davFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	p, err := d.name("mkdir", name, acl.PermWrite)
+	if err != nil {
+		return err
+	}
+	return d.fsys.Mkdir(p, perm)
+}
+
+func (d *davFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		p, err := d.name("open", name, acl.PermRead)
+		if err != nil {
+			return nil, err
+		}
+		info, err := d.fsys.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() && !acl.Allowed(d.user, p, acl.PermRead) {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+		}
+		file, err := d.fsys.Open(p)
+		if err != nil {
+			return nil, err
+		}
+		return &davFile{File: file, dav: d, name: p}, nil
+	}
+
+	p, err := d.name("open", name, acl.PermWrite)
+	if err != nil {
+		return nil, err
+	}
+	info, err := d.fsys.Stat(p)
+	switch {
+	case err == nil && flag&os.O_EXCL != 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	case err == nil && info.IsDir():
+		return nil, &fs.PathError{Op: "open", Path: name, Err: storage.ErrNotDir}
+	case err != nil && flag&os.O_CREATE == 0:
+		return nil, err
+	}
+	var pending storage.PendingFile
+	if d.budget != nil {
+		pending, err = d.budget.Create(d.fsys, p)
+	} else {
+		pending, err = storage.CreateAtomic(d.fsys, p)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &davWriter{pending: pending, body: d.body, name: path.Base(p)}, nil
+}
+
+// RemoveAll moves the file or folder to the trash instead of deleting it
+func (d *davFS) RemoveAll(ctx context.Context, name string) error {
+	p, err := d.name("remove", name, acl.PermDelete)
+	if err != nil {
+		return err
+	}
+	if p == "." || !acl.AllowedTree(d.user, d.fsys, p, p, acl.PermDelete) {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
+	}
+	if _, err := d.fsys.Stat(p); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return trash.MoveToTrash(d.fsys, p)
+}
+
+func (d *davFS) Rename(ctx context.Context, oldName, newName string) error {
+	oldPath, err := d.name("rename", oldName, acl.PermDelete)
+	if err != nil {
+		return err
+	}
+	newPath, err := d.name("rename", newName, acl.PermWrite)
+	if err != nil {
+		return err
+	}
+	// Rules deeper in the folder apply as well
+	if !acl.AllowedTree(d.user, d.fsys, oldPath, oldPath, acl.PermDelete) ||
+		!acl.AllowedTree(d.user, d.fsys, newPath, oldPath, acl.PermWrite) {
+		return &fs.PathError{Op: "rename", Path: oldName, Err: fs.ErrPermission}
+	}
+	return d.fsys.Rename(oldPath, newPath)
+}
+
+func (d *davFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	p, err := d.name("stat", name, acl.PermRead)
+	if err != nil {
+		// Hidden entries don't exist as far as the client is concerned
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return d.fsys.Stat(p)
+}
+
+// davFile is a file or folder opened for reading
+type davFile struct {
+	storage.File
+	dav  *davFS
+	name string
+	read bool // Readdir has returned the entries
+}
+
+func (f *davFile) Readdir(count int) ([]fs.FileInfo, error) {
+	if f.read {
+		if count > 0 {
+			return nil, io.EOF
+		}
+		return nil, nil
+	}
+	f.read = true
+
+	entries, err := f.dav.fsys.ReadDir(f.name)
+	if err != nil {
+		return nil, err
+	}
+	var infos []fs.FileInfo
+	for _, entry := range entries {
+		child := path.Join(f.name, entry.Name())
+		if !acl.CanSee(f.dav.user, child) || storage.IsInternal(f.dav.fsys, child) {
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			infos = append(infos, info)
+		}
+	}
+	return infos, nil
+}
+
+func (f *davFile) Write(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: "write", Path: f.name, Err: fs.ErrPermission}
+}
+
+// davWriter is a file opened for writing. It replaces the old file on Close
+// only if everything was written. Stat reports what has been written so
+// far, which is what webdav uses for the ETag after a PUT.
+type davWriter struct {
+	pending storage.PendingFile // Counted against the budget
+	body    *bodyReader
+	name    string
+	written int64
+	err     error // First write error
+}
+
+func (f *davWriter) Write(p []byte) (int, error) {
+	n, err := f.pending.Write(p)
+	f.written += int64(n)
+	if err != nil && f.err == nil {
+		f.err = err
+	}
+	return n, err
+}
+
+func (f *davWriter) Close() error {
+	if f.err == nil && f.body != nil {
+		f.err = f.body.err
+	}
+	if f.err != nil {
+		f.pending.Abort()
+		return f.err
+	}
+	return f.pending.Commit()
+}
+
+func (f *davWriter) Read(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrPermission}
+}
+
+func (f *davWriter) Seek(offset int64, whence int) (int64, error) {
+	return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+}
+
+func (f *davWriter) Readdir(count int) ([]fs.FileInfo, error) {
+	return nil, &fs.PathError{Op: "readdir", Path: f.name, Err: storage.ErrNotDir}
+}
+
+func (f *davWriter) Stat() (fs.FileInfo, error) {
+	return writtenInfo{name: f.name, size: f.written, modTime: time.Now()}, nil
+}
+
+type writtenInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (i writtenInfo) Name() string       { return i.name }
+func (i writtenInfo) Size() int64        { return i.size }
+func (i writtenInfo) Mode() fs.FileMode  { return 0644 }
+func (i writtenInfo) ModTime() time.Time { return i.modTime }
+func (i writtenInfo) IsDir() bool        { return false }
+func (i writtenInfo) Sys() interface{}   { return nil }
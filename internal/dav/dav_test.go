@@ -0,0 +1,139 @@
+package dav
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+
+	"GoFiles/internal/acl"
+	"GoFiles/internal/auth"
+	"GoFiles/internal/config"
+	"GoFiles/internal/storage"
+	"GoFiles/internal/types"
+	"GoFiles/internal/users"
+)
+
+// setup starts a test with no accounts and an empty storage root
+func setup(t *testing.T) {
+	t.Chdir(t.TempDir()) // The config and key files live in the working directory
+	config.AppConfig = types.ConfigFile{}
+	storage.Root = storage.NewMemory()
+	auth.Init()
+}
+
+// addUser creates an account with access rules
+func addUser(t *testing.T, username string, rules []types.ACLRule) types.User {
+	t.Helper()
+	if _, err := users.Create(username, "password1", users.RoleUser); err != nil {
+		t.Fatal(err)
+	}
+	user, err := users.Modify(username, users.Change{Rules: &rules})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// writeFiles creates files (and their folders) in the storage root
+func writeFiles(t *testing.T, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		if err := storage.Root.MkdirAll(path.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := storage.WriteFile(storage.Root, name, []byte(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestRulesApplyToWholeTree checks that a rule on a folder can't be bypassed
+// by deleting or moving one of its parents
+func TestRulesApplyToWholeTree(t *testing.T) {
+	rules := []types.ACLRule{
+		{Path: "/", Permissions: []string{acl.PermRead, acl.PermWrite, acl.PermDelete}},
+		{Path: "/projects/locked", Permissions: []string{acl.PermRead}},
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		dest   string
+		want   int
+	}{
+		{"delete a parent", "DELETE", "/dav/projects", "", http.StatusForbidden},
+		{"delete next to it", "DELETE", "/dav/projects/a.txt", "", http.StatusNoContent},
+		{"move a parent", "MOVE", "/dav/projects", "/dav/archive", http.StatusForbidden},
+		{"move next to it", "MOVE", "/dav/projects/a.txt", "/dav/a.txt", http.StatusCreated},
+		{"copy onto a protected path", "COPY", "/dav/drafts", "/dav/projects", http.StatusForbidden},
+		{"copy a parent", "COPY", "/dav/projects", "/dav/archive", http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setup(t)
+			writeFiles(t, "projects/a.txt", "projects/locked/b.txt", "drafts/locked/c.txt")
+			addUser(t, "bob", rules)
+
+			r := httptest.NewRequest(tt.method, tt.target, nil)
+			r.RemoteAddr = "127.0.0.1:1234" // Passwords are only accepted locally without TLS
+			r.SetBasicAuth("bob", "password1")
+			if tt.dest != "" {
+				r.Header.Set("Destination", tt.dest)
+				r.Header.Set("Overwrite", "T")
+			}
+			w := httptest.NewRecorder()
+			Handler().ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+
+	// The file system checks on its own too
+	setup(t)
+	writeFiles(t, "projects/locked/b.txt")
+	d := &davFS{user: addUser(t, "bob", rules), fsys: storage.Root}
+	if err := d.RemoveAll(context.Background(), "/projects"); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("RemoveAll = %v, want %v", err, fs.ErrPermission)
+	}
+	if err := d.Rename(context.Background(), "/projects", "/archive"); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("Rename = %v, want %v", err, fs.ErrPermission)
+	}
+}
+
+// TestPasswordsNeedTLS checks that account passwords are refused over plain
+// HTTP, except from this machine
+func TestPasswordsNeedTLS(t *testing.T) {
+	setup(t)
+	addUser(t, "bob", nil)
+
+	tests := []struct {
+		name   string
+		remote string
+		tls    bool
+		want   int
+	}{
+		{"plain HTTP from elsewhere", "192.0.2.1:1234", false, http.StatusForbidden},
+		{"plain HTTP from this machine", "127.0.0.1:1234", false, http.StatusMultiStatus},
+		{"HTTPS from elsewhere", "192.0.2.1:1234", true, http.StatusMultiStatus},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("PROPFIND", "/dav/", nil)
+		r.RemoteAddr = tt.remote
+		if tt.tls {
+			r.TLS = &tls.ConnectionState{}
+		}
+		r.SetBasicAuth("bob", "password1")
+		w := httptest.NewRecorder()
+		Handler().ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"net/http"
+
+	"GoFiles/internal/acl"
+	"GoFiles/internal/auth"
+	"GoFiles/internal/storage"
+	"GoFiles/internal/users"
+)
+
+// userFS returns the files the caller is confined to.
+// "/" in the API means the root of this tree (the user's home, if one is set).
+func userFS(r *http.Request) storage.FS {
+	return users.Files(auth.CurrentUser(r))
+}
+
+// authorize checks the caller's access rules for relPath, and that writes
+// don't go to a read-only tree. Writes a 403 and returns false if not allowed.
+func authorize(w http.ResponseWriter, r *http.Request, relPath, perm string) bool {
+	if reason := denied(r, relPath, perm); reason != "" {
+		http.Error(w, reason, http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// authorizeTree is authorize for relPath and everything below it, as found
+// in the tree at src (relPath itself, unless that tree is copied or moved
+// there), so a deeper rule can't be bypassed through a parent folder
+func authorizeTree(w http.ResponseWriter, r *http.Request, relPath, src, perm string) bool {
+	if !authorize(w, r, relPath, perm) {
+		return false
+	}
+	if !acl.AllowedTree(auth.CurrentUser(r), userFS(r), relPath, src, perm) {
+		http.Error(w, "Access Denied: a folder inside is protected", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// denied is authorize without the response: why the caller may not use
+// relPath, or "" if they may
+func denied(r *http.Request, relPath, perm string) string {
+	if !acl.Allowed(auth.CurrentUser(r), relPath, perm) || storage.IsInternal(userFS(r), relPath) {
+		return "Access Denied"
+	}
+	if perm == acl.PermDelete && storage.HoldsStateFile(userFS(r), relPath) {
+		return "Access Denied: the folder holds server files"
+	}
+	if (perm == acl.PermWrite || perm == acl.PermDelete) && storage.IsReadOnly(userFS(r), relPath) {
+		return "Read-only: files here can't be changed"
+	}
+	return ""
+}
+
+// canSee reports whether relPath may appear in the caller's listings
+func canSee(r *http.Request, relPath string) bool {
+	return acl.CanSee(auth.CurrentUser(r), relPath) && !storage.IsInternal(userFS(r), relPath)
+}
+
+// canRead reports whether the caller may read relPath
+func canRead(r *http.Request, relPath string) bool {
+	return acl.Allowed(auth.CurrentUser(r), relPath, acl.PermRead) && !storage.IsInternal(userFS(r), relPath)
+}
@@ -4,21 +4,20 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/fs"
 	"net/http"
-	"os"
-	"path/filepath"
+	"path"
 	"strings"
 
-	"GoFiles/internal/config"
+	"GoFiles/internal/acl"
+	"GoFiles/internal/storage"
 	"GoFiles/internal/types"
-	"GoFiles/internal/utils"
 
 	"github.com/yeka/zip" // Replaces standard archive/zip
 )
 
 // HandleZip compresses a file or folder into a .zip
 func HandleZip(w http.ResponseWriter, r *http.Request) {
-	utils.EnableCors(&w)
 	if r.Method != http.MethodPost {
 		return
 	}
@@ -29,53 +28,72 @@ func HandleZip(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	srcPath := filepath.Join(config.RootFolder, req.SourcePath)
+	srcPath, ok1 := storage.Clean(req.SourcePath)
 	// Destination: If DestPath is empty, save next to source
-	destPath := ""
-	if req.DestPath != "" {
-		destPath = filepath.Join(config.RootFolder, req.DestPath)
-	} else {
-		destPath = srcPath + ".zip"
+	destRelPath := req.DestPath
+	if destRelPath == "" {
+		destRelPath = req.SourcePath + ".zip"
 	}
+	destPath, ok2 := storage.Clean(destRelPath)
 
-	if !utils.IsPathSafe(srcPath) || !utils.IsPathSafe(destPath) {
+	if !ok1 || !ok2 {
 		http.Error(w, "Access Denied", http.StatusForbidden)
 		return
 	}
+	if !authorize(w, r, srcPath, acl.PermRead) || !authorize(w, r, destPath, acl.PermWrite) {
+		return
+	}
+	fsys := userFS(r)
+	budget := newBudget(w, r, destPath, false)
+	if budget == nil {
+		return
+	}
 
-	// Create the Zip File
-	zipFile, err := os.Create(destPath)
+	// Create the Zip File, out of sight until it's complete
+	zipFile, err := budget.Create(fsys, destPath)
 	if err != nil {
 		http.Error(w, "Could not create zip file", http.StatusInternalServerError)
 		return
 	}
-	defer zipFile.Close()
 
-	// Initialize Zip Writer
+	// Initialize Zip Writer, counting the compressed bytes against the limits
 	zipWriter := zip.NewWriter(zipFile)
-	defer zipWriter.Close()
 
 	// Walk through the source directory/file
-	err = filepath.Walk(srcPath, func(path string, info os.FileInfo, err error) error {
+	err = storage.WalkDir(fsys, srcPath, func(p string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
 
 		// Don't zip the zip file itself if it's in the same folder
-		if path == destPath {
+		if p == destPath {
 			return nil
 		}
 
+		// Leave out anything the user isn't allowed to read
+		if ok, err := zipIncludes(r, p, d.IsDir()); !ok {
+			return err
+		}
+
+		// Make path relative to the root of the archive
+		// e.g. zipping /users/docs/work -> work/resume.pdf
+		name := storage.Rel(path.Dir(srcPath), p)
+		if name == "." {
+			return nil // Zipping the whole root: its contents go in at the top
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
 		// Create header
 		header, err := zip.FileInfoHeader(info)
 		if err != nil {
 			return err
 		}
 
-		// Make path relative to the root of the archive
-		// e.g. zipping /users/docs/work -> work/resume.pdf
-		relPath, _ := filepath.Rel(filepath.Dir(srcPath), path)
-		header.Name = filepath.ToSlash(relPath)
+		header.Name = name
 
 		if info.IsDir() {
 			header.Name += "/"
@@ -99,7 +117,7 @@ func HandleZip(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Copy content
-		file, err := os.Open(path)
+		file, err := fsys.Open(p)
 		if err != nil {
 			return err
 		}
@@ -109,6 +127,18 @@ func HandleZip(w http.ResponseWriter, r *http.Request) {
 		return err
 	})
 
+	// Closing writes the zip directory, committing puts the file in place
+	if closeErr := zipWriter.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		zipFile.Abort() // Half an archive is of no use
+	} else {
+		err = zipFile.Commit()
+	}
+	if writeLimitError(w, err) {
+		return
+	}
 	if err != nil {
 		http.Error(w, "Error zipping: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -119,7 +149,6 @@ func HandleZip(w http.ResponseWriter, r *http.Request) {
 
 // HandleUnzip extracts a zip file
 func HandleUnzip(w http.ResponseWriter, r *http.Request) {
-	utils.EnableCors(&w)
 	if r.Method != http.MethodPost {
 		return
 	}
@@ -130,21 +159,39 @@ func HandleUnzip(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	srcPath := filepath.Join(config.RootFolder, req.SourcePath)
-	destPath := filepath.Join(config.RootFolder, req.DestPath)
+	srcPath, ok1 := storage.Clean(req.SourcePath)
+	destPath, ok2 := storage.Clean(req.DestPath)
 
-	if !utils.IsPathSafe(srcPath) || !utils.IsPathSafe(destPath) {
+	if !ok1 || !ok2 {
 		http.Error(w, "Access Denied", http.StatusForbidden)
 		return
 	}
+	if !authorize(w, r, srcPath, acl.PermRead) || !authorize(w, r, destPath, acl.PermWrite) {
+		return
+	}
+	fsys := userFS(r)
+	budget := newBudget(w, r, destPath, false)
+	if budget == nil {
+		return
+	}
 
 	// Open Zip Reader
-	reader, err := zip.OpenReader(srcPath)
+	zipFile, err := fsys.Open(srcPath)
+	if err != nil {
+		http.Error(w, "Failed to open zip: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer zipFile.Close()
+	info, err := zipFile.Stat()
+	if err != nil {
+		http.Error(w, "Failed to open zip: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	reader, err := zip.NewReader(zipFile, info.Size())
 	if err != nil {
 		http.Error(w, "Failed to open zip: "+err.Error(), http.StatusBadRequest)
 		return
 	}
-	defer reader.Close()
 
 	// Iterate through files in zip
 	for _, file := range reader.File {
@@ -154,21 +201,27 @@ func HandleUnzip(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Calculate extract path
-		fpath := filepath.Join(destPath, file.Name)
+		fpath, ok := storage.Clean(path.Join(destPath, file.Name))
 
 		// Zip Slip Protection (Security)
 		// Prevent zips from containing "../../virus.exe"
-		if !strings.HasPrefix(fpath, filepath.Clean(destPath)+string(os.PathSeparator)) {
+		if !ok || fpath == destPath || (destPath != "." && !strings.HasPrefix(fpath, destPath+"/")) {
 			continue // Skip illegal paths
 		}
 
+		// Access rules may differ below destPath, and the hidden folders and
+		// the server's own files can't be written from an archive
+		if denied(r, fpath, acl.PermWrite) != "" {
+			continue
+		}
+
 		if file.FileInfo().IsDir() {
-			os.MkdirAll(fpath, os.ModePerm)
+			fsys.MkdirAll(fpath, 0755)
 			continue
 		}
 
 		// Make parent dirs
-		if err := os.MkdirAll(filepath.Dir(fpath), os.ModePerm); err != nil {
+		if err := fsys.MkdirAll(path.Dir(fpath), 0755); err != nil {
 			http.Error(w, "File permission error", http.StatusInternalServerError)
 			return
 		}
@@ -184,17 +237,25 @@ func HandleUnzip(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// Create file on disk
-		outFile, err := os.OpenFile(fpath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, file.Mode())
+		// Create file in storage, replacing an existing one only once complete
+		outFile, err := budget.Create(fsys, fpath)
 		if err != nil {
 			rc.Close()
+			http.Error(w, "Could not create "+file.Name, http.StatusInternalServerError)
 			return
 		}
 
+		// Counted as it's written: the sizes in the zip can't be trusted
 		_, err = io.Copy(outFile, rc)
-
-		outFile.Close()
 		rc.Close()
+		if err != nil {
+			outFile.Abort()
+		} else {
+			err = outFile.Commit()
+		}
+		if writeLimitError(w, err) {
+			return
+		}
 		if err != nil {
 			http.Error(w, "Extract error", http.StatusInternalServerError)
 			return
@@ -205,21 +266,25 @@ func HandleUnzip(w http.ResponseWriter, r *http.Request) {
 }
 
 func HandleDownloadZip(w http.ResponseWriter, r *http.Request) {
-	utils.EnableCors(&w)
 	if r.Method != http.MethodGet {
 		return
 	}
 
-	reqPath := r.URL.Query().Get("path")
-	fullPath := filepath.Join(config.RootFolder, reqPath)
-
-	if !utils.IsPathSafe(fullPath) {
+	reqPath, ok := storage.Clean(r.URL.Query().Get("path"))
+	if !ok {
 		http.Error(w, "Access Denied", http.StatusForbidden)
 		return
 	}
+	if !authorize(w, r, reqPath, acl.PermRead) {
+		return
+	}
+	fsys := userFS(r)
 
 	// 1. Set Headers for Download
-	zipName := filepath.Base(fullPath) + ".zip"
+	zipName := path.Base(reqPath) + ".zip"
+	if reqPath == "." {
+		zipName = "files.zip"
+	}
 	w.Header().Set("Content-Type", "application/zip")
 	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, zipName))
 
@@ -228,15 +293,22 @@ func HandleDownloadZip(w http.ResponseWriter, r *http.Request) {
 	defer zipWriter.Close()
 
 	// 3. Walk and Stream
-	filepath.Walk(fullPath, func(path string, info os.FileInfo, err error) error {
+	storage.WalkDir(fsys, reqPath, func(p string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return nil
 		}
+		if ok, err := zipIncludes(r, p, d.IsDir()); !ok {
+			return err
+		}
 
 		// Calculate relative path
-		relPath, _ := filepath.Rel(filepath.Dir(fullPath), path)
+		name := storage.Rel(path.Dir(reqPath), p)
+		info, err := d.Info()
+		if name == "." || err != nil {
+			return nil
+		}
 		header, _ := zip.FileInfoHeader(info)
-		header.Name = filepath.ToSlash(relPath)
+		header.Name = name
 
 		if info.IsDir() {
 			header.Name += "/"
@@ -249,7 +321,7 @@ func HandleDownloadZip(w http.ResponseWriter, r *http.Request) {
 			return nil
 		}
 
-		file, err := os.Open(path)
+		file, err := fsys.Open(p)
 		if err != nil {
 			return nil
 		}
@@ -259,3 +331,18 @@ func HandleDownloadZip(w http.ResponseWriter, r *http.Request) {
 		return nil
 	})
 }
+
+// zipIncludes decides whether a walked entry goes into an archive. Entries
+// the user can't read are left out; hidden folders are skipped entirely.
+func zipIncludes(r *http.Request, relPath string, isDir bool) (bool, error) {
+	if canRead(r, relPath) {
+		return true, nil
+	}
+	if isDir {
+		if canSee(r, relPath) {
+			return true, nil // A readable child may follow
+		}
+		return false, fs.SkipDir
+	}
+	return false, nil
+}
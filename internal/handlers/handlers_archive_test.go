@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"net/http"
+	"testing"
+
+	"GoFiles/internal/acl"
+	"GoFiles/internal/storage"
+	"GoFiles/internal/types"
+	"GoFiles/internal/users"
+)
+
+// writeZip stores a zip of the given files and contents in the storage root
+func writeZip(t *testing.T, name string, files map[string]string) {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for file, content := range files {
+		f, err := zw.Create(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.WriteFile(storage.Root, name, buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestUnzipFollowsRules checks that an archive can't write where its
+// entries' paths aren't allowed
+func TestUnzipFollowsRules(t *testing.T) {
+	setup(t)
+	all := []string{acl.PermRead, acl.PermWrite, acl.PermDelete}
+	rules := []types.ACLRule{
+		{Path: "/", Permissions: all},
+		{Path: "/out/locked", Permissions: []string{acl.PermRead}},
+	}
+	login(t, "admin", users.RoleAdmin, users.Change{})
+	bob := login(t, "bob", users.RoleUser, users.Change{Rules: &rules})
+	writeZip(t, "files.zip", map[string]string{
+		"a.txt":        "a",
+		"locked/b.txt": "b",
+		"../evil.txt":  "evil",
+	})
+
+	w := call(HandleUnzip, bob, http.MethodPost, "/", types.ArchiveRequest{SourcePath: "files.zip", DestPath: "out"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	if !exists("out/a.txt") {
+		t.Error("allowed entry not extracted")
+	}
+	if exists("out/locked/b.txt") {
+		t.Error("entry extracted into a read-only folder")
+	}
+	if exists("evil.txt") {
+		t.Error("entry extracted outside the destination")
+	}
+
+	if w := call(HandleUnzip, bob, http.MethodPost, "/", types.ArchiveRequest{SourcePath: "files.zip", DestPath: "out/locked"}); w.Code != http.StatusForbidden {
+		t.Errorf("unzip into a read-only folder: %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+// TestUnzipQuota checks that extracting stops at the quota, counted by what
+// is written rather than the sizes the zip claims
+func TestUnzipQuota(t *testing.T) {
+	setup(t)
+	home, limit := "homes/unzip", int64(500)
+	bob := login(t, "bob", users.RoleUser, users.Change{Home: &home, Quota: &limit})
+	writeZip(t, "homes/unzip/files.zip", map[string]string{
+		"big.txt": string(bytes.Repeat([]byte("x"), 1000)),
+	})
+	if info, _ := storage.Root.Stat("homes/unzip/files.zip"); info.Size() >= limit {
+		t.Fatalf("the zip alone (%d bytes) fills the quota", info.Size())
+	}
+
+	w := call(HandleUnzip, bob, http.MethodPost, "/", types.ArchiveRequest{SourcePath: "files.zip", DestPath: "out"})
+	if w.Code != http.StatusInsufficientStorage {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusInsufficientStorage, w.Body)
+	}
+	if exists("homes/unzip/out/big.txt") {
+		t.Error("partial file left behind")
+	}
+}
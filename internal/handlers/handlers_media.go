@@ -5,40 +5,41 @@ import (
 	"encoding/hex"
 	"fmt"
 	"net/http"
-	"os"
-	"path/filepath"
+	"path"
 	"strings"
 
+	"GoFiles/internal/acl"
 	"GoFiles/internal/config"
-	"GoFiles/internal/utils"
+	"GoFiles/internal/storage"
 
 	"github.com/disintegration/imaging"
 )
 
 // HandleThumbnail generates or retrieves a cached thumbnail
 func HandleThumbnail(w http.ResponseWriter, r *http.Request) {
-	utils.EnableCors(&w)
 	if r.Method != http.MethodGet {
 		return
 	}
 
-	reqPath := r.URL.Query().Get("path")
-	fullPath := filepath.Join(config.RootFolder, reqPath)
-
-	if !utils.IsPathSafe(fullPath) {
+	reqPath, ok := storage.Clean(r.URL.Query().Get("path"))
+	if !ok {
 		http.Error(w, "Access Denied", http.StatusForbidden)
 		return
 	}
+	if !authorize(w, r, reqPath, acl.PermRead) {
+		return
+	}
+	fsys := userFS(r)
 
 	// 1. Check if the file is actually an image
-	ext := strings.ToLower(filepath.Ext(fullPath))
+	ext := strings.ToLower(path.Ext(reqPath))
 	if ext != ".jpg" && ext != ".jpeg" && ext != ".png" && ext != ".gif" {
 		http.Error(w, "Not an image", http.StatusBadRequest)
 		return
 	}
 
 	// 2. Get File Info (to check modification time)
-	info, err := os.Stat(fullPath)
+	info, err := fsys.Stat(reqPath)
 	if err != nil {
 		http.Error(w, "File not found", http.StatusNotFound)
 		return
@@ -46,28 +47,37 @@ func HandleThumbnail(w http.ResponseWriter, r *http.Request) {
 
 	// 3. Generate a Unique Cache Filename
 	// We hash the Path + ModTime. If the file is edited, ModTime changes, hash changes -> New Thumbnail!
-	hashKey := fmt.Sprintf("%s-%d", fullPath, info.ModTime().Unix())
+	hashKey := fmt.Sprintf("%s-%d", reqPath, info.ModTime().Unix())
 	hasher := md5.New()
 	hasher.Write([]byte(hashKey))
 	hash := hex.EncodeToString(hasher.Sum(nil))
 
+	// Thumbnails are cached on the same mount as the image
+	thumbsDir := path.Join(storage.MountOf(fsys, reqPath), config.ThumbsFolder)
 	thumbFilename := hash + ".jpg"
-	thumbPath := filepath.Join(config.RootFolder, config.ThumbsFolder, thumbFilename)
+	thumbPath := path.Join(thumbsDir, thumbFilename)
 
-	// 4. Check if Thumbnail already exists on disk
-	if _, err := os.Stat(thumbPath); err == nil {
+	// 4. Check if Thumbnail already exists
+	if _, err := fsys.Stat(thumbPath); err == nil {
 		// HIT! Serve directly from cache
-		http.ServeFile(w, r, thumbPath)
+		serveThumbnail(w, r, fsys, thumbPath)
 		return
 	}
 
 	// 5. MISS! Generate it.
 	// Ensure .thumbs folder exists
-	os.MkdirAll(filepath.Join(config.RootFolder, config.ThumbsFolder), 0755)
+	fsys.MkdirAll(thumbsDir, 0755)
 
 	// Open and Resize
-	// imaging.Open handles rotation automatically (EXIF data)
-	srcImage, err := imaging.Open(fullPath)
+	src, err := fsys.Open(reqPath)
+	if err != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	defer src.Close()
+
+	// AutoOrientation applies the EXIF rotation
+	srcImage, err := imaging.Decode(src, imaging.AutoOrientation(true))
 	if err != nil {
 		http.Error(w, "Failed to decode image", http.StatusInternalServerError)
 		return
@@ -77,13 +87,43 @@ func HandleThumbnail(w http.ResponseWriter, r *http.Request) {
 	// Lanczos is the best quality filter
 	dstImage := imaging.Resize(srcImage, 300, 0, imaging.Lanczos)
 
+	// Nowhere to cache it on a read-only tree: send it straight away
+	if storage.IsReadOnly(fsys, thumbPath) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		imaging.Encode(w, dstImage, imaging.JPEG)
+		return
+	}
+
 	// Save to .thumbs folder
-	err = imaging.Save(dstImage, thumbPath)
+	dst, err := fsys.Create(thumbPath)
+	if err == nil {
+		err = imaging.Encode(dst, dstImage, imaging.JPEG)
+		if closeErr := dst.Close(); err == nil {
+			err = closeErr
+		}
+	}
 	if err != nil {
+		fsys.Remove(thumbPath)
 		http.Error(w, "Failed to save thumbnail", http.StatusInternalServerError)
 		return
 	}
 
 	// Serve the newly created file
-	http.ServeFile(w, r, thumbPath)
+	serveThumbnail(w, r, fsys, thumbPath)
+}
+
+func serveThumbnail(w http.ResponseWriter, r *http.Request, fsys storage.FS, thumbPath string) {
+	file, err := fsys.Open(thumbPath)
+	if err != nil {
+		http.Error(w, "Thumbnail not found", http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		http.Error(w, "Thumbnail not found", http.StatusNotFound)
+		return
+	}
+	http.ServeContent(w, r, thumbPath, info.ModTime(), file)
 }
@@ -2,95 +2,183 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"io/fs"
 	"net/http"
-	"os"
-	"path/filepath"
+	"path"
 
-	"GoFiles/internal/config"
+	"GoFiles/internal/acl"
+	"GoFiles/internal/storage"
 	"GoFiles/internal/trash"
 	"GoFiles/internal/types"
 	"GoFiles/internal/utils"
 )
 
 func HandleDelete(w http.ResponseWriter, r *http.Request) {
-	utils.EnableCors(&w)
 	if r.Method != http.MethodDelete && r.Method != http.MethodPost {
 		return
 	}
 
-	targetPath := r.URL.Query().Get("path")
+	targetPath, ok := storage.Clean(r.URL.Query().Get("path"))
 	permanent := r.URL.Query().Get("permanent") == "true"
 
-	if !utils.IsPathSafe(filepath.Join(config.RootFolder, targetPath)) {
+	if !ok {
 		return
 	}
+	if !authorizeTree(w, r, targetPath, targetPath, acl.PermDelete) {
+		return
+	}
+	unlock, current := lockVersion(r, targetPath)
+	if current != nil {
+		writeVersionConflict(w, current)
+		return
+	}
+	defer unlock()
 
+	fsys := userFS(r)
+	var err error
 	if permanent {
-		os.RemoveAll(filepath.Join(config.RootFolder, targetPath))
+		err = fsys.RemoveAll(targetPath)
 	} else {
-		trash.MoveToTrash(targetPath)
+		err = trash.MoveToTrash(fsys, targetPath)
+	}
+	if err != nil {
+		writeOpError(w, err, "delete")
+		return
 	}
 	w.WriteHeader(http.StatusOK)
 }
 
 func HandleRename(w http.ResponseWriter, r *http.Request) {
-	utils.EnableCors(&w)
 	if r.Method != http.MethodPost {
 		return
 	}
 
 	var req types.ActionRequest
 	json.NewDecoder(r.Body).Decode(&req)
-	oldPath := filepath.Join(config.RootFolder, req.SourcePath)
-	newPath := filepath.Join(filepath.Dir(oldPath), req.NewName)
+	oldPath, ok1 := storage.Clean(req.SourcePath)
+	newPath, ok2 := storage.Clean(path.Join(path.Dir(oldPath), req.NewName))
 
-	if !utils.IsPathSafe(oldPath) || !utils.IsPathSafe(newPath) {
+	if !ok1 || !ok2 {
+		return
+	}
+	// Renaming removes the old name and creates the new one
+	if !authorizeTree(w, r, oldPath, oldPath, acl.PermDelete) || !authorizeTree(w, r, newPath, oldPath, acl.PermWrite) {
 		return
 	}
 
-	os.Rename(oldPath, newPath)
+	if err := userFS(r).Rename(oldPath, newPath); err != nil {
+		writeOpError(w, err, "rename")
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 }
 
 func HandleMove(w http.ResponseWriter, r *http.Request) {
-	utils.EnableCors(&w)
 	if r.Method != http.MethodPost {
 		return
 	}
 
 	var req types.ActionRequest
 	json.NewDecoder(r.Body).Decode(&req)
-	srcPath := filepath.Join(config.RootFolder, req.SourcePath)
-	destPath := filepath.Join(config.RootFolder, req.DestPath, filepath.Base(req.SourcePath))
+	srcPath, ok1 := storage.Clean(req.SourcePath)
+	destPath, ok2 := storage.Clean(path.Join(req.DestPath, path.Base(srcPath)))
 
-	if !utils.IsPathSafe(srcPath) || !utils.IsPathSafe(destPath) {
+	if !ok1 || !ok2 {
+		return
+	}
+	if !authorizeTree(w, r, srcPath, srcPath, acl.PermDelete) || !authorizeTree(w, r, destPath, srcPath, acl.PermWrite) {
 		return
 	}
 
-	os.Rename(srcPath, destPath)
+	if err := userFS(r).Rename(srcPath, destPath); err != nil {
+		writeOpError(w, err, "move")
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 }
 
 func HandleCopy(w http.ResponseWriter, r *http.Request) {
-	utils.EnableCors(&w)
 	if r.Method != http.MethodPost {
 		return
 	}
 
 	var req types.ActionRequest
 	json.NewDecoder(r.Body).Decode(&req)
-	srcPath := filepath.Join(config.RootFolder, req.SourcePath)
-	destPath := filepath.Join(config.RootFolder, req.DestPath, filepath.Base(req.SourcePath))
+	srcPath, ok1 := storage.Clean(req.SourcePath)
+	destPath, ok2 := storage.Clean(path.Join(req.DestPath, path.Base(srcPath)))
 
-	if !utils.IsPathSafe(srcPath) || !utils.IsPathSafe(destPath) {
+	if !ok1 || !ok2 {
+		return
+	}
+	if !authorizeTree(w, r, srcPath, srcPath, acl.PermRead) || !authorizeTree(w, r, destPath, srcPath, acl.PermWrite) {
+		return
+	}
+	// Copying onto itself would truncate the source, into itself would never end
+	if storage.Rel(srcPath, destPath) != destPath || srcPath == "." {
+		http.Error(w, "Cannot copy a folder into itself", http.StatusBadRequest)
+		return
+	}
+
+	fsys := userFS(r)
+	info, err := fsys.Stat(srcPath)
+	if err != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+
+	// The sizes are known, so the whole copy is reserved before it starts
+	budget := newBudget(w, r, destPath, false)
+	if budget == nil {
+		return
+	}
+	var size int64
+	storage.WalkDir(fsys, srcPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if storage.IsInternal(fsys, p) {
+			if d.IsDir() {
+				return fs.SkipDir // Not copied
+			}
+			return nil
+		}
+		if !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	if err := budget.Reserve(size); err != nil {
+		writeLimitError(w, err)
 		return
 	}
 
-	info, _ := os.Stat(srcPath)
 	if info.IsDir() {
-		utils.CopyDir(srcPath, destPath)
+		err = utils.CopyDir(fsys, srcPath, destPath)
 	} else {
-		utils.CopyFile(srcPath, destPath)
+		err = utils.CopyFile(fsys, srcPath, destPath)
+	}
+	if err != nil {
+		budget.Release(size) // Whatever was copied shows up on the next walk
+		writeOpError(w, err, "copy")
+		return
 	}
 	w.WriteHeader(http.StatusOK)
 }
+
+// writeOpError reports why a file operation failed: 404 for a missing file,
+// 403 for a read-only tree or a refused path, 500 for anything else
+func writeOpError(w http.ResponseWriter, err error, action string) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		http.Error(w, "File not found", http.StatusNotFound)
+	case errors.Is(err, storage.ErrReadOnly):
+		http.Error(w, "Read-only: files here can't be changed", http.StatusForbidden)
+	case errors.Is(err, fs.ErrPermission):
+		http.Error(w, "Access Denied", http.StatusForbidden)
+	default:
+		http.Error(w, "Failed to "+action, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"GoFiles/internal/acl"
+	"GoFiles/internal/storage"
+	"GoFiles/internal/types"
+	"GoFiles/internal/users"
+)
+
+// TestOpsCheckWholeTree checks that a rule on a folder can't be bypassed by
+// deleting, moving or copying one of its parents
+func TestOpsCheckWholeTree(t *testing.T) {
+	all := []string{acl.PermRead, acl.PermWrite, acl.PermDelete}
+	rules := []types.ACLRule{
+		{Path: "/", Permissions: all},
+		{Path: "/projects/locked", Permissions: []string{acl.PermRead}},
+		{Path: "/projects/secret", Permissions: nil},
+		{Path: "/archive/drafts/locked", Permissions: []string{acl.PermRead}},
+	}
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		target  string
+		body    *types.ActionRequest
+		want    int
+		gone    string // Removed by the request, if allowed
+	}{
+		{"delete a parent", HandleDelete, "/api/delete?path=projects", nil, http.StatusForbidden, "projects"},
+		{"delete the folder", HandleDelete, "/api/delete?path=projects/locked", nil, http.StatusForbidden, "projects/locked"},
+		{"delete next to it", HandleDelete, "/api/delete?path=projects/a.txt", nil, http.StatusOK, "projects/a.txt"},
+		{"rename a parent", HandleRename, "/api/rename", &types.ActionRequest{SourcePath: "projects", NewName: "old"}, http.StatusForbidden, "projects"},
+		{"move a parent", HandleMove, "/api/move", &types.ActionRequest{SourcePath: "projects", DestPath: "archive"}, http.StatusForbidden, "projects"},
+		{"move onto a protected path", HandleMove, "/api/move", &types.ActionRequest{SourcePath: "drafts", DestPath: "archive"}, http.StatusForbidden, "drafts"},
+		{"copy an unreadable folder", HandleCopy, "/api/copy", &types.ActionRequest{SourcePath: "projects", DestPath: "archive"}, http.StatusForbidden, ""},
+		{"copy a readable folder", HandleCopy, "/api/copy", &types.ActionRequest{SourcePath: "projects/locked", DestPath: "archive"}, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setup(t)
+			writeFiles(t, "projects/a.txt", "projects/locked/b.txt", "projects/secret/c.txt", "drafts/locked/d.txt")
+			login(t, "admin", users.RoleAdmin, users.Change{})
+			bob := login(t, "bob", users.RoleUser, users.Change{Rules: &rules})
+
+			method := http.MethodPost
+			if tt.body == nil {
+				method = http.MethodDelete
+			}
+			w := call(tt.handler, bob, method, tt.target, tt.body)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if tt.gone != "" && exists(tt.gone) != (tt.want != http.StatusOK) {
+				t.Errorf("%s exists = %v after status %d", tt.gone, exists(tt.gone), w.Code)
+			}
+		})
+	}
+}
+
+// TestCopyLeavesOutInternalFiles checks that temporary files of writes in
+// progress aren't copied along with their folder
+func TestCopyLeavesOutInternalFiles(t *testing.T) {
+	setup(t)
+	writeFiles(t, "docs/a.txt", "docs/.gofiles-123.tmp")
+	admin := login(t, "admin", users.RoleAdmin, users.Change{})
+
+	w := call(HandleCopy, admin, http.MethodPost, "/api/copy", &types.ActionRequest{SourcePath: "docs", DestPath: "backup"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	if !exists("backup/docs/a.txt") {
+		t.Error("file not copied")
+	}
+	if exists("backup/docs/.gofiles-123.tmp") {
+		t.Error("temporary file copied")
+	}
+}
+
+// TestOpsReportErrors checks that a failed rename, move or copy isn't
+// reported as a success
+func TestOpsReportErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		body    types.ActionRequest
+		want    int
+	}{
+		{"rename a missing file", HandleRename, types.ActionRequest{SourcePath: "media/missing.txt", NewName: "b.txt"}, http.StatusNotFound},
+		{"move a missing file", HandleMove, types.ActionRequest{SourcePath: "media/missing.txt", DestPath: "media/docs"}, http.StatusNotFound},
+		{"move next to the mounts", HandleMove, types.ActionRequest{SourcePath: "media/a.txt", DestPath: "/"}, http.StatusForbidden},
+		{"copy next to the mounts", HandleCopy, types.ActionRequest{SourcePath: "media/a.txt", DestPath: "/"}, http.StatusForbidden},
+		{"copy", HandleCopy, types.ActionRequest{SourcePath: "media/a.txt", DestPath: "media/docs"}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setup(t)
+			storage.Root = storage.NewMountFS(map[string]storage.FS{"media": storage.NewMemory()})
+			writeFiles(t, "media/a.txt", "media/docs/b.txt")
+			admin := login(t, "admin", users.RoleAdmin, users.Change{})
+
+			w := call(tt.handler, admin, http.MethodPost, "/", tt.body)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
@@ -7,32 +7,30 @@ import (
 	"io"
 	"io/fs"
 	"net/http"
-	"os"
+	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 
-	"GoFiles/internal/config"
+	"GoFiles/internal/acl"
+	"GoFiles/internal/storage"
 	"GoFiles/internal/types"
-	"GoFiles/internal/utils"
 )
 
 // HandleListFiles displays files in a folder, with optional filtering
 func HandleListFiles(w http.ResponseWriter, r *http.Request) {
-	utils.EnableCors(&w)
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	reqPath := r.URL.Query().Get("path")
-	fullPath := filepath.Join(config.RootFolder, reqPath)
-
-	if !utils.IsPathSafe(fullPath) {
+	reqPath, ok := storage.Clean(r.URL.Query().Get("path"))
+	if !ok || !canSee(r, reqPath) {
 		http.Error(w, "Access Denied", http.StatusForbidden)
 		return
 	}
+	fsys := userFS(r)
 
 	// --- FILTERING PARAMETERS ---
 	filterExt := strings.ToLower(r.URL.Query().Get("ext")) // e.g. ".jpg"
@@ -43,7 +41,7 @@ func HandleListFiles(w http.ResponseWriter, r *http.Request) {
 		minSize, _ = strconv.ParseInt(minSizeStr, 10, 64)
 	}
 
-	files, err := os.ReadDir(fullPath)
+	files, err := fsys.ReadDir(reqPath)
 	if err != nil {
 		http.Error(w, "Unable to read directory", http.StatusNotFound)
 		return
@@ -53,6 +51,11 @@ func HandleListFiles(w http.ResponseWriter, r *http.Request) {
 	for _, f := range files {
 		info, _ := f.Info()
 
+		// Hide entries the user has no access to
+		if !canSee(r, path.Join(reqPath, f.Name())) {
+			continue
+		}
+
 		// --- APPLY FILTERS ---
 		if filterExt != "" && strings.ToLower(filepath.Ext(f.Name())) != filterExt {
 			continue
@@ -76,38 +79,45 @@ func HandleListFiles(w http.ResponseWriter, r *http.Request) {
 
 // HandleSearch performs recursive search for Name or Content
 func HandleSearch(w http.ResponseWriter, r *http.Request) {
-	utils.EnableCors(&w)
 	if r.Method != http.MethodGet {
 		return
 	}
 
 	query := strings.ToLower(r.URL.Query().Get("q"))
 	searchType := r.URL.Query().Get("type") // "name" or "content"
-	startPath := r.URL.Query().Get("path")
+	startPath, ok := storage.Clean(r.URL.Query().Get("path"))
 
 	if query == "" {
 		http.Error(w, "Query is empty", http.StatusBadRequest)
 		return
 	}
 
-	fullStartPath := filepath.Join(config.RootFolder, startPath)
-	if !utils.IsPathSafe(fullStartPath) {
+	if !ok || !canSee(r, startPath) {
 		http.Error(w, "Access Denied", http.StatusForbidden)
 		return
 	}
+	fsys := userFS(r)
 
 	var results []types.FileInfo
 
-	err := filepath.WalkDir(fullStartPath, func(path string, d fs.DirEntry, err error) error {
+	err := storage.WalkDir(fsys, startPath, func(rootRelPath string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return nil
 		}
 
-		relPath, _ := filepath.Rel(fullStartPath, path)
+		relPath := storage.Rel(startPath, rootRelPath)
 		if relPath == "." {
 			return nil
 		}
 
+		// Don't reveal (or descend into) entries the user can't see
+		if !canSee(r, rootRelPath) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
 		// --- NAME SEARCH ---
 		if searchType == "name" {
 			if strings.Contains(strings.ToLower(d.Name()), query) {
@@ -123,13 +133,13 @@ func HandleSearch(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// --- CONTENT SEARCH ---
-		if searchType == "content" && !d.IsDir() {
+		if searchType == "content" && !d.IsDir() && canRead(r, rootRelPath) {
 			info, _ := d.Info()
 			if info.Size() > 5*1024*1024 {
 				return nil
 			} // Skip > 5MB
 
-			file, err := os.Open(path)
+			file, err := fsys.Open(rootRelPath)
 			if err == nil {
 				scanner := bufio.NewScanner(file)
 				for scanner.Scan() {
@@ -163,17 +173,32 @@ func HandleSearch(w http.ResponseWriter, r *http.Request) {
 }
 
 func HandleDownloadFile(w http.ResponseWriter, r *http.Request) {
-	utils.EnableCors(&w)
 	if r.Method != http.MethodGet {
 		return
 	}
 
-	reqPath := r.URL.Query().Get("path")
-	fullPath := filepath.Join(config.RootFolder, reqPath)
-
-	if !utils.IsPathSafe(fullPath) {
+	reqPath, ok := storage.Clean(r.URL.Query().Get("path"))
+	if !ok {
 		http.Error(w, "Access Denied", http.StatusForbidden)
 		return
 	}
-	http.ServeFile(w, r, fullPath)
+	if !authorize(w, r, reqPath, acl.PermRead) {
+		return
+	}
+
+	file, err := userFS(r).Open(reqPath)
+	if err != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		http.Error(w, "Not a file", http.StatusBadRequest)
+		return
+	}
+	// ServeContent handles Range requests and the If-* headers
+	w.Header().Set("ETag", storage.ETag(info))
+	http.ServeContent(w, r, info.Name(), info.ModTime(), file)
 }
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"testing"
+
+	"GoFiles/internal/acl"
+	"GoFiles/internal/types"
+	"GoFiles/internal/users"
+)
+
+// TestReadFollowsRules checks that listings, searches and downloads only
+// show what the caller's rules let them read
+func TestReadFollowsRules(t *testing.T) {
+	setup(t)
+	rules := []types.ACLRule{
+		{Path: "/projects", Permissions: []string{acl.PermRead}},
+		{Path: "/projects/secret", Permissions: nil},
+		{Path: "/shared/team", Permissions: []string{acl.PermRead}},
+	}
+	writeFiles(t, "projects/plan.txt", "projects/secret/plan.txt", "shared/team/plan.txt", "shared/other/plan.txt", "private/plan.txt")
+	login(t, "admin", users.RoleAdmin, users.Change{})
+	bob := login(t, "bob", users.RoleUser, users.Change{Rules: &rules})
+
+	names := func(w *http.Response) []string {
+		var files []types.FileInfo
+		json.NewDecoder(w.Body).Decode(&files)
+		var names []string
+		for _, f := range files {
+			names = append(names, f.Name)
+		}
+		slices.Sort(names)
+		return names
+	}
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		target  string
+		want    int
+		names   []string // The listed or found entries
+	}{
+		{"list the root", HandleListFiles, "/?path=/", http.StatusOK, []string{"projects", "shared"}},
+		{"list a parent of a readable folder", HandleListFiles, "/?path=shared", http.StatusOK, []string{"team"}},
+		{"list a readable folder", HandleListFiles, "/?path=projects", http.StatusOK, []string{"plan.txt"}},
+		{"list a hidden folder", HandleListFiles, "/?path=private", http.StatusForbidden, nil},
+		{"search names", HandleSearch, "/?path=/&q=plan&type=name", http.StatusOK, []string{"projects/plan.txt", "shared/team/plan.txt"}},
+		{"search content", HandleSearch, "/?path=/&q=plan&type=content", http.StatusOK, []string{"projects/plan.txt", "shared/team/plan.txt"}},
+		{"download a readable file", HandleDownloadFile, "/?path=projects/plan.txt", http.StatusOK, nil},
+		{"download a denied file", HandleDownloadFile, "/?path=projects/secret/plan.txt", http.StatusForbidden, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := call(tt.handler, bob, http.MethodGet, tt.target, nil)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if tt.names != nil {
+				if got := names(w.Result()); !slices.Equal(got, tt.names) {
+					t.Errorf("entries = %v, want %v", got, tt.names)
+				}
+			}
+		})
+	}
+}
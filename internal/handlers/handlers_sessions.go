@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"GoFiles/internal/auth"
+	"GoFiles/internal/session"
+	"GoFiles/internal/types"
+)
+
+// HandleListSessions returns the caller's active sessions
+func HandleListSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		return
+	}
+
+	currentID := auth.CurrentSessionID(r)
+	list := []types.SessionInfo{}
+	for _, s := range session.ListFor(auth.CurrentUser(r).Username) {
+		list = append(list, types.SessionInfo{
+			ID:        s.ID,
+			CreatedAt: s.CreatedAt,
+			LastSeen:  s.LastSeen,
+			ExpiresAt: s.ExpiresAt,
+			IP:        s.IP,
+			UserAgent: s.UserAgent,
+			Current:   s.ID == currentID,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// HandleRevokeSession ends one of the caller's sessions,
+// or all other sessions when id=others
+func HandleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		return
+	}
+
+	username := auth.CurrentUser(r).Username
+	id := r.URL.Query().Get("id")
+
+	if id == "others" {
+		session.RevokeAll(username, auth.CurrentSessionID(r))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if !session.Revoke(username, id) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"GoFiles/internal/session"
+	"GoFiles/internal/types"
+	"GoFiles/internal/users"
+)
+
+func TestSessions(t *testing.T) {
+	setup(t)
+	// Sessions stay in memory between tests, so this user is used nowhere else
+	dana := login(t, "dana", users.RoleUser, users.Change{})
+	phone, _, _ := session.Create("dana", "127.0.0.1", "phone")
+	tablet, _, _ := session.Create("dana", "127.0.0.1", "tablet")
+	alice := login(t, "alice", users.RoleUser, users.Change{})
+
+	// 1. Users only see their own sessions, with the current one marked
+	w := call(HandleListSessions, dana, http.MethodGet, "/", nil)
+	var list []types.SessionInfo
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 3 {
+		t.Fatalf("listed %d sessions, want 3", len(list))
+	}
+	var phoneID string
+	current := 0
+	for _, s := range list {
+		if s.Current {
+			current++
+		}
+		if s.UserAgent == "phone" {
+			phoneID = s.ID
+		}
+	}
+	if current != 1 {
+		t.Errorf("%d sessions marked current, want 1", current)
+	}
+
+	// 2. Users can't revoke other users' sessions
+	if w := call(HandleRevokeSession, alice, http.MethodPost, "/?id="+phoneID, nil); w.Code != http.StatusNotFound {
+		t.Errorf("revoking another user's session: %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if w := call(HandleRevokeSession, dana, http.MethodPost, "/?id="+phoneID, nil); w.Code != http.StatusOK {
+		t.Fatalf("revoke: %d %s", w.Code, w.Body)
+	}
+	if _, ok := session.Validate(phone); ok {
+		t.Error("revoked session still valid")
+	}
+
+	// 3. Revoking the others keeps the current session
+	if w := call(HandleRevokeSession, dana, http.MethodPost, "/?id=others", nil); w.Code != http.StatusOK {
+		t.Fatalf("revoke others: %d %s", w.Code, w.Body)
+	}
+	if _, ok := session.Validate(tablet); ok {
+		t.Error("other session survived")
+	}
+	if _, ok := session.Validate(dana.Value); !ok {
+		t.Error("current session revoked")
+	}
+	if _, ok := session.Validate(alice.Value); !ok {
+		t.Error("another user's session revoked")
+	}
+}
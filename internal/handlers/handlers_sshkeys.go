@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"GoFiles/internal/auth"
+	"GoFiles/internal/types"
+	"GoFiles/internal/users"
+)
+
+// HandleListSSHKeys returns the public keys the caller can use for SFTP
+func HandleListSSHKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(users.SSHKeys(auth.CurrentUser(r).Username))
+}
+
+// HandleAddSSHKey registers a public key for the caller
+func HandleAddSSHKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		return
+	}
+
+	var req types.AddSSHKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	key, err := users.AddSSHKey(auth.CurrentUser(r).Username, req.Name, req.Key)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(key)
+}
+
+// HandleRemoveSSHKey deletes one of the caller's public keys
+func HandleRemoveSSHKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		return
+	}
+
+	fingerprint := r.URL.Query().Get("fingerprint")
+	if err := users.RemoveSSHKey(auth.CurrentUser(r).Username, fingerprint); err != nil {
+		writeUserError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"GoFiles/internal/types"
+	"GoFiles/internal/users"
+)
+
+const testKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIGr6FXn0zL6dDUW6xWdXwJqz5B0kq0xvP2m+q3gc0tyr laptop"
+
+func TestSSHKeys(t *testing.T) {
+	setup(t)
+	alice := login(t, "alice", users.RoleUser, users.Change{})
+	bob := login(t, "bob", users.RoleUser, users.Change{})
+
+	// 1. Adding checks the key and names it after its comment
+	if w := call(HandleAddSSHKey, bob, http.MethodPost, "/", types.AddSSHKeyRequest{Key: "ssh-ed25519 not-a-key"}); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid key: %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	w := call(HandleAddSSHKey, bob, http.MethodPost, "/", types.AddSSHKeyRequest{Key: testKey})
+	var key types.SSHKey
+	if err := json.NewDecoder(w.Body).Decode(&key); err != nil || w.Code != http.StatusCreated {
+		t.Fatalf("add: %d %v", w.Code, err)
+	}
+	if key.Name != "laptop" || key.Fingerprint == "" {
+		t.Errorf("added key = %+v", key)
+	}
+	if w := call(HandleAddSSHKey, bob, http.MethodPost, "/", types.AddSSHKeyRequest{Key: testKey}); w.Code != http.StatusConflict {
+		t.Errorf("adding twice: %d, want %d", w.Code, http.StatusConflict)
+	}
+
+	// 2. Keys are listed and removed per user
+	var list []types.SSHKey
+	json.NewDecoder(call(HandleListSSHKeys, alice, http.MethodGet, "/", nil).Body).Decode(&list)
+	if len(list) != 0 {
+		t.Errorf("alice sees %d of bob's keys", len(list))
+	}
+	target := "/?fingerprint=" + url.QueryEscape(key.Fingerprint)
+	if w := call(HandleRemoveSSHKey, alice, http.MethodPost, target, nil); w.Code != http.StatusNotFound {
+		t.Errorf("removing another user's key: %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if w := call(HandleRemoveSSHKey, bob, http.MethodPost, target, nil); w.Code != http.StatusOK {
+		t.Fatalf("remove: %d %s", w.Code, w.Body)
+	}
+	if keys := users.SSHKeys("bob"); len(keys) != 0 {
+		t.Errorf("%d keys left after removing", len(keys))
+	}
+}
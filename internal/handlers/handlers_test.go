@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+
+	"GoFiles/internal/auth"
+	"GoFiles/internal/config"
+	"GoFiles/internal/session"
+	"GoFiles/internal/storage"
+	"GoFiles/internal/types"
+	"GoFiles/internal/users"
+)
+
+// setup starts a test with no accounts and an empty storage root
+func setup(t *testing.T) {
+	t.Chdir(t.TempDir()) // The config and key files live in the working directory
+	config.AppConfig = types.ConfigFile{}
+	storage.Root = storage.NewMemory()
+	auth.Init()
+}
+
+// login creates an account and returns a session cookie for it
+func login(t *testing.T, username, role string, change users.Change) *http.Cookie {
+	t.Helper()
+	if _, err := users.Create(username, "password1", role); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.Modify(username, change); err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := session.Create(username, "127.0.0.1", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Cookie{Name: "session_token", Value: token}
+}
+
+// call runs handler behind AuthMiddleware. A non-nil body is sent as JSON.
+func call(handler http.HandlerFunc, cookie *http.Cookie, method, target string, body any) *httptest.ResponseRecorder {
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	r := httptest.NewRequest(method, target, bytes.NewReader(data))
+	r.AddCookie(cookie)
+	w := httptest.NewRecorder()
+	auth.AuthMiddleware(handler)(w, r)
+	return w
+}
+
+// writeFiles creates files (and their folders) in the storage root
+func writeFiles(t *testing.T, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		if err := storage.Root.MkdirAll(path.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := storage.WriteFile(storage.Root, name, []byte(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// exists reports whether name is in the storage root
+func exists(name string) bool {
+	_, err := storage.Root.Stat(name)
+	return err == nil
+}
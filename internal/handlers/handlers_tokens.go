@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"GoFiles/internal/auth"
+	"GoFiles/internal/config"
+	"GoFiles/internal/tokens"
+	"GoFiles/internal/types"
+	"GoFiles/internal/users"
+)
+
+// HandleListTokens returns the caller's API tokens
+func HandleListTokens(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens.List(auth.CurrentUser(r).Username))
+}
+
+// HandleCreateToken issues a personal access token. The secret is only returned once.
+func HandleCreateToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		return
+	}
+
+	var req types.CreateTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.Scope == "" {
+		req.Scope = tokens.ScopeRead
+	}
+
+	// A token can't grant more than its creator has
+	user := auth.CurrentUser(r)
+	maxScope := tokens.ScopeWrite
+	if user.Role == users.RoleAdmin {
+		maxScope = tokens.ScopeAdmin
+	}
+	if scope := auth.CurrentScope(r); scope != "" && tokens.Rank(scope) < tokens.Rank(maxScope) {
+		maxScope = scope
+	}
+	if tokens.Rank(req.Scope) > tokens.Rank(maxScope) {
+		http.Error(w, "Scope exceeds your permissions", http.StatusForbidden)
+		return
+	}
+	if req.ExpiresInDays < 0 {
+		http.Error(w, "Invalid expiry", http.StatusBadRequest)
+		return
+	}
+
+	expiresIn := time.Duration(req.ExpiresInDays) * 24 * time.Hour
+	secret, token, err := tokens.Create(user.Username, req.Name, req.Scope, expiresIn)
+	if err != nil {
+		if err == tokens.ErrInvalidName || err == tokens.ErrInvalidScope {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			http.Error(w, "Failed to save token", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	token.Hash = ""
+	token.S3Secret = ""
+	resp := types.CreateTokenResponse{Token: secret, Detail: token}
+	if config.S3APIListen != "" {
+		resp.S3AccessKey = token.ID
+		resp.S3SecretKey = tokens.S3Secret(token.ID)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// HandleRevokeToken deletes one of the caller's API tokens
+func HandleRevokeToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	if err := tokens.Revoke(auth.CurrentUser(r).Username, id); err != nil {
+		if err == tokens.ErrNotFound {
+			http.Error(w, "Token not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to save config", http.StatusInternalServerError)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
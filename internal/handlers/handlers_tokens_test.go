@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"GoFiles/internal/config"
+	"GoFiles/internal/tokens"
+	"GoFiles/internal/types"
+	"GoFiles/internal/users"
+)
+
+func TestCreateToken(t *testing.T) {
+	setup(t)
+	if err := tokens.Init(); err != nil {
+		t.Fatal(err)
+	}
+	admin := login(t, "admin", users.RoleAdmin, users.Change{})
+	bob := login(t, "bob", users.RoleUser, users.Change{})
+
+	tests := []struct {
+		name   string
+		cookie *http.Cookie
+		req    types.CreateTokenRequest
+		want   int
+	}{
+		{"default scope", bob, types.CreateTokenRequest{Name: "laptop"}, http.StatusCreated},
+		{"write", bob, types.CreateTokenRequest{Name: "laptop", Scope: tokens.ScopeWrite}, http.StatusCreated},
+		{"admin scope for a user", bob, types.CreateTokenRequest{Name: "laptop", Scope: tokens.ScopeAdmin}, http.StatusForbidden},
+		{"admin scope for an admin", admin, types.CreateTokenRequest{Name: "scripts", Scope: tokens.ScopeAdmin}, http.StatusCreated},
+		{"no name", bob, types.CreateTokenRequest{Name: " "}, http.StatusBadRequest},
+		{"unknown scope", bob, types.CreateTokenRequest{Name: "laptop", Scope: "root"}, http.StatusBadRequest},
+		{"negative expiry", bob, types.CreateTokenRequest{Name: "laptop", ExpiresInDays: -1}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := call(HandleCreateToken, tt.cookie, http.MethodPost, "/", tt.req)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if w.Code != http.StatusCreated {
+				return
+			}
+			var resp types.CreateTokenResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := tokens.Validate(resp.Token); !ok {
+				t.Error("returned secret isn't valid")
+			}
+			if resp.Detail.Hash != "" || resp.Detail.S3Secret != "" || resp.S3SecretKey != "" {
+				t.Errorf("response leaks stored secrets or S3 keys: %+v", resp)
+			}
+		})
+	}
+
+	// The S3 keys are only returned when the S3 API is enabled
+	saved := config.S3APIListen
+	defer func() { config.S3APIListen = saved }()
+	config.S3APIListen = ":9000"
+	w := call(HandleCreateToken, bob, http.MethodPost, "/", types.CreateTokenRequest{Name: "s3"})
+	var resp types.CreateTokenResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.S3AccessKey != resp.Detail.ID || resp.S3SecretKey != tokens.S3Secret(resp.Detail.ID) || resp.S3SecretKey == "" {
+		t.Errorf("S3 keys = %q, %q", resp.S3AccessKey, resp.S3SecretKey)
+	}
+}
+
+func TestRevokeToken(t *testing.T) {
+	setup(t)
+	if err := tokens.Init(); err != nil {
+		t.Fatal(err)
+	}
+	alice := login(t, "alice", users.RoleUser, users.Change{})
+	bob := login(t, "bob", users.RoleUser, users.Change{})
+	secret, token, _ := tokens.Create("bob", "laptop", tokens.ScopeRead, 0)
+
+	if w := call(HandleRevokeToken, alice, http.MethodPost, "/?id="+token.ID, nil); w.Code != http.StatusNotFound {
+		t.Errorf("revoking another user's token: %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if w := call(HandleRevokeToken, bob, http.MethodPost, "/?id="+token.ID, nil); w.Code != http.StatusOK {
+		t.Fatalf("revoke: %d %s", w.Code, w.Body)
+	}
+	if _, ok := tokens.Validate(secret); ok {
+		t.Error("revoked token still valid")
+	}
+
+	var list []types.APIToken
+	json.NewDecoder(call(HandleListTokens, bob, http.MethodGet, "/", nil).Body).Decode(&list)
+	if len(list) != 0 {
+		t.Errorf("listed %d tokens after revoking, want 0", len(list))
+	}
+}
@@ -2,31 +2,33 @@ package handlers
 
 import (
 	"encoding/json"
-	"io/ioutil"
 	"net/http"
-	"os"
-	"path/filepath"
+	"path"
 	"strings"
 
-	"GoFiles/internal/config"
+	"GoFiles/internal/acl"
+	"GoFiles/internal/storage"
 	"GoFiles/internal/trash"
 	"GoFiles/internal/types"
-	"GoFiles/internal/utils"
 )
 
 func HandleListTrash(w http.ResponseWriter, r *http.Request) {
-	utils.EnableCors(&w)
-	trashRoot := filepath.Join(config.RootFolder, config.TrashFolder)
-
-	files, _ := ioutil.ReadDir(trashRoot)
+	fsys := userFS(r)
 
 	var trashList []types.TrashInfo
-	for _, f := range files {
-		if strings.HasSuffix(f.Name(), ".json") {
-			metaBytes, _ := ioutil.ReadFile(filepath.Join(trashRoot, f.Name()))
-			var meta types.TrashInfo
-			json.Unmarshal(metaBytes, &meta)
-			trashList = append(trashList, meta)
+	for _, dir := range trash.Folders(fsys) {
+		files, _ := fsys.ReadDir(dir)
+		for _, f := range files {
+			if strings.HasSuffix(f.Name(), ".json") {
+				metaBytes, _ := storage.ReadFile(fsys, path.Join(dir, f.Name()))
+				var meta types.TrashInfo
+				json.Unmarshal(metaBytes, &meta)
+				// Only show items the user could see at their original location
+				if !canSee(r, meta.OriginalPath) {
+					continue
+				}
+				trashList = append(trashList, meta)
+			}
 		}
 	}
 
@@ -35,7 +37,6 @@ func HandleListTrash(w http.ResponseWriter, r *http.Request) {
 }
 
 func HandleRestore(w http.ResponseWriter, r *http.Request) {
-	utils.EnableCors(&w)
 	if r.Method != http.MethodPost {
 		return
 	}
@@ -47,17 +48,34 @@ func HandleRestore(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	trash.RestoreFromTrash(trashFilename)
+	// Restoring writes back to the original location
+	fsys := userFS(r)
+	meta, err := trash.ReadMeta(fsys, trashFilename)
+	if err != nil {
+		http.Error(w, "Not found in trash", http.StatusNotFound)
+		return
+	}
+	if !authorize(w, r, meta.OriginalPath, acl.PermWrite) {
+		return
+	}
+
+	trash.RestoreFromTrash(fsys, trashFilename)
 	w.WriteHeader(http.StatusOK)
 }
 
 func HandleEmptyTrash(w http.ResponseWriter, r *http.Request) {
-	utils.EnableCors(&w)
 	if r.Method != http.MethodPost {
 		return
 	}
+	// Emptying destroys everyone's deleted files
+	if !authorize(w, r, "/", acl.PermDelete) {
+		return
+	}
 
-	os.RemoveAll(filepath.Join(config.RootFolder, config.TrashFolder))
-	os.Mkdir(filepath.Join(config.RootFolder, config.TrashFolder), 0755)
+	fsys := userFS(r)
+	for _, dir := range trash.Folders(fsys) {
+		fsys.RemoveAll(dir)
+		fsys.Mkdir(dir, 0755)
+	}
 	w.WriteHeader(http.StatusOK)
 }
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"GoFiles/internal/acl"
+	"GoFiles/internal/storage"
+	"GoFiles/internal/trash"
+	"GoFiles/internal/types"
+	"GoFiles/internal/users"
+)
+
+// TestTrashFollowsRules checks that users only see and restore deleted
+// items they could use at their original location
+func TestTrashFollowsRules(t *testing.T) {
+	setup(t)
+	all := []string{acl.PermRead, acl.PermWrite, acl.PermDelete}
+	rules := []types.ACLRule{
+		{Path: "/", Permissions: all},
+		{Path: "/docs/locked", Permissions: []string{acl.PermRead}},
+		{Path: "/secret", Permissions: nil},
+	}
+	login(t, "admin", users.RoleAdmin, users.Change{})
+	bob := login(t, "bob", users.RoleUser, users.Change{Rules: &rules})
+	writeFiles(t, "docs/a.txt", "docs/locked/b.txt", "secret/c.txt")
+	for _, name := range []string{"docs/a.txt", "docs/locked/b.txt", "secret/c.txt"} {
+		if err := trash.MoveToTrash(storage.Root, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var list []types.TrashInfo
+	json.NewDecoder(call(HandleListTrash, bob, http.MethodGet, "/", nil).Body).Decode(&list)
+	trashed := map[string]string{} // Original path to trash name
+	for _, item := range list {
+		trashed[item.OriginalPath] = item.Filename
+	}
+	if len(trashed) != 2 || trashed["/docs/a.txt"] == "" || trashed["/docs/locked/b.txt"] == "" {
+		t.Fatalf("trash lists %v, want /docs/a.txt and /docs/locked/b.txt", trashed)
+	}
+
+	if w := call(HandleRestore, bob, http.MethodPost, "/?name="+trashed["/docs/locked/b.txt"], nil); w.Code != http.StatusForbidden {
+		t.Errorf("restore into a read-only folder: %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if w := call(HandleRestore, bob, http.MethodPost, "/?name="+trashed["/docs/a.txt"], nil); w.Code != http.StatusOK {
+		t.Fatalf("restore: %d %s", w.Code, w.Body)
+	}
+	if !exists("docs/a.txt") || exists("docs/locked/b.txt") {
+		t.Errorf("after restoring: a.txt %v, b.txt %v", exists("docs/a.txt"), exists("docs/locked/b.txt"))
+	}
+}
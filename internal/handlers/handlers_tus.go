@@ -0,0 +1,437 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"GoFiles/internal/acl"
+	"GoFiles/internal/auth"
+	"GoFiles/internal/config"
+	"GoFiles/internal/quota"
+	"GoFiles/internal/security"
+	"GoFiles/internal/storage"
+	"GoFiles/internal/types"
+	"GoFiles/internal/uploads"
+)
+
+// Resumable uploads with the tus protocol (https://tus.io). An upload is
+// staged as "tus-<id>/" in the uploads folder of the target's mount, holding
+// upload.json and one "chunk-<offset>" file per PATCH, and renamed into place
+// once all of it has arrived.
+
+const tusVersion = "1.0.0"
+const tusExtensions = "creation,creation-with-upload,termination,expiration"
+const tusContentType = "application/offset+octet-stream"
+const tusPrefix = "tus-"
+
+// TusPath is where uploads are created; each one lives at TusPath + "/<id>"
+const TusPath = "/api/tus"
+
+var errTooLong = errors.New("upload is longer than its Upload-Length")
+
+// Uploads being written to, so two PATCH requests can't append at once
+var tusBusy = map[string]bool{}
+var tusBusyMu sync.Mutex
+
+// HandleTus serves the tus protocol: POST creates an upload, HEAD reports
+// how much has been received, PATCH appends and DELETE cancels
+func HandleTus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Tus-Version", tusVersion)
+		w.Header().Set("Tus-Extension", tusExtensions)
+		if config.MaxUploadSize > 0 {
+			w.Header().Set("Tus-Max-Size", strconv.FormatInt(int64(config.MaxUploadSize), 10))
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		http.Error(w, "Unsupported tus version", http.StatusPreconditionFailed)
+		return
+	}
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, TusPath), "/")
+	switch {
+	case id == "" && r.Method == http.MethodPost:
+		createTusUpload(w, r)
+	case id != "" && r.Method == http.MethodHead:
+		headTusUpload(w, r, id)
+	case id != "" && r.Method == http.MethodPatch:
+		patchTusUpload(w, r, id)
+	case id != "" && r.Method == http.MethodDelete:
+		deleteTusUpload(w, r, id)
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// createTusUpload starts an upload. The metadata names the file ("filename")
+// and the folder it goes to ("path", the root by default).
+func createTusUpload(w http.ResponseWriter, r *http.Request) {
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "Invalid Upload-Length", http.StatusBadRequest)
+		return
+	}
+	metadata := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if metadata["filename"] == "" {
+		http.Error(w, "Upload-Metadata must include a filename", http.StatusBadRequest)
+		return
+	}
+
+	fsys := userFS(r)
+	dstPath, ok := storage.Clean(path.Join(metadata["path"], path.Base(metadata["filename"])))
+	if !ok || dstPath == "." || storage.IsInternal(fsys, dstPath) {
+		http.Error(w, "Access Denied", http.StatusForbidden)
+		return
+	}
+	if !authorize(w, r, dstPath, acl.PermWrite) {
+		return
+	}
+	if info, err := fsys.Stat(path.Dir(dstPath)); err != nil || !info.IsDir() {
+		http.Error(w, "Folder not found", http.StatusNotFound)
+		return
+	}
+	if info, err := fsys.Stat(dstPath); err == nil && info.IsDir() {
+		http.Error(w, "A folder with this name already exists", http.StatusConflict)
+		return
+	}
+	budget := newBudget(w, r, dstPath, true)
+	if budget == nil {
+		return
+	}
+	if err := budget.Check(length); err != nil {
+		writeLimitError(w, err)
+		return
+	}
+
+	// 1. Stage the upload next to its target
+	token, err := security.RandomToken(16)
+	if err != nil {
+		http.Error(w, "Failed to create upload", http.StatusInternalServerError)
+		return
+	}
+	id := tusPrefix + token
+	dir := path.Join(uploads.Dir(fsys, dstPath), id)
+	upload := types.TusUpload{
+		Username:  auth.CurrentUser(r).Username,
+		Path:      dstPath,
+		Length:    length,
+		Metadata:  r.Header.Get("Upload-Metadata"),
+		CreatedAt: time.Now(),
+	}
+	data, _ := json.MarshalIndent(upload, "", "  ")
+	if err := fsys.MkdirAll(dir, 0755); err != nil {
+		http.Error(w, "Failed to create upload", http.StatusInternalServerError)
+		return
+	}
+	if err := storage.WriteFile(fsys, path.Join(dir, "upload.json"), data); err != nil {
+		fsys.RemoveAll(dir)
+		http.Error(w, "Failed to create upload", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Location", TusPath+"/"+id)
+
+	// 2. The first chunk may come with the request (creation-with-upload)
+	var offset int64
+	if r.Header.Get("Content-Type") == tusContentType {
+		claimTusUpload(id)
+		defer releaseTusUpload(id)
+		offset, err = writeTusChunk(fsys, budget, dir, 0, length, r.Body)
+		if err == errTooLong {
+			fsys.RemoveAll(dir)
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if writeLimitError(w, err) {
+			return // What was received is kept, as for any interrupted chunk
+		}
+	}
+	if offset == length {
+		if !finishTusUpload(w, fsys, budget, dir, upload) {
+			return
+		}
+	} else {
+		w.Header().Set("Upload-Expires", uploads.ExpiresAt(fsys, dir).UTC().Format(http.TimeFormat))
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// headTusUpload tells the client where to resume
+func headTusUpload(w http.ResponseWriter, r *http.Request, id string) {
+	fsys := userFS(r)
+	w.Header().Set("Cache-Control", "no-store")
+	dir, upload, ok := openTusUpload(r, id)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(tusOffset(fsys, dir), 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	if upload.Metadata != "" {
+		w.Header().Set("Upload-Metadata", upload.Metadata)
+	}
+	w.Header().Set("Upload-Expires", uploads.ExpiresAt(fsys, dir).UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusOK)
+}
+
+// patchTusUpload appends a chunk at the offset the client says it resumes from
+func patchTusUpload(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Header.Get("Content-Type") != tusContentType {
+		http.Error(w, "Content-Type must be "+tusContentType, http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "Invalid Upload-Offset", http.StatusBadRequest)
+		return
+	}
+
+	fsys := userFS(r)
+	dir, upload, ok := openTusUpload(r, id)
+	if !ok {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+	if !authorize(w, r, upload.Path, acl.PermWrite) {
+		return
+	}
+	if !claimTusUpload(id) {
+		http.Error(w, "The upload is busy with another request", http.StatusLocked)
+		return
+	}
+	defer releaseTusUpload(id)
+
+	if current := tusOffset(fsys, dir); offset != current {
+		http.Error(w, "Upload-Offset does not match the received data", http.StatusConflict)
+		return
+	}
+
+	budget := newBudget(w, r, upload.Path, false)
+	if budget == nil {
+		return
+	}
+
+	// Whatever arrives is kept, even if the connection drops half way
+	n, err := writeTusChunk(fsys, budget, dir, offset, upload.Length-offset, r.Body)
+	switch {
+	case err == errTooLong:
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	case writeLimitError(w, err):
+		return
+	case err != nil && n == 0:
+		http.Error(w, "Failed to save chunk", http.StatusInternalServerError)
+		return
+	}
+	offset += n
+	if offset == upload.Length {
+		if !finishTusUpload(w, fsys, budget, dir, upload) {
+			return
+		}
+	} else {
+		w.Header().Set("Upload-Expires", uploads.ExpiresAt(fsys, dir).UTC().Format(http.TimeFormat))
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteTusUpload cancels an upload and throws away what was received
+func deleteTusUpload(w http.ResponseWriter, r *http.Request, id string) {
+	dir, _, ok := openTusUpload(r, id)
+	if !ok {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+	if !claimTusUpload(id) {
+		http.Error(w, "The upload is busy with another request", http.StatusLocked)
+		return
+	}
+	defer releaseTusUpload(id)
+	if err := userFS(r).RemoveAll(dir); err != nil {
+		http.Error(w, "Failed to delete upload", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// openTusUpload finds one of the caller's uploads
+func openTusUpload(r *http.Request, id string) (string, types.TusUpload, bool) {
+	var upload types.TusUpload
+	if !strings.HasPrefix(id, tusPrefix) || strings.ContainsAny(id, "/\\") || strings.Contains(id, "..") {
+		return "", upload, false
+	}
+	fsys := userFS(r)
+	dir, ok := uploads.Find(fsys, id)
+	if !ok {
+		return "", upload, false
+	}
+	data, err := storage.ReadFile(fsys, path.Join(dir, "upload.json"))
+	if err != nil || json.Unmarshal(data, &upload) != nil || upload.Username != auth.CurrentUser(r).Username {
+		return "", upload, false
+	}
+	return dir, upload, true
+}
+
+// claimTusUpload marks an upload as being written to, unless it already is
+func claimTusUpload(id string) bool {
+	tusBusyMu.Lock()
+	defer tusBusyMu.Unlock()
+	if tusBusy[id] {
+		return false
+	}
+	tusBusy[id] = true
+	return true
+}
+
+func releaseTusUpload(id string) {
+	tusBusyMu.Lock()
+	defer tusBusyMu.Unlock()
+	delete(tusBusy, id)
+}
+
+// tusChunks lists the received chunks in order
+func tusChunks(fsys storage.FS, dir string) ([]string, int64) {
+	entries, _ := fsys.ReadDir(dir)
+	var names []string
+	var size int64
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), "chunk-") {
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			names = append(names, entry.Name())
+			size += info.Size()
+		}
+	}
+	sort.Strings(names)
+	return names, size
+}
+
+// tusOffset is how much of an upload has been received
+func tusOffset(fsys storage.FS, dir string) int64 {
+	_, size := tusChunks(fsys, dir)
+	return size
+}
+
+// writeTusChunk stores a request body as the chunk starting at offset. Data
+// is kept if the body ends early or runs out of budget; only a body longer
+// than max is thrown away.
+func writeTusChunk(fsys storage.FS, budget *quota.Budget, dir string, offset, max int64, body io.Reader) (int64, error) {
+	name := path.Join(dir, fmt.Sprintf("chunk-%020d", offset))
+	out, err := fsys.Create(name)
+	if err != nil {
+		return 0, err
+	}
+	n, copyErr := io.Copy(budget.Writer(out), io.LimitReader(body, max))
+	if copyErr == nil {
+		if extra, _ := io.CopyN(io.Discard, body, 1); extra > 0 {
+			copyErr = errTooLong
+		}
+	}
+	if err := out.Close(); err != nil || n == 0 || copyErr == errTooLong {
+		fsys.Remove(name)
+		budget.Release(n)
+		if copyErr == errTooLong || quota.IsLimit(copyErr) {
+			return 0, copyErr
+		}
+		return 0, err
+	}
+	return n, copyErr
+}
+
+// finishTusUpload joins the chunks and moves the file into place. Writes an
+// error and returns false if that fails.
+func finishTusUpload(w http.ResponseWriter, fsys storage.FS, budget *quota.Budget, dir string, upload types.TusUpload) bool {
+	var replaced int64
+	if info, err := fsys.Stat(upload.Path); err == nil && info.IsDir() {
+		http.Error(w, "A folder with this name already exists", http.StatusConflict)
+		return false
+	} else if err == nil {
+		replaced = info.Size()
+	}
+
+	// A single chunk is the file; several are joined next to it first
+	chunks, _ := tusChunks(fsys, dir)
+	src := path.Join(dir, "file")
+	if len(chunks) == 1 {
+		src = path.Join(dir, chunks[0])
+	} else if err := joinChunks(fsys, budget, dir, chunks, src); err != nil {
+		// Some chunks are gone, so the upload can't be resumed. What it
+		// counted in the usage shows up on the next walk.
+		fsys.RemoveAll(dir)
+		if !writeLimitError(w, err) {
+			http.Error(w, "Failed to save file", http.StatusInternalServerError)
+		}
+		return false
+	}
+
+	if err := fsys.Rename(src, upload.Path); err != nil {
+		http.Error(w, "Failed to save file", http.StatusInternalServerError)
+		return false
+	}
+	fsys.RemoveAll(dir)
+	budget.Release(replaced)
+	return true
+}
+
+// joinChunks appends the chunks to dst in order, deleting each one once it
+// is copied: joining takes one chunk more room than the upload, not twice
+// as much
+func joinChunks(fsys storage.FS, budget *quota.Budget, dir string, chunks []string, dst string) error {
+	out, err := fsys.Create(dst)
+	if err != nil {
+		return err
+	}
+	w := budget.Writer(out)
+	for _, chunk := range chunks {
+		name := path.Join(dir, chunk)
+		in, err := fsys.Open(name)
+		if err != nil {
+			out.Close()
+			return err
+		}
+		n, err := io.Copy(w, in)
+		in.Close()
+		if err != nil {
+			out.Close()
+			return err
+		}
+		if err := fsys.Remove(name); err != nil {
+			out.Close()
+			return err
+		}
+		budget.Release(n)
+	}
+	return out.Close()
+}
+
+// parseTusMetadata decodes "key base64value,key2 base64value2"
+func parseTusMetadata(header string) map[string]string {
+	metadata := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			continue
+		}
+		metadata[key] = string(decoded)
+	}
+	return metadata
+}
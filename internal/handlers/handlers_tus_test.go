@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"GoFiles/internal/auth"
+	"GoFiles/internal/quota"
+	"GoFiles/internal/storage"
+	"GoFiles/internal/users"
+)
+
+// tusCall sends a tus request with a raw body
+func tusCall(cookie *http.Cookie, method, target string, headers map[string]string, body []byte) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, bytes.NewReader(body))
+	r.AddCookie(cookie)
+	r.Header.Set("Tus-Resumable", tusVersion)
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	auth.AuthMiddleware(HandleTus)(w, r)
+	return w
+}
+
+// TestTusUpload uploads a file in chunks over one that is already there,
+// close to the quota
+func TestTusUpload(t *testing.T) {
+	setup(t)
+	home, limit := "homes/tus", int64(100)
+	bob := login(t, "bob", users.RoleUser, users.Change{Home: &home, Quota: &limit})
+	storage.WriteFile(storage.Root, "homes/tus/a.txt", bytes.Repeat([]byte("o"), 40))
+	data := bytes.Repeat([]byte("n"), 40)
+
+	// 1. Create the upload
+	metadata := "filename " + base64.StdEncoding.EncodeToString([]byte("a.txt"))
+	w := tusCall(bob, http.MethodPost, TusPath, map[string]string{
+		"Upload-Length":   strconv.Itoa(len(data)),
+		"Upload-Metadata": metadata,
+	}, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", w.Code, w.Body)
+	}
+	location := w.Header().Get("Location")
+
+	// 2. Send it in two chunks, the second one after a wrong offset
+	patch := func(offset int, chunk []byte) *httptest.ResponseRecorder {
+		return tusCall(bob, http.MethodPatch, location, map[string]string{
+			"Content-Type":  tusContentType,
+			"Upload-Offset": strconv.Itoa(offset),
+		}, chunk)
+	}
+	if w := patch(0, data[:20]); w.Code != http.StatusNoContent || w.Header().Get("Upload-Offset") != "20" {
+		t.Fatalf("first chunk: %d %s", w.Code, w.Body)
+	}
+	if w := patch(0, data[20:]); w.Code != http.StatusConflict {
+		t.Errorf("chunk at the wrong offset: %d, want %d", w.Code, http.StatusConflict)
+	}
+	w = tusCall(bob, http.MethodHead, location, nil, nil)
+	if got := w.Header().Get("Upload-Offset"); got != "20" {
+		t.Errorf("HEAD offset = %s, want 20", got)
+	}
+	if w := patch(20, data[20:]); w.Code != http.StatusNoContent {
+		t.Fatalf("last chunk: %d %s", w.Code, w.Body)
+	}
+
+	// 3. The file is replaced, and only the new one counts
+	got, err := storage.ReadFile(storage.Root, "homes/tus/a.txt")
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("uploaded file = %q, %v", got, err)
+	}
+	user, _ := users.Get("bob")
+	if used := quota.Usage(user); used != 40 {
+		t.Errorf("usage = %d, want 40", used)
+	}
+	if w := tusCall(bob, http.MethodHead, location, nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("finished upload still there: %d", w.Code)
+	}
+}
+
+// TestTusLimits checks the checks made before and while receiving data
+func TestTusLimits(t *testing.T) {
+	setup(t)
+	home, limit := "homes/tus-limits", int64(100)
+	bob := login(t, "bob", users.RoleUser, users.Change{Home: &home, Quota: &limit})
+	metadata := "filename " + base64.StdEncoding.EncodeToString([]byte("big.bin"))
+
+	w := tusCall(bob, http.MethodPost, TusPath, map[string]string{"Upload-Length": "101", "Upload-Metadata": metadata}, nil)
+	if w.Code != http.StatusInsufficientStorage {
+		t.Errorf("upload over the quota: %d, want %d", w.Code, http.StatusInsufficientStorage)
+	}
+
+	w = tusCall(bob, http.MethodPost, TusPath, map[string]string{
+		"Upload-Length":   "10",
+		"Upload-Metadata": metadata,
+		"Content-Type":    tusContentType,
+	}, []byte(strings.Repeat("x", 11)))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("body longer than Upload-Length: %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+
+	w = tusCall(bob, http.MethodPost, TusPath, map[string]string{"Upload-Length": "10"}, nil)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("upload without a filename: %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"GoFiles/internal/auth"
+	"GoFiles/internal/types"
+	"GoFiles/internal/users"
+)
+
+// HandleTwoFactorEnroll starts TOTP enrollment and returns the secret / otpauth URI
+func HandleTwoFactorEnroll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		return
+	}
+
+	resp, err := users.EnrollTOTP(auth.CurrentUser(r).Username)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// HandleTwoFactorConfirm enables TOTP with a first valid code and returns recovery codes
+func HandleTwoFactorConfirm(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		return
+	}
+
+	var req types.TwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	codes, err := users.ConfirmTOTP(auth.CurrentUser(r).Username, req.Code)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(types.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// HandleTwoFactorDisable turns TOTP off. Requires the password and a current code.
+func HandleTwoFactorDisable(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		return
+	}
+
+	var req types.TwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	username := auth.CurrentUser(r).Username
+	if !auth.Recheck(w, r, func() error { return checkPassword(username, req.Password, req.Code, true) }) {
+		return
+	}
+
+	if err := users.DisableTOTP(username); err != nil {
+		writeUserError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// HandleRecoveryCodes replaces the caller's recovery codes. Requires the password.
+func HandleRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		return
+	}
+
+	var req types.TwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	username := auth.CurrentUser(r).Username
+	if !auth.Recheck(w, r, func() error { return checkPassword(username, req.Password, "", false) }) {
+		return
+	}
+
+	codes, err := users.RegenerateRecoveryCodes(username)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(types.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// checkPassword verifies a user's password, and their second factor if
+// withCode is set
+func checkPassword(username, password, code string, withCode bool) error {
+	if _, ok := users.Authenticate(username, password); !ok {
+		return users.ErrWrongPassword
+	}
+	if withCode && !users.VerifySecondFactor(username, code) {
+		return users.ErrInvalidCode
+	}
+	return nil
+}
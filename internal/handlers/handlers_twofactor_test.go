@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"GoFiles/internal/totp"
+	"GoFiles/internal/types"
+	"GoFiles/internal/users"
+)
+
+func TestTwoFactor(t *testing.T) {
+	setup(t)
+	bob := login(t, "bob", users.RoleUser, users.Change{})
+
+	// 1. Enrollment only takes effect with a valid code
+	var enroll types.TwoFactorEnrollResponse
+	json.NewDecoder(call(HandleTwoFactorEnroll, bob, http.MethodPost, "/", nil).Body).Decode(&enroll)
+	if enroll.Secret == "" {
+		t.Fatal("no secret returned")
+	}
+	if w := call(HandleTwoFactorConfirm, bob, http.MethodPost, "/", types.TwoFactorRequest{Code: "000000"}); w.Code != http.StatusForbidden {
+		t.Errorf("wrong code: %d, want %d", w.Code, http.StatusForbidden)
+	}
+	code, _ := totp.CodeAt(enroll.Secret, totp.Step(time.Now()))
+	w := call(HandleTwoFactorConfirm, bob, http.MethodPost, "/", types.TwoFactorRequest{Code: code})
+	var codes types.RecoveryCodesResponse
+	if err := json.NewDecoder(w.Body).Decode(&codes); err != nil || len(codes.RecoveryCodes) == 0 {
+		t.Fatalf("confirm: %d, no recovery codes", w.Code)
+	}
+	if w := call(HandleTwoFactorEnroll, bob, http.MethodPost, "/", nil); w.Code != http.StatusConflict {
+		t.Errorf("enrolling twice: %d, want %d", w.Code, http.StatusConflict)
+	}
+
+	// 2. New recovery codes need the password and replace the old ones
+	if w := call(HandleRecoveryCodes, bob, http.MethodPost, "/", types.TwoFactorRequest{Password: "wrong"}); w.Code != http.StatusForbidden {
+		t.Errorf("recovery codes with a wrong password: %d, want %d", w.Code, http.StatusForbidden)
+	}
+	old := codes.RecoveryCodes[0]
+	w = call(HandleRecoveryCodes, bob, http.MethodPost, "/", types.TwoFactorRequest{Password: "password1"})
+	if err := json.NewDecoder(w.Body).Decode(&codes); err != nil || len(codes.RecoveryCodes) == 0 {
+		t.Fatalf("recovery codes: %d, none returned", w.Code)
+	}
+
+	// 3. Turning 2FA off needs the password and a current code
+	tests := []struct {
+		name string
+		req  types.TwoFactorRequest
+		want int
+	}{
+		{"no code", types.TwoFactorRequest{Password: "password1"}, http.StatusForbidden},
+		{"replaced recovery code", types.TwoFactorRequest{Password: "password1", Code: old}, http.StatusForbidden},
+		{"wrong password", types.TwoFactorRequest{Password: "wrong", Code: codes.RecoveryCodes[0]}, http.StatusForbidden},
+		{"recovery code", types.TwoFactorRequest{Password: "password1", Code: codes.RecoveryCodes[0]}, http.StatusOK},
+		{"already off", types.TwoFactorRequest{Password: "password1", Code: codes.RecoveryCodes[1]}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := call(HandleTwoFactorDisable, bob, http.MethodPost, "/", tt.req); w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+	if user, _ := users.Get("bob"); user.TOTPSecret != "" {
+		t.Error("2FA still on")
+	}
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"GoFiles/frontend"
+)
+
+// uiFiles is the embedded build output, rooted at dist/
+var uiFiles, _ = fs.Sub(frontend.Dist, "dist")
+
+// uiETags maps every embedded file to a content hash. Embedded files have
+// no modification time, so this is what lets browsers revalidate.
+var uiETags = hashUIFiles()
+
+func hashUIFiles() map[string]string {
+	etags := map[string]string{}
+	fs.WalkDir(uiFiles, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(uiFiles, name)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		etags[name] = `"` + hex.EncodeToString(sum[:8]) + `"`
+		return nil
+	})
+	return etags
+}
+
+// HandleUI serves the embedded frontend. Paths that don't match a file and
+// have no extension fall back to index.html so client-side routes survive
+// a page reload.
+func HandleUI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// Unknown API routes must not turn into the app
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name == "" {
+		name = "index.html"
+	}
+	if serveUIFile(w, r, name) {
+		return
+	}
+	if path.Ext(name) != "" {
+		// A missing script or image should be a 404, not HTML
+		http.NotFound(w, r)
+		return
+	}
+	if !serveUIFile(w, r, "index.html") {
+		http.Error(w, "Frontend not built. Run `npm run build` in frontend/ and rebuild the server.", http.StatusNotFound)
+	}
+}
+
+// serveUIFile writes one embedded file with caching headers.
+// Returns false if there is no such file.
+func serveUIFile(w http.ResponseWriter, r *http.Request, name string) bool {
+	etag, ok := uiETags[name]
+	if !ok || strings.HasPrefix(path.Base(name), ".") {
+		return false
+	}
+	file, err := uiFiles.Open(name)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	// Vite puts content-hashed bundles in assets/, so those never change.
+	// Everything else (index.html, favicon) must be revalidated.
+	if strings.HasPrefix(name, "assets/") {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	w.Header().Set("ETag", etag)
+	http.ServeContent(w, r, name, time.Time{}, file.(io.ReadSeeker))
+	return true
+}
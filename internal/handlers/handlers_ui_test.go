@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+func TestUI(t *testing.T) {
+	savedFiles, savedETags := uiFiles, uiETags
+	defer func() { uiFiles, uiETags = savedFiles, savedETags }()
+	uiFiles = fstest.MapFS{
+		"index.html":         {Data: []byte("<html>app</html>")},
+		"assets/app-1a2b.js": {Data: []byte("app()")},
+		".gitkeep":           {},
+	}
+	uiETags = hashUIFiles()
+
+	tests := []struct {
+		name   string
+		target string
+		want   int
+		body   string
+		cache  string
+	}{
+		{"root", "/", http.StatusOK, "<html>app</html>", "no-cache"},
+		{"client route", "/files/docs", http.StatusOK, "<html>app</html>", "no-cache"},
+		{"asset", "/assets/app-1a2b.js", http.StatusOK, "app()", "public, max-age=31536000, immutable"},
+		{"missing asset", "/assets/gone.js", http.StatusNotFound, "", ""},
+		{"unknown API route", "/api/nothing", http.StatusNotFound, "", ""},
+		{"hidden file", "/.gitkeep", http.StatusNotFound, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			HandleUI(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d", w.Code, tt.want)
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+			if w.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", w.Body, tt.body)
+			}
+			if got := w.Header().Get("Cache-Control"); got != tt.cache {
+				t.Errorf("Cache-Control = %q, want %q", got, tt.cache)
+			}
+		})
+	}
+
+	// Browsers revalidate with the ETag
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("If-None-Match", uiETags["index.html"])
+	w := httptest.NewRecorder()
+	HandleUI(w, r)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("revalidation: %d, want %d", w.Code, http.StatusNotModified)
+	}
+}
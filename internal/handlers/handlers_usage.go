@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path"
+
+	"GoFiles/internal/auth"
+	"GoFiles/internal/config"
+	"GoFiles/internal/quota"
+	"GoFiles/internal/storage"
+	"GoFiles/internal/types"
+	"GoFiles/internal/users"
+)
+
+// HandleUsage reports how much the caller stores against their quota and the
+// room left on each disk. Admins can ask about anyone with ?username=.
+func HandleUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		return
+	}
+
+	user := auth.CurrentUser(r)
+	if name := r.URL.Query().Get("username"); name != "" && name != user.Username {
+		if user.Role != users.RoleAdmin {
+			http.Error(w, "Admin access required", http.StatusForbidden)
+			return
+		}
+		var found bool
+		if user, found = users.Get(name); !found {
+			http.Error(w, users.ErrUserNotFound.Error(), http.StatusNotFound)
+			return
+		}
+	}
+
+	resp := types.UsageResponse{
+		Username:      user.Username,
+		Used:          quota.Usage(user),
+		Quota:         quota.Of(user),
+		MaxUploadSize: int64(config.MaxUploadSize),
+	}
+	for _, root := range storage.MountRoots(users.Files(user)) {
+		if free, available, ok := quota.Space(user, root); ok {
+			resp.Disks = append(resp.Disks, types.DiskUsage{Path: path.Join("/", root), Free: free, Available: available})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// newBudget returns what the caller may write to relPath. Writes the limit
+// error and returns nil if nothing can be written there.
+func newBudget(w http.ResponseWriter, r *http.Request, relPath string, upload bool) *quota.Budget {
+	budget, err := quota.NewBudget(auth.CurrentUser(r), relPath, upload)
+	if err != nil {
+		writeLimitError(w, err)
+		return nil
+	}
+	return budget
+}
+
+// limitStatus is 413 for a file over the upload size, 507 for a full quota or disk
+func limitStatus(err error) int {
+	if errors.Is(err, quota.ErrTooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusInsufficientStorage
+}
+
+// writeLimitError answers {"error": ...} if err comes from a limit. Returns
+// false, writing nothing, for any other error.
+func writeLimitError(w http.ResponseWriter, err error) bool {
+	if !quota.IsLimit(err) {
+		return false
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(limitStatus(err))
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+	return true
+}
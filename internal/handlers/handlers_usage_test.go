@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"GoFiles/internal/types"
+	"GoFiles/internal/users"
+)
+
+func TestUsage(t *testing.T) {
+	setup(t)
+	home, limit := "homes/usage", int64(1000)
+	admin := login(t, "admin", users.RoleAdmin, users.Change{})
+	bob := login(t, "bob", users.RoleUser, users.Change{Home: &home, Quota: &limit})
+	login(t, "carol", users.RoleUser, users.Change{})
+	writeFiles(t, "homes/usage/a.txt", "homes/usage/docs/b.txt")
+
+	tests := []struct {
+		name   string
+		cookie *http.Cookie
+		target string
+		want   int
+		user   string
+		used   int64
+	}{
+		{"own usage", bob, "/", http.StatusOK, "bob", int64(len("homes/usage/a.txt") + len("homes/usage/docs/b.txt"))},
+		{"admin asks", admin, "/?username=bob", http.StatusOK, "bob", int64(len("homes/usage/a.txt") + len("homes/usage/docs/b.txt"))},
+		{"user asks about another", bob, "/?username=carol", http.StatusForbidden, "", 0},
+		{"missing user", admin, "/?username=nobody", http.StatusNotFound, "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := call(HandleUsage, tt.cookie, http.MethodGet, tt.target, nil)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+			var resp types.UsageResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Username != tt.user || resp.Used != tt.used || resp.Quota != limit {
+				t.Errorf("usage = %+v, want %s using %d of %d", resp, tt.user, tt.used, limit)
+			}
+		})
+	}
+}
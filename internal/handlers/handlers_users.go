@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"GoFiles/internal/acl"
+	"GoFiles/internal/auth"
+	"GoFiles/internal/config"
+	"GoFiles/internal/session"
+	"GoFiles/internal/tokens"
+	"GoFiles/internal/types"
+	"GoFiles/internal/users"
+)
+
+// HandleListUsers returns all accounts (admin only)
+func HandleListUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(users.List())
+}
+
+// HandleCreateUser adds a new account (admin only)
+func HandleCreateUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		return
+	}
+
+	var req types.UserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	change, err := userChange(req)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+	user, err := users.Create(req.Username, req.Password, req.Role)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+	// Rules, home and quota are applied after, removing the account if they are invalid
+	change.Password, change.Role = "", ""
+	if user, err = users.Modify(user.Username, change); err != nil {
+		users.Delete(req.Username)
+		writeUserError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(users.ToInfo(user))
+}
+
+// HandleUpdateUser changes another account (admin only). The whole change
+// is applied at once, or nothing if any part of it is invalid.
+func HandleUpdateUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		return
+	}
+
+	var req types.UserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	change, err := userChange(req)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+	// A reset password may mean the account was taken over: everything that
+	// was logged in or issued with the old one stops working
+	change.RemoveSSHKeys = req.Password != ""
+	user, err := users.Modify(req.Username, change)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+	if req.Password != "" {
+		session.RevokeAll(user.Username, "")
+		tokens.RevokeAll(user.Username)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(users.ToInfo(user))
+}
+
+// HandleDeleteUser removes an account (admin only)
+func HandleDeleteUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete && r.Method != http.MethodPost {
+		return
+	}
+
+	username := r.URL.Query().Get("username")
+	if username == auth.CurrentUser(r).Username {
+		http.Error(w, "You cannot delete your own account", http.StatusBadRequest)
+		return
+	}
+
+	if err := users.Delete(username); err != nil {
+		writeUserError(w, err)
+		return
+	}
+	session.RevokeAll(username, "")
+	tokens.RevokeAll(username)
+	w.WriteHeader(http.StatusOK)
+}
+
+// HandleChangePassword lets the caller change their own password
+func HandleChangePassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		return
+	}
+
+	var req types.ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	username := auth.CurrentUser(r).Username
+	if err := users.ChangePassword(username, req.OldPassword, req.NewPassword); err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	// Keep this session, log out everywhere else
+	session.RevokeAll(username, auth.CurrentSessionID(r))
+	w.WriteHeader(http.StatusOK)
+}
+
+// userChange turns a create or update request into a users.Change
+func userChange(req types.UserRequest) (users.Change, error) {
+	change := users.Change{
+		Password:       req.Password,
+		Role:           req.Role,
+		Rules:          req.Rules,
+		Home:           req.Home,
+		ResetTwoFactor: req.ResetTwoFactor,
+	}
+	if req.Quota != nil {
+		quota, err := parseQuota(req.Quota)
+		if err != nil {
+			return change, err
+		}
+		change.Quota = &quota
+	}
+	return change, nil
+}
+
+// parseQuota reads the quota of a user request ("10GB"); missing, empty and
+// "0" all mean the default quota
+func parseQuota(text *string) (int64, error) {
+	if text == nil || *text == "" {
+		return 0, nil
+	}
+	size, err := config.ParseSize(*text)
+	if err != nil {
+		return 0, users.ErrInvalidQuota
+	}
+	return int64(size), nil
+}
+
+// writeUserError maps user store errors to HTTP status codes
+func writeUserError(w http.ResponseWriter, err error) {
+	switch err {
+	case users.ErrInvalidInput, users.ErrInvalidName, users.ErrInvalidRole, users.ErrInvalidHome, users.ErrInvalidQuota, users.ErrQuotaNoHome, users.ErrInvalidSSHKey, acl.ErrInvalidRule:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case users.ErrWrongPassword, users.ErrInvalidCode:
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case users.ErrTwoFactorEnabled, users.ErrTwoFactorDisabled, users.ErrNoEnrollment:
+		http.Error(w, err.Error(), http.StatusConflict)
+	case users.ErrUserNotFound, users.ErrSSHKeyMissing:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case users.ErrUserExists, users.ErrLastAdmin, users.ErrSSHKeyExists:
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, "Failed to save config", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"GoFiles/internal/session"
+	"GoFiles/internal/tokens"
+	"GoFiles/internal/types"
+	"GoFiles/internal/users"
+)
+
+func TestCreateUser(t *testing.T) {
+	setup(t)
+	admin := login(t, "admin", users.RoleAdmin, users.Change{})
+	str := func(s string) *string { return &s }
+
+	tests := []struct {
+		name string
+		req  types.UserRequest
+		want int
+	}{
+		{"user", types.UserRequest{Username: "bob", Password: "password1", Role: users.RoleUser}, http.StatusCreated},
+		{"taken name", types.UserRequest{Username: "bob", Password: "password1", Role: users.RoleUser}, http.StatusConflict},
+		{"invalid role", types.UserRequest{Username: "carol", Password: "password1", Role: "root"}, http.StatusBadRequest},
+		{"quota without home", types.UserRequest{Username: "dave", Password: "password1", Role: users.RoleUser, Quota: str("1GB")}, http.StatusBadRequest},
+		{"home and quota", types.UserRequest{Username: "erin", Password: "password1", Role: users.RoleUser, Home: str("erin"), Quota: str("1GB")}, http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := call(HandleCreateUser, admin, http.MethodPost, "/", tt.req)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+
+	// A refused change doesn't leave a half made account behind
+	if _, ok := users.Get("dave"); ok {
+		t.Error("account kept after its quota was refused")
+	}
+}
+
+func TestUpdateUser(t *testing.T) {
+	setup(t)
+	if err := tokens.Init(); err != nil {
+		t.Fatal(err)
+	}
+	admin := login(t, "admin", users.RoleAdmin, users.Change{})
+	bob := login(t, "bob", users.RoleUser, users.Change{})
+	secret, _, _ := tokens.Create("bob", "laptop", tokens.ScopeRead, 0)
+
+	// 1. A refused change leaves the account as it was
+	quota := "1GB"
+	w := call(HandleUpdateUser, admin, http.MethodPost, "/", types.UserRequest{Username: "bob", Role: users.RoleAdmin, Quota: &quota})
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("quota without home: %d %s", w.Code, w.Body)
+	}
+	if user, _ := users.Get("bob"); user.Role != users.RoleUser {
+		t.Errorf("role changed to %s by a refused update", user.Role)
+	}
+
+	// 2. Resetting the password logs the user out and revokes their tokens
+	w = call(HandleUpdateUser, admin, http.MethodPost, "/", types.UserRequest{Username: "bob", Password: "password2"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("password reset: %d %s", w.Code, w.Body)
+	}
+	if _, ok := session.Validate(bob.Value); ok {
+		t.Error("session survived a password reset")
+	}
+	if _, ok := tokens.Validate(secret); ok {
+		t.Error("token survived a password reset")
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	setup(t)
+	admin := login(t, "admin", users.RoleAdmin, users.Change{})
+	bob := login(t, "bob", users.RoleUser, users.Change{})
+
+	if w := call(HandleDeleteUser, admin, http.MethodPost, "/?username=admin", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("deleting yourself: %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w := call(HandleDeleteUser, admin, http.MethodPost, "/?username=nobody", nil); w.Code != http.StatusNotFound {
+		t.Errorf("deleting a missing user: %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if w := call(HandleDeleteUser, admin, http.MethodPost, "/?username=bob", nil); w.Code != http.StatusOK {
+		t.Fatalf("delete: %d %s", w.Code, w.Body)
+	}
+	if _, ok := session.Validate(bob.Value); ok {
+		t.Error("session of a deleted user still valid")
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	setup(t)
+	bob := login(t, "bob", users.RoleUser, users.Change{})
+	other, _, _ := session.Create("bob", "127.0.0.1", "phone")
+
+	w := call(HandleChangePassword, bob, http.MethodPost, "/", types.ChangePasswordRequest{OldPassword: "wrong", NewPassword: "password2"})
+	if w.Code != http.StatusForbidden {
+		t.Errorf("wrong old password: %d, want %d", w.Code, http.StatusForbidden)
+	}
+	w = call(HandleChangePassword, bob, http.MethodPost, "/", types.ChangePasswordRequest{OldPassword: "password1", NewPassword: "password2"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("change: %d %s", w.Code, w.Body)
+	}
+	if _, ok := session.Validate(bob.Value); !ok {
+		t.Error("the session that changed the password was logged out")
+	}
+	if _, ok := session.Validate(other); ok {
+		t.Error("other session survived the change")
+	}
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"GoFiles/internal/auth"
+	"GoFiles/internal/storage"
+	"GoFiles/internal/types"
+)
+
+// Optimistic concurrency: reads send the ETag of the file, and writes that
+// carry it back in If-Match only go ahead if the file hasn't changed since.
+
+// fileLock serializes the writes with If-Match to one file, so two clients
+// holding the same version can't both pass the check
+type fileLock struct {
+	sync.Mutex
+	waiting int
+}
+
+var fileLocks = map[string]*fileLock{}
+var fileLocksMu sync.Mutex
+
+// lockVersion checks If-Match for relPath and, when it matches, keeps other
+// conditional writes to the file out until the returned unlock is called.
+// On a mismatch it returns the current version and a nil unlock. Requests
+// without If-Match always pass and don't lock.
+func lockVersion(r *http.Request, relPath string) (func(), *types.VersionConflict) {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return func() {}, nil
+	}
+
+	key := path.Join(auth.CurrentUser(r).Home, relPath)
+	fileLocksMu.Lock()
+	lock := fileLocks[key]
+	if lock == nil {
+		lock = &fileLock{}
+		fileLocks[key] = lock
+	}
+	lock.waiting++
+	fileLocksMu.Unlock()
+	lock.Lock()
+
+	unlock := func() {
+		lock.Unlock()
+		fileLocksMu.Lock()
+		if lock.waiting--; lock.waiting == 0 {
+			delete(fileLocks, key)
+		}
+		fileLocksMu.Unlock()
+	}
+
+	current := types.VersionConflict{Error: "The file has changed since it was loaded", Path: "/" + relPath}
+	info, err := userFS(r).Stat(relPath)
+	if err == nil {
+		current.ETag = storage.ETag(info)
+		current.Size = info.Size()
+		current.ModTime = info.ModTime().Format(time.RFC3339)
+	} else {
+		current.Error = "The file doesn't exist"
+	}
+	if err == nil && etagMatches(header, current.ETag) {
+		return unlock, nil
+	}
+	unlock()
+	return nil, &current
+}
+
+// etagMatches compares an If-Match header ("*" or a list of ETags) with the
+// current ETag. Weak ETags never match, as If-Match requires.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// writeVersionConflict answers 412 with the current version of the file
+func writeVersionConflict(w http.ResponseWriter, current *types.VersionConflict) {
+	if current.ETag != "" {
+		w.Header().Set("ETag", current.ETag)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusPreconditionFailed)
+	json.NewEncoder(w).Encode(current)
+}
+
+// setETag sends the version of a file that was just written
+func setETag(w http.ResponseWriter, r *http.Request, relPath string) {
+	if info, err := userFS(r).Stat(relPath); err == nil {
+		w.Header().Set("ETag", storage.ETag(info))
+	}
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"GoFiles/internal/auth"
+	"GoFiles/internal/storage"
+	"GoFiles/internal/types"
+	"GoFiles/internal/users"
+)
+
+// callIfMatch is call with an If-Match header
+func callIfMatch(handler http.HandlerFunc, cookie *http.Cookie, target, ifMatch string, body any) *httptest.ResponseRecorder {
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	r := httptest.NewRequest(http.MethodPost, target, bytes.NewReader(data))
+	r.AddCookie(cookie)
+	r.Header.Set("If-Match", ifMatch)
+	w := httptest.NewRecorder()
+	auth.AuthMiddleware(handler)(w, r)
+	return w
+}
+
+func TestIfMatch(t *testing.T) {
+	setup(t)
+	bob := login(t, "bob", users.RoleUser, users.Change{})
+	writeFiles(t, "notes.txt")
+	info, _ := storage.Root.Stat("notes.txt")
+	loaded := storage.ETag(info)
+
+	// 1. A save with the loaded version goes ahead and returns the new one
+	w := callIfMatch(HandleSaveFile, bob, "/", loaded, types.SaveFileRequest{Path: "notes.txt", Content: "first edit"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("save: %d %s", w.Code, w.Body)
+	}
+	saved := w.Header().Get("ETag")
+	if saved == "" || saved == loaded {
+		t.Fatalf("ETag after save = %q", saved)
+	}
+
+	// 2. Writes with a stale version are refused with the current one
+	for name, handler := range map[string]http.HandlerFunc{
+		"save":   HandleSaveFile,
+		"delete": HandleDelete,
+	} {
+		w := callIfMatch(handler, bob, "/?path=notes.txt", loaded, types.SaveFileRequest{Path: "notes.txt", Content: "lost edit"})
+		if w.Code != http.StatusPreconditionFailed {
+			t.Fatalf("%s with a stale version: %d, want %d", name, w.Code, http.StatusPreconditionFailed)
+		}
+		var current types.VersionConflict
+		json.NewDecoder(w.Body).Decode(&current)
+		if current.ETag != saved || w.Header().Get("ETag") != saved {
+			t.Errorf("%s conflict reports %q, want %q", name, current.ETag, saved)
+		}
+	}
+	if data, _ := storage.ReadFile(storage.Root, "notes.txt"); string(data) != "first edit" {
+		t.Errorf("content = %q after refused writes", data)
+	}
+
+	// 3. "*" only matches a file that exists
+	if w := callIfMatch(HandleSaveFile, bob, "/", "*", types.SaveFileRequest{Path: "new.txt", Content: "x"}); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("* on a missing file: %d, want %d", w.Code, http.StatusPreconditionFailed)
+	}
+	if w := callIfMatch(HandleDelete, bob, "/?path=notes.txt&permanent=true", `W/"x", `+saved, nil); w.Code != http.StatusOK {
+		t.Errorf("delete with the current version in a list: %d %s", w.Code, w.Body)
+	}
+}
+
+// TestLockVersion checks that a second write holding the same version waits
+// for the first and then sees that the file changed
+func TestLockVersion(t *testing.T) {
+	setup(t)
+	bob := login(t, "bob", users.RoleUser, users.Change{})
+	writeFiles(t, "notes.txt")
+	info, _ := storage.Root.Stat("notes.txt")
+	var r *http.Request // An authenticated request with If-Match
+	callIfMatch(func(w http.ResponseWriter, req *http.Request) { r = req }, bob, "/", storage.ETag(info), nil)
+
+	unlock, current := lockVersion(r, "notes.txt")
+	if current != nil {
+		t.Fatalf("current version refused: %+v", current)
+	}
+	second := make(chan *types.VersionConflict)
+	go func() {
+		_, current := lockVersion(r, "notes.txt")
+		second <- current
+	}()
+	select {
+	case <-second:
+		t.Fatal("second write didn't wait for the first")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	storage.WriteFile(storage.Root, "notes.txt", []byte("first write"))
+	unlock()
+	if current := <-second; current == nil {
+		t.Error("second write passed with a stale version")
+	}
+}
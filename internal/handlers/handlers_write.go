@@ -2,91 +2,346 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
-	"io/ioutil"
+	"mime"
+	"mime/multipart"
 	"net/http"
-	"os"
-	"path/filepath"
+	"path"
+	"strings"
 
+	"GoFiles/internal/acl"
 	"GoFiles/internal/config"
+	"GoFiles/internal/quota"
+	"GoFiles/internal/storage"
 	"GoFiles/internal/types"
-	"GoFiles/internal/utils"
 )
 
+// What an upload does with files that already exist (?conflict=)
+const (
+	conflictOverwrite = "overwrite" // Replace them (the default)
+	conflictRename    = "rename"    // Store the upload as "name (1).ext"
+	conflictSkip      = "skip"      // Keep them and drop the upload
+	conflictFail      = "fail"      // Stop at the first one
+)
+
+// HandleUploadFile stores the files of a multipart form in the folder given
+// by ?path=. File names may be relative paths ("photos/2024/a.jpg", as sent
+// for a dropped folder); their folders are created. Responds with the result
+// of every file.
 func HandleUploadFile(w http.ResponseWriter, r *http.Request) {
-	utils.EnableCors(&w)
 	if r.Method != http.MethodPost {
 		return
 	}
 
-	r.ParseMultipartForm(10 << 20)
-	targetDir := r.URL.Query().Get("path")
-	fullDirPath := filepath.Join(config.RootFolder, targetDir)
-
-	if !utils.IsPathSafe(fullDirPath) {
+	targetDir, ok := storage.Clean(r.URL.Query().Get("path"))
+	if !ok {
 		http.Error(w, "Access Denied", http.StatusForbidden)
 		return
 	}
-
-	file, handler, err := r.FormFile("file")
+	conflict := r.URL.Query().Get("conflict")
+	switch conflict {
+	case "":
+		conflict = conflictOverwrite
+	case conflictOverwrite, conflictRename, conflictSkip, conflictFail:
+	default:
+		http.Error(w, "conflict must be overwrite, rename, skip or fail", http.StatusBadRequest)
+		return
+	}
+	reader, err := r.MultipartReader()
 	if err != nil {
+		http.Error(w, "Expected a multipart form", http.StatusBadRequest)
+		return
+	}
+	budget := newBudget(w, r, targetDir, true)
+	if budget == nil {
 		return
 	}
-	defer file.Close()
 
-	dstPath := filepath.Join(fullDirPath, filepath.Base(handler.Filename))
-	dst, err := os.Create(dstPath)
-	if err != nil {
+	// If-Match names the version of one file, so an upload carrying it must
+	// hold just that file. It's only committed once the form is known to end
+	// there, and nothing is written otherwise.
+	single := r.Header.Get("If-Match") != ""
+	checked := false
+	var checkRest func() error
+	if single {
+		checkRest = func() error {
+			checked = true
+			return onlyFile(reader)
+		}
+	}
+
+	// Files are streamed one part at a time, in the order they were sent
+	resp := types.UploadResponse{Files: []types.UploadResult{}}
+	status := http.StatusOK
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			http.Error(w, "Invalid multipart form", http.StatusBadRequest)
+			return
+		}
+		name := uploadName(part)
+		if name == "" {
+			part.Close() // Not a file
+			continue
+		}
+		result, err := saveUpload(r, budget, targetDir, name, conflict, part, checkRest)
+		part.Close()
+		if single && !checked && err == nil {
+			err = checkRest() // Nothing was written, but the form must still hold one file
+		}
+		if err == errManyFiles {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp.Files = append(resp.Files, result)
+		if result.Status == "conflict" {
+			status = http.StatusConflict
+			break
+		}
+		if version, ok := err.(*versionError); ok {
+			writeVersionConflict(w, version.current)
+			return
+		}
+		if err != nil {
+			// Over a limit: the rest of the files would be too
+			status = limitStatus(err)
+			resp.Error = err.Error()
+			break
+		}
+		if single {
+			break // The form was read to its end
+		}
+	}
+	if len(resp.Files) == 0 {
+		http.Error(w, "No files uploaded", http.StatusBadRequest)
 		return
 	}
-	defer dst.Close()
 
-	io.Copy(dst, file)
-	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// uploadName returns the file name of a form part as sent, keeping the
+// folders that Part.FileName strips
+func uploadName(part *multipart.Part) string {
+	_, params, err := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+	if err != nil {
+		return ""
+	}
+	return strings.ReplaceAll(params["filename"], "\\", "/")
+}
+
+// errManyFiles refuses an upload of several files with If-Match
+var errManyFiles = errors.New("If-Match can only be used to upload one file")
+
+// onlyFile reads the rest of a form, failing with errManyFiles if it holds
+// another file
+func onlyFile(reader *multipart.Reader) error {
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := uploadName(part)
+		part.Close()
+		if name != "" {
+			return errManyFiles
+		}
+	}
+}
+
+// saveUpload stores one uploaded file under dir. The error is set only when
+// the file went over a limit of the budget, is a *versionError when
+// If-Match doesn't match the file being replaced, or comes from
+// beforeCommit, which (if set) decides whether the complete file is kept.
+func saveUpload(r *http.Request, budget *quota.Budget, dir, name, conflict string, src io.Reader, beforeCommit func() error) (types.UploadResult, error) {
+	result := types.UploadResult{Name: name, Status: "failed"}
+	fsys := userFS(r)
+	rel, ok := storage.Clean(name)
+	dstPath := path.Join(dir, rel)
+	if !ok || rel == "." || storage.IsInternal(fsys, dstPath) {
+		result.Error = "Invalid file name"
+		return result, nil
+	}
+	if reason := denied(r, dstPath, acl.PermWrite); reason != "" {
+		result.Error = reason
+		return result, nil
+	}
+	unlock, current := lockVersion(r, dstPath)
+	if current != nil {
+		result.Error = current.Error
+		return result, &versionError{current}
+	}
+	defer unlock()
+
+	// 1. Apply the conflict policy
+	created := "created"
+	if info, err := fsys.Stat(dstPath); err == nil {
+		if info.IsDir() {
+			result.Error = "A folder with this name already exists"
+			return result, nil
+		}
+		switch conflict {
+		case conflictSkip:
+			result.Path = "/" + dstPath
+			result.Status = "skipped"
+			return result, nil
+		case conflictFail:
+			result.Path = "/" + dstPath
+			result.Status = "conflict"
+			result.Error = "File already exists"
+			return result, nil
+		case conflictRename:
+			dstPath = freeName(fsys, dstPath)
+			created = "renamed"
+		default:
+			created = "overwritten"
+		}
+	}
+
+	// 2. Rebuild the folders of a relative path, then stream the file
+	if err := fsys.MkdirAll(path.Dir(dstPath), 0755); err != nil {
+		result.Error = "Failed to create folder"
+		return result, nil
+	}
+	dst, err := budget.Create(fsys, dstPath)
+	if err != nil {
+		result.Error = "Failed to save file"
+		return result, nil
+	}
+	n, err := io.Copy(dst, src)
+	if err == nil && beforeCommit != nil {
+		err = beforeCommit()
+	}
+	if err == nil {
+		err = dst.Commit()
+	} else {
+		dst.Abort() // A replaced file stays as it was
+	}
+	if err != nil {
+		if quota.IsLimit(err) || err == errManyFiles {
+			result.Error = err.Error()
+			return result, err
+		}
+		result.Error = "Failed to save file"
+		return result, nil
+	}
+
+	result.Path = "/" + dstPath
+	result.Status = created
+	result.Size = n
+	if info, err := fsys.Stat(dstPath); err == nil {
+		result.ETag = storage.ETag(info)
+	}
+	return result, nil
+}
+
+// versionError stops an upload whose If-Match doesn't match
+type versionError struct {
+	current *types.VersionConflict
+}
+
+func (e *versionError) Error() string { return e.current.Error }
+
+// freeName finds a name next to name that isn't taken: "a (1).txt", "a (2).txt"...
+func freeName(fsys storage.FS, name string) string {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s (%d)%s", base, i, ext)
+		if _, err := fsys.Stat(candidate); err != nil {
+			return candidate
+		}
+	}
 }
 
 func HandleCreateDir(w http.ResponseWriter, r *http.Request) {
-	utils.EnableCors(&w)
 	if r.Method != http.MethodPost {
 		return
 	}
 
 	var req types.CreateDirRequest
 	json.NewDecoder(r.Body).Decode(&req)
-	fullPath := filepath.Join(config.RootFolder, req.Path, req.Name)
-
-	if !utils.IsPathSafe(fullPath) {
+	dirPath, ok := storage.Clean(path.Join(req.Path, req.Name))
+	if !ok {
+		return
+	}
+	if !authorize(w, r, dirPath, acl.PermWrite) {
 		return
 	}
-	os.Mkdir(fullPath, 0755)
+	userFS(r).Mkdir(dirPath, 0755)
 	w.WriteHeader(http.StatusOK)
 }
 
 func HandleSaveFile(w http.ResponseWriter, r *http.Request) {
-	utils.EnableCors(&w)
 	if r.Method != http.MethodPost {
 		return
 	}
 
+	// Stop reading a body far over the limit; JSON escaping can double the
+	// size of text, so the content itself is checked after decoding
+	if config.MaxUploadSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, 2*int64(config.MaxUploadSize)+1<<20)
+	}
+
 	var req types.SaveFileRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeLimitError(w, quota.ErrTooLarge)
+			return
+		}
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
 
-	fullPath := filepath.Join(config.RootFolder, req.Path)
-
-	if !utils.IsPathSafe(fullPath) {
+	filePath, ok := storage.Clean(req.Path)
+	if !ok {
 		http.Error(w, "Access Denied", http.StatusForbidden)
 		return
 	}
+	if !authorize(w, r, filePath, acl.PermWrite) {
+		return
+	}
+	unlock, current := lockVersion(r, filePath)
+	if current != nil {
+		writeVersionConflict(w, current)
+		return
+	}
+	defer unlock()
+	fsys := userFS(r)
 
-	// Write the string content to the file
-	err := ioutil.WriteFile(fullPath, []byte(req.Content), 0644)
+	// The new content replaces the old one, which no longer counts
+	budget := newBudget(w, r, filePath, true)
+	if budget == nil {
+		return
+	}
+	file, err := budget.Create(fsys, filePath)
 	if err != nil {
 		http.Error(w, "Failed to save file", http.StatusInternalServerError)
 		return
 	}
+	if _, err := file.Write([]byte(req.Content)); err != nil {
+		file.Abort()
+		if !writeLimitError(w, err) {
+			http.Error(w, "Failed to save file", http.StatusInternalServerError)
+		}
+		return
+	}
+	if err := file.Commit(); err != nil {
+		http.Error(w, "Failed to save file", http.StatusInternalServerError)
+		return
+	}
 
+	setETag(w, r, filePath) // The version to send with the next save
 	w.WriteHeader(http.StatusOK)
 }
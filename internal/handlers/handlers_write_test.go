@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"GoFiles/internal/auth"
+	"GoFiles/internal/quota"
+	"GoFiles/internal/storage"
+	"GoFiles/internal/types"
+	"GoFiles/internal/users"
+)
+
+// uploadCall sends files as a multipart form, keeping their folders in the
+// file names
+func uploadCall(t *testing.T, cookie *http.Cookie, target string, files ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	auth.AuthMiddleware(HandleUploadFile)(w, uploadRequest(t, cookie, target, files...))
+	return w
+}
+
+// uploadRequest builds the request of uploadCall
+func uploadRequest(t *testing.T, cookie *http.Cookie, target string, files ...string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for _, name := range files {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Disposition", `form-data; name="files"; filename="`+name+`"`)
+		part, err := form.CreatePart(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write([]byte("new"))
+	}
+	form.Close()
+	r := httptest.NewRequest(http.MethodPost, target, &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	r.AddCookie(cookie)
+	return r
+}
+
+// TestUploadConflict uploads a file that exists and a new one in a new
+// folder with every conflict policy
+func TestUploadConflict(t *testing.T) {
+	tests := []struct {
+		conflict string
+		want     int
+		statuses []string // Of each file, in the order sent
+		content  string   // Of docs/a.txt afterwards
+		renamed  bool     // "docs/a (1).txt" was written
+	}{
+		{"", http.StatusOK, []string{"overwritten", "created"}, "new", false},
+		{"rename", http.StatusOK, []string{"renamed", "created"}, "docs/a.txt", true},
+		{"skip", http.StatusOK, []string{"skipped", "created"}, "docs/a.txt", false},
+		{"fail", http.StatusConflict, []string{"conflict"}, "docs/a.txt", false},
+	}
+	for _, tt := range tests {
+		t.Run("conflict="+tt.conflict, func(t *testing.T) {
+			setup(t)
+			writeFiles(t, "docs/a.txt")
+			bob := login(t, "bob", users.RoleUser, users.Change{})
+
+			// 1. Upload both files
+			w := uploadCall(t, bob, "/api/upload?path=docs&conflict="+tt.conflict, "a.txt", "new/b.txt")
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			var resp types.UploadResponse
+			json.NewDecoder(w.Body).Decode(&resp)
+			if len(resp.Files) != len(tt.statuses) {
+				t.Fatalf("got %d results, want %d", len(resp.Files), len(tt.statuses))
+			}
+			for i, file := range resp.Files {
+				if file.Status != tt.statuses[i] {
+					t.Errorf("%s: status %q, want %q", file.Name, file.Status, tt.statuses[i])
+				}
+			}
+
+			// 2. Check what was written
+			data, _ := storage.ReadFile(storage.Root, "docs/a.txt")
+			if string(data) != tt.content {
+				t.Errorf("docs/a.txt = %q, want %q", data, tt.content)
+			}
+			if exists("docs/a (1).txt") != tt.renamed {
+				t.Errorf("docs/a (1).txt exists = %v, want %v", exists("docs/a (1).txt"), tt.renamed)
+			}
+			if exists("docs/new/b.txt") != (tt.want == http.StatusOK) {
+				t.Errorf("docs/new/b.txt exists = %v after status %d", exists("docs/new/b.txt"), w.Code)
+			}
+		})
+	}
+}
+
+// TestUploadRefusesNames checks the names an upload may not write to
+func TestUploadRefusesNames(t *testing.T) {
+	setup(t)
+	bob := login(t, "bob", users.RoleUser, users.Change{})
+
+	w := uploadCall(t, bob, "/api/upload?path=/", "../escape.txt", ".trash/a.txt", "docs/ok.txt")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var resp types.UploadResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	want := []string{"failed", "failed", "created"}
+	if len(resp.Files) != len(want) {
+		t.Fatalf("got %d results, want %d", len(resp.Files), len(want))
+	}
+	for i, file := range resp.Files {
+		if file.Status != want[i] {
+			t.Errorf("%s: status %q, want %q", file.Name, file.Status, want[i])
+		}
+	}
+	if exists(".trash/a.txt") || !exists("docs/ok.txt") {
+		t.Errorf(".trash/a.txt exists = %v, docs/ok.txt exists = %v", exists(".trash/a.txt"), exists("docs/ok.txt"))
+	}
+
+	w = uploadCall(t, bob, "/api/upload?path=docs&conflict=merge", "ok.txt")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("unknown conflict policy: %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+// TestUploadIfMatch checks that an upload with If-Match replaces its one
+// file only if it's still the version that was loaded
+func TestUploadIfMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		ifMatch string // "" for the current ETag
+		files   []string
+		want    int
+		content string // Of docs/a.txt afterwards
+	}{
+		{"current version", "", []string{"a.txt"}, http.StatusOK, "new"},
+		{"stale version", `"0-0"`, []string{"a.txt"}, http.StatusPreconditionFailed, "docs/a.txt"},
+		{"missing file", "*", []string{"b.txt"}, http.StatusPreconditionFailed, "docs/a.txt"},
+		{"several files", "", []string{"a.txt", "b.txt"}, http.StatusBadRequest, "docs/a.txt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setup(t)
+			writeFiles(t, "docs/a.txt")
+			bob := login(t, "bob", users.RoleUser, users.Change{})
+			info, _ := storage.Root.Stat("docs/a.txt")
+			if tt.ifMatch == "" {
+				tt.ifMatch = storage.ETag(info)
+			}
+
+			r := uploadRequest(t, bob, "/api/upload?path=docs", tt.files...)
+			r.Header.Set("If-Match", tt.ifMatch)
+			w := httptest.NewRecorder()
+			auth.AuthMiddleware(HandleUploadFile)(w, r)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			data, _ := storage.ReadFile(storage.Root, "docs/a.txt")
+			if string(data) != tt.content {
+				t.Errorf("docs/a.txt = %q, want %q", data, tt.content)
+			}
+			if exists("docs/b.txt") {
+				t.Error("docs/b.txt written")
+			}
+		})
+	}
+}
+
+// TestSaveFileQuota checks that saving over a file only counts the change
+// in size against the quota
+func TestSaveFileQuota(t *testing.T) {
+	setup(t)
+	home, limit := "homes/save", int64(100)
+	bob := login(t, "bob", users.RoleUser, users.Change{Home: &home, Quota: &limit})
+	storage.WriteFile(storage.Root, "homes/save/notes.txt", []byte(strings.Repeat("o", 80)))
+	user, _ := users.Get("bob")
+
+	tests := []struct {
+		name  string
+		size  int
+		want  int
+		usage int64
+	}{
+		{"grow within the quota", 90, http.StatusOK, 90},
+		{"grow past the quota", 120, http.StatusInsufficientStorage, 90},
+		{"shrink", 10, http.StatusOK, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := strings.Repeat("n", tt.size)
+			w := call(HandleSaveFile, bob, http.MethodPost, "/", types.SaveFileRequest{Path: "notes.txt", Content: content})
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if got := quota.Usage(user); got != tt.usage {
+				t.Errorf("usage = %d, want %d", got, tt.usage)
+			}
+			data, _ := storage.ReadFile(storage.Root, "homes/save/notes.txt")
+			if tt.want == http.StatusOK && string(data) != content {
+				t.Errorf("saved %d bytes, want %d", len(data), tt.size)
+			}
+		})
+	}
+}
@@ -0,0 +1,262 @@
+package quota
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sync"
+	"time"
+
+	"GoFiles/internal/config"
+	"GoFiles/internal/storage"
+	"GoFiles/internal/types"
+	"GoFiles/internal/users"
+)
+
+var (
+	ErrTooLarge          = errors.New("file is larger than the maximum upload size")
+	ErrQuotaExceeded     = errors.New("storage quota exceeded")
+	ErrInsufficientSpace = errors.New("not enough free space on the disk")
+)
+
+// usageTTL is how long a walked usage total is trusted. Writes through a
+// Budget, and the files they replace, keep it current in between; other
+// deletions show up on the next walk.
+const usageTTL = time.Minute
+
+type cachedUsage struct {
+	bytes    int64
+	walkedAt time.Time
+}
+
+// Usage totals by home folder, since users sharing a home share its files
+var usage = map[string]*cachedUsage{}
+var usageMu sync.Mutex
+
+// Of returns a user's quota in bytes, 0 if unlimited. A quota limits what is
+// stored in a home folder, so users of the whole tree never have one.
+func Of(u types.User) int64 {
+	if u.Home == "" {
+		return 0
+	}
+	if u.Quota > 0 {
+		return u.Quota
+	}
+	return int64(config.DefaultQuota)
+}
+
+// Usage returns the bytes stored in a user's tree, trash included
+func Usage(u types.User) int64 {
+	usageMu.Lock()
+	cached := usage[u.Home]
+	usageMu.Unlock()
+	if cached != nil && time.Since(cached.walkedAt) < usageTTL {
+		return cached.bytes
+	}
+
+	var total int64
+	storage.WalkDir(users.Files(u), ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			total += info.Size()
+		}
+		return nil
+	})
+
+	usageMu.Lock()
+	usage[u.Home] = &cachedUsage{bytes: total, walkedAt: time.Now()}
+	usageMu.Unlock()
+	return total
+}
+
+// add counts bytes written (or freed, when negative) since the last walk
+func add(u types.User, n int64) {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	if cached := usage[u.Home]; cached != nil {
+		cached.bytes += n
+	}
+}
+
+// reserve counts n more bytes in a user's usage, unless that takes it over
+// quota (0 for none). Writes in progress all count against the same total,
+// so together they can't go over it either.
+func reserve(u types.User, n, quota int64) bool {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	cached := usage[u.Home]
+	if cached == nil {
+		return true // Not walked yet, the walk will count it
+	}
+	if quota > 0 && cached.bytes+n > quota {
+		return false
+	}
+	cached.bytes += n
+	return true
+}
+
+// Space returns the free space on the disk behind name in the user's tree,
+// and how much of it can be written before the disk reaches min_free_space.
+// False when the backend has no such limit.
+func Space(u types.User, name string) (free, available int64, ok bool) {
+	full := path.Join(u.Home, name)
+	free, ok = storage.FreeSpace(storage.Root, full)
+	if !ok {
+		return 0, 0, false
+	}
+	return free, max(free-config.MinFreeSpaceFor(storage.MountOf(storage.Root, full)), 0), true
+}
+
+// Budget is what one operation (an upload, a copy, an unzip...) may still
+// write: the least of the room left in the user's quota and on the disk
+type Budget struct {
+	user    types.User
+	maxFile int64 // Per file, -1 for no limit
+	quota   int64 // 0 for no quota
+	space   int64 // Room left on the disk, -1 for no limit
+}
+
+// NewBudget checks that the user may write to name and returns what they
+// may write. Uploads (files coming from the client) are also held to the
+// maximum upload size.
+func NewBudget(u types.User, name string, upload bool) (*Budget, error) {
+	b := &Budget{user: u, maxFile: -1, quota: Of(u), space: -1}
+	if upload && config.MaxUploadSize > 0 {
+		b.maxFile = int64(config.MaxUploadSize)
+	}
+	if b.quota > 0 && Usage(u) >= b.quota {
+		return nil, ErrQuotaExceeded
+	}
+	if _, available, ok := Space(u, name); ok {
+		if available == 0 {
+			return nil, ErrInsufficientSpace
+		}
+		b.space = available
+	}
+	return b, nil
+}
+
+// Check reports whether a new file of a known size fits
+func (b *Budget) Check(size int64) error {
+	if b.maxFile >= 0 && size > b.maxFile {
+		return ErrTooLarge
+	}
+	if b.quota > 0 && Usage(b.user)+size > b.quota {
+		return ErrQuotaExceeded
+	}
+	if b.space >= 0 && size > b.space {
+		return ErrInsufficientSpace
+	}
+	return nil
+}
+
+// Reserve counts bytes about to be written, failing if they don't fit
+func (b *Budget) Reserve(n int64) error {
+	if b.space >= 0 && n > b.space {
+		return ErrInsufficientSpace
+	}
+	if !reserve(b.user, n, b.quota) {
+		return ErrQuotaExceeded
+	}
+	if b.space >= 0 {
+		b.space -= n
+	}
+	return nil
+}
+
+// Release gives back bytes that were reserved but not written, or freed
+func (b *Budget) Release(n int64) {
+	if b.space >= 0 {
+		b.space += n
+	}
+	add(b.user, -n)
+}
+
+// Create starts writing name like storage.CreateAtomic, counting what is
+// written against the budget. A file it replaces no longer counts once it
+// commits; if it's aborted (or fails to commit) it gives back everything.
+func (b *Budget) Create(fsys storage.FS, name string) (storage.PendingFile, error) {
+	var replaced int64
+	if info, err := fsys.Stat(name); err == nil && !info.IsDir() {
+		replaced = info.Size()
+	}
+	file, err := storage.CreateAtomic(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	return &pendingFile{PendingFile: file, budget: b, replaced: replaced}, nil
+}
+
+type pendingFile struct {
+	storage.PendingFile
+	budget   *Budget
+	replaced int64 // Size of the file it replaces, written over first
+	written  int64
+	reserved int64 // Counted in the usage so far
+}
+
+func (f *pendingFile) Write(p []byte) (int, error) {
+	if f.budget.maxFile >= 0 && f.written+int64(len(p)) > f.budget.maxFile {
+		return 0, ErrTooLarge
+	}
+	if grow := max(f.written+int64(len(p))-f.replaced, 0) - f.reserved; grow > 0 {
+		if err := f.budget.Reserve(grow); err != nil {
+			return 0, err
+		}
+		f.reserved += grow
+	}
+	n, err := f.PendingFile.Write(p)
+	f.written += int64(n)
+	return n, err
+}
+
+func (f *pendingFile) Commit() error {
+	if err := f.PendingFile.Commit(); err != nil {
+		f.budget.Release(f.reserved)
+		f.reserved = 0
+		return err
+	}
+	// What's left of the replaced file is freed
+	f.budget.Release(f.reserved - (f.written - f.replaced))
+	return nil
+}
+
+func (f *pendingFile) Abort() error {
+	f.budget.Release(f.reserved)
+	f.reserved = 0
+	return f.PendingFile.Abort()
+}
+
+// Writer wraps the writer of one file, failing as soon as the file grows
+// past the maximum upload size or the budget runs out
+func (b *Budget) Writer(w io.Writer) io.Writer {
+	return &limitedWriter{budget: b, w: w}
+}
+
+type limitedWriter struct {
+	budget  *Budget
+	w       io.Writer
+	written int64
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if l.budget.maxFile >= 0 && l.written+int64(len(p)) > l.budget.maxFile {
+		return 0, ErrTooLarge
+	}
+	if err := l.budget.Reserve(int64(len(p))); err != nil {
+		return 0, err
+	}
+	n, err := l.w.Write(p)
+	l.written += int64(n)
+	l.budget.Release(int64(len(p) - n)) // Reserved but not written
+	return n, err
+}
+
+// IsLimit reports whether err comes from a size limit, quota or the free
+// space check
+func IsLimit(err error) bool {
+	return errors.Is(err, ErrTooLarge) || errors.Is(err, ErrQuotaExceeded) || errors.Is(err, ErrInsufficientSpace)
+}
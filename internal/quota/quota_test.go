@@ -0,0 +1,111 @@
+package quota
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"GoFiles/internal/config"
+	"GoFiles/internal/storage"
+	"GoFiles/internal/types"
+	"GoFiles/internal/users"
+)
+
+// setup starts a test with a user whose home holds 80 of their 100 bytes
+func setup(t *testing.T) types.User {
+	t.Helper()
+	t.Chdir(t.TempDir()) // The config file lives in the working directory
+	config.AppConfig = types.ConfigFile{}
+	storage.Root = storage.NewMemory()
+	usage = map[string]*cachedUsage{}
+
+	if _, err := users.Create("alice", "password1", users.RoleUser); err != nil {
+		t.Fatal(err)
+	}
+	home, limit := "homes/alice", int64(100)
+	user, err := users.Modify("alice", users.Change{Home: &home, Quota: &limit})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.WriteFile(storage.Root, "homes/alice/old.txt", bytes.Repeat([]byte("x"), 80)); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func newBudget(t *testing.T, user types.User) *Budget {
+	t.Helper()
+	b, err := NewBudget(user, "new.txt", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// TestBudgetsShareQuota checks that writes in progress at the same time
+// can't go over the quota together
+func TestBudgetsShareQuota(t *testing.T) {
+	user := setup(t)
+	first, second := newBudget(t, user), newBudget(t, user)
+	if err := first.Reserve(15); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Reserve(15); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("second reservation = %v, want %v", err, ErrQuotaExceeded)
+	}
+	first.Release(15)
+	if err := second.Reserve(15); err != nil {
+		t.Errorf("reservation after a release = %v", err)
+	}
+	if got := Usage(user); got != 95 {
+		t.Errorf("usage = %d, want 95", got)
+	}
+}
+
+func TestCreate(t *testing.T) {
+	user := setup(t)
+	fsys := users.Files(user)
+
+	write := func(name string, size int) (storage.PendingFile, error) {
+		file, err := newBudget(t, user).Create(fsys, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = file.Write(bytes.Repeat([]byte("y"), size))
+		return file, err
+	}
+
+	// 1. A new file can't go over the quota, and gives back what it
+	// reserved when aborted
+	file, err := write("new.txt", 30)
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("writing past the quota = %v, want %v", err, ErrQuotaExceeded)
+	}
+	file.Abort()
+	file, err = write("new.txt", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Abort()
+	if got := Usage(user); got != 80 {
+		t.Errorf("usage after aborting = %d, want 80", got)
+	}
+
+	// 2. Replacing a file reuses its bytes, and frees what the new one
+	// doesn't need
+	file, err = write("old.txt", 95)
+	if err != nil {
+		t.Fatalf("replacing a file within the quota: %v", err)
+	}
+	if err := file.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if got := Usage(user); got != 95 {
+		t.Errorf("usage after growing a file = %d, want 95", got)
+	}
+	file, _ = write("old.txt", 40)
+	file.Commit()
+	if got := Usage(user); got != 40 {
+		t.Errorf("usage after shrinking a file = %d, want 40", got)
+	}
+}
@@ -0,0 +1,144 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Attempts tracks failed authentication attempts per key (e.g. "ip:1.2.3.4"
+// or "user:bob"). After FreeAttempts failures every further failure locks the
+// key for an exponentially growing time, capped at MaxLockout.
+type Attempts struct {
+	FreeAttempts int
+	BaseLockout  time.Duration
+	MaxLockout   time.Duration
+	Window       time.Duration // Failures are forgotten after this much quiet time
+
+	mu        sync.Mutex
+	entries   map[string]*attemptEntry
+	lastSweep time.Time
+}
+
+type attemptEntry struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+func NewAttempts(freeAttempts int, baseLockout, maxLockout time.Duration) *Attempts {
+	return &Attempts{
+		FreeAttempts: freeAttempts,
+		BaseLockout:  baseLockout,
+		MaxLockout:   maxLockout,
+		Window:       maxLockout,
+		entries:      map[string]*attemptEntry{},
+	}
+}
+
+// Blocked returns how long the caller must wait before trying again
+// (the longest lockout among keys), or 0 if none of them is locked.
+func (a *Attempts) Blocked(keys ...string) time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	var wait time.Duration
+	for _, key := range keys {
+		if e, ok := a.entries[key]; ok && e.lockedUntil.After(now) {
+			if d := e.lockedUntil.Sub(now); d > wait {
+				wait = d
+			}
+		}
+	}
+	return wait
+}
+
+// Fail records a failed attempt for every key and returns the resulting lockout (0 if none)
+func (a *Attempts) Fail(keys ...string) time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	a.sweep(now)
+
+	var longest time.Duration
+	for _, key := range keys {
+		e, ok := a.entries[key]
+		if !ok || now.Sub(e.lastFailure) > a.Window {
+			e = &attemptEntry{}
+			a.entries[key] = e
+		}
+		e.failures++
+		e.lastFailure = now
+
+		if over := e.failures - a.FreeAttempts; over > 0 {
+			lockout := a.BaseLockout
+			for i := 1; i < over && lockout < a.MaxLockout; i++ {
+				lockout *= 2
+			}
+			if lockout > a.MaxLockout {
+				lockout = a.MaxLockout
+			}
+			e.lockedUntil = now.Add(lockout)
+			if lockout > longest {
+				longest = lockout
+			}
+		}
+	}
+	return longest
+}
+
+// Reset forgets the failures of the given keys (after a successful login)
+func (a *Attempts) Reset(keys ...string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, key := range keys {
+		delete(a.entries, key)
+	}
+}
+
+// sweep drops stale entries so the map doesn't grow forever. Caller holds mu.
+func (a *Attempts) sweep(now time.Time) {
+	if now.Sub(a.lastSweep) < time.Minute {
+		return
+	}
+	a.lastSweep = now
+	for key, e := range a.entries {
+		if now.Sub(e.lastFailure) > a.Window && now.After(e.lockedUntil) {
+			delete(a.entries, key)
+		}
+	}
+}
+
+// Bucket is a token bucket: it allows Rate requests per second on average,
+// with bursts of up to Burst requests.
+type Bucket struct {
+	rate   float64
+	burst  float64
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func NewBucket(rate float64, burst int) *Bucket {
+	return &Bucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// Allow takes a token if one is available
+func (b *Bucket) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -0,0 +1,65 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAttempts(t *testing.T) {
+	a := NewAttempts(2, time.Minute, 5*time.Minute)
+
+	// 1. Free attempts don't lock
+	for i := 0; i < 2; i++ {
+		if d := a.Fail("ip:1.2.3.4", "user:bob"); d != 0 {
+			t.Fatalf("failure %d locked for %v", i+1, d)
+		}
+	}
+	if d := a.Blocked("ip:1.2.3.4"); d != 0 {
+		t.Fatalf("blocked for %v after free attempts", d)
+	}
+
+	// 2. Then the lockout doubles up to the cap
+	for _, want := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute} {
+		if d := a.Fail("ip:1.2.3.4"); d != want {
+			t.Errorf("lockout = %v, want %v", d, want)
+		}
+	}
+	if d := a.Blocked("ip:5.6.7.8", "ip:1.2.3.4"); d <= 4*time.Minute {
+		t.Errorf("Blocked = %v, want the longest lockout among the keys", d)
+	}
+
+	// 3. Keys are counted separately and reset on success
+	if d := a.Blocked("user:bob"); d != 0 {
+		t.Errorf("user:bob blocked for %v after two failures", d)
+	}
+	a.Reset("ip:1.2.3.4")
+	if d := a.Blocked("ip:1.2.3.4"); d != 0 {
+		t.Errorf("blocked for %v after a reset", d)
+	}
+}
+
+func TestAttemptsWindow(t *testing.T) {
+	a := NewAttempts(1, time.Minute, time.Hour)
+	a.Window = 10 * time.Millisecond
+	a.Fail("key")
+	time.Sleep(20 * time.Millisecond)
+	if d := a.Fail("key"); d != 0 {
+		t.Errorf("failure after a quiet window locked for %v", d)
+	}
+}
+
+func TestBucket(t *testing.T) {
+	b := NewBucket(100, 3)
+	for i := 0; i < 3; i++ {
+		if !b.Allow() {
+			t.Fatalf("request %d of the burst refused", i+1)
+		}
+	}
+	if b.Allow() {
+		t.Fatal("request past the burst allowed")
+	}
+	time.Sleep(30 * time.Millisecond) // Refills 3 tokens at 100 per second
+	if !b.Allow() {
+		t.Error("no token after refilling")
+	}
+}
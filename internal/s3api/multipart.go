@@ -0,0 +1,293 @@
+package s3api
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"GoFiles/internal/acl"
+	"GoFiles/internal/quota"
+	"GoFiles/internal/security"
+	"GoFiles/internal/storage"
+	"GoFiles/internal/uploads"
+)
+
+// uploadPrefix marks multipart uploads in the uploads folder
+const uploadPrefix = "s3-"
+
+// maxPartNumber is the highest part number S3 allows
+const maxPartNumber = 10000
+
+// multipartUpload is stored next to the parts of an unfinished upload
+type multipartUpload struct {
+	Bucket    string    `json:"bucket"`
+	Key       string    `json:"key"`
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// uploadDir is where the parts of an upload are kept until it is completed:
+// the uploads folder of the bucket's mount
+func (req *request) uploadDir(uploadID string) (string, error) {
+	if !strings.HasPrefix(uploadID, uploadPrefix) || strings.ContainsAny(uploadID, "/\\") || strings.Contains(uploadID, "..") {
+		return "", errNoSuchUpload
+	}
+	return path.Join(uploads.Dir(req.fsys, req.bucket), uploadID), nil
+}
+
+// openUpload checks that the upload exists and belongs to this user and key
+func (req *request) openUpload(uploadID string) (string, error) {
+	dir, err := req.uploadDir(uploadID)
+	if err != nil {
+		return "", err
+	}
+	data, err := storage.ReadFile(req.fsys, path.Join(dir, "upload.json"))
+	if err != nil {
+		return "", errNoSuchUpload
+	}
+	var upload multipartUpload
+	if json.Unmarshal(data, &upload) != nil || upload.Bucket != req.bucket || upload.Key != req.key || upload.Username != req.user.Username {
+		return "", errNoSuchUpload
+	}
+	return dir, nil
+}
+
+func (req *request) createMultipartUpload() error {
+	p, err := req.objectPath(req.key)
+	if err != nil {
+		return err
+	}
+	if err := req.checkWrite(p, acl.PermWrite); err != nil {
+		return err
+	}
+	id, err := security.RandomToken(16)
+	if err != nil {
+		return err
+	}
+	uploadID := uploadPrefix + id
+	dir, _ := req.uploadDir(uploadID)
+	if err := req.fsys.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	data, _ := json.Marshal(multipartUpload{Bucket: req.bucket, Key: req.key, Username: req.user.Username, CreatedAt: time.Now()})
+	if err := storage.WriteFile(req.fsys, path.Join(dir, "upload.json"), data); err != nil {
+		req.fsys.RemoveAll(dir)
+		return err
+	}
+	return writeXML(req.w, http.StatusOK, initiateMultipartUploadResult{Bucket: req.bucket, Key: req.key, UploadID: uploadID})
+}
+
+// uploadPart stores a part as "part-<number>-<md5>", replacing an earlier
+// upload of the same part
+func (req *request) uploadPart(uploadID string) error {
+	if req.r.Header.Get("X-Amz-Copy-Source") != "" {
+		return errNotImplemented // UploadPartCopy
+	}
+	dir, err := req.openUpload(uploadID)
+	if err != nil {
+		return err
+	}
+	number, err := strconv.Atoi(req.r.URL.Query().Get("partNumber"))
+	if err != nil || number < 1 || number > maxPartNumber {
+		return errInvalidArgument
+	}
+
+	budget, err := quota.NewBudget(req.user, dir, false)
+	if err != nil {
+		return err
+	}
+	tmp := path.Join(dir, fmt.Sprintf("part-%05d.tmp", number))
+	out, err := req.fsys.Create(tmp)
+	if err != nil {
+		return err
+	}
+	hash := md5.New()
+	n, err := io.Copy(io.MultiWriter(budget.Writer(out), hash), req.r.Body)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		req.fsys.Remove(tmp)
+		budget.Release(n)
+		return err
+	}
+
+	sum := hex.EncodeToString(hash.Sum(nil))
+	parts, err := req.listParts(dir)
+	if err != nil {
+		return err
+	}
+	if old, ok := parts[number]; ok {
+		req.fsys.Remove(path.Join(dir, old.name))
+	}
+	if err := req.fsys.Rename(tmp, path.Join(dir, fmt.Sprintf("part-%05d-%s", number, sum))); err != nil {
+		return err
+	}
+	req.w.Header().Set("ETag", `"`+sum+`"`)
+	req.w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// completeMultipartUpload joins the listed parts into the object
+func (req *request) completeMultipartUpload(uploadID string) error {
+	dir, err := req.openUpload(uploadID)
+	if err != nil {
+		return err
+	}
+	p, err := req.objectPath(req.key)
+	if err != nil {
+		return err
+	}
+	if err := req.checkWrite(p, acl.PermWrite); err != nil {
+		return err
+	}
+	var body completeMultipartUpload
+	if err := readXML(req.r.Body, &body); err != nil {
+		return err
+	}
+	if len(body.Parts) == 0 {
+		return errMalformedXML
+	}
+	stored, err := req.listParts(dir)
+	if err != nil {
+		return err
+	}
+
+	// 1. Every listed part must have been uploaded with that ETag, in order
+	var names []string
+	for i, part := range body.Parts {
+		if i > 0 && part.PartNumber <= body.Parts[i-1].PartNumber {
+			return errInvalidPartOrder
+		}
+		got, ok := stored[part.PartNumber]
+		if !ok || got.etag != strings.Trim(part.ETag, `"`) {
+			return errInvalidPart
+		}
+		names = append(names, path.Join(dir, got.name))
+	}
+
+	// 2. Stream them into place
+	readers := make([]io.Reader, len(names))
+	for i, name := range names {
+		readers[i] = &partReader{fsys: req.fsys, name: name}
+	}
+	info, err := req.writeFile(p, io.MultiReader(readers...), nil)
+	for _, r := range readers {
+		r.(*partReader).Close()
+	}
+	if err != nil {
+		return err
+	}
+	req.fsys.RemoveAll(dir)
+
+	return writeXML(req.w, http.StatusOK, completeMultipartUploadResult{
+		Location: "/" + req.bucket + "/" + req.key,
+		Bucket:   req.bucket,
+		Key:      req.key,
+		ETag:     storage.ETag(info),
+	})
+}
+
+func (req *request) abortMultipartUpload(uploadID string) error {
+	dir, err := req.openUpload(uploadID)
+	if err != nil {
+		return err
+	}
+	if err := req.fsys.RemoveAll(dir); err != nil {
+		return err
+	}
+	req.w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+type storedPart struct {
+	name string
+	etag string
+}
+
+// listParts finds the uploaded parts of an upload by number
+func (req *request) listParts(dir string) (map[int]storedPart, error) {
+	entries, err := req.fsys.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	parts := map[int]storedPart{}
+	for _, entry := range entries {
+		rest, ok := strings.CutPrefix(entry.Name(), "part-")
+		number, sum, found := strings.Cut(rest, "-")
+		if !ok || !found {
+			continue
+		}
+		if n, err := strconv.Atoi(number); err == nil {
+			parts[n] = storedPart{name: entry.Name(), etag: sum}
+		}
+	}
+	return parts, nil
+}
+
+// partReader opens a part when it is first read and closes it at the end,
+// so only one part is open at a time
+type partReader struct {
+	fsys storage.FS
+	name string
+	file storage.File
+	done bool
+}
+
+func (p *partReader) Read(b []byte) (int, error) {
+	if p.done {
+		return 0, io.EOF
+	}
+	if p.file == nil {
+		file, err := p.fsys.Open(p.name)
+		if err != nil {
+			return 0, err
+		}
+		p.file = file
+	}
+	n, err := p.file.Read(b)
+	if err == io.EOF {
+		p.Close()
+	}
+	return n, err
+}
+
+func (p *partReader) Close() error {
+	if p.file == nil || p.done {
+		return nil
+	}
+	p.done = true
+	return p.file.Close()
+}
+
+// --- XML DOCUMENTS ---
+
+type initiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ InitiateMultipartUploadResult"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadID string   `xml:"UploadId"`
+}
+
+type completeMultipartUpload struct {
+	Parts []struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	} `xml:"Part"`
+}
+
+type completeMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CompleteMultipartUploadResult"`
+	Location string   `xml:"Location"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	ETag     string   `xml:"ETag"`
+}
@@ -0,0 +1,849 @@
+package s3api
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"GoFiles/internal/acl"
+	"GoFiles/internal/quota"
+	"GoFiles/internal/seclog"
+	"GoFiles/internal/storage"
+	"GoFiles/internal/tokens"
+	"GoFiles/internal/trash"
+	"GoFiles/internal/types"
+	"GoFiles/internal/users"
+	"GoFiles/internal/utils"
+)
+
+// maxXMLBody caps request bodies that are parsed (multi-delete, completing
+// a multipart upload)
+const maxXMLBody = 2 << 20
+
+// maxListKeys is the most entries a listing returns at once
+const maxListKeys = 1000
+
+// Subresources this API doesn't implement
+var unsupported = []string{"acl", "policy", "versioning", "lifecycle", "cors", "tagging", "website",
+	"replication", "encryption", "object-lock", "retention", "legal-hold", "torrent", "versions"}
+
+// apiError is an S3 error response
+type apiError struct {
+	Code    string
+	Message string
+	Status  int
+}
+
+func (e *apiError) Error() string { return e.Message }
+
+// errIsS3 reports whether err is one of the errors with an S3 error code
+func errIsS3(err error) (*apiError, bool) {
+	var e *apiError
+	ok := errors.As(err, &e)
+	return e, ok
+}
+
+var (
+	errAccessDenied                 = &apiError{"AccessDenied", "Access Denied", http.StatusForbidden}
+	errAuthorizationHeaderMalformed = &apiError{"AuthorizationHeaderMalformed", "The authorization header is malformed", http.StatusBadRequest}
+	errBadDigest                    = &apiError{"BadDigest", "The Content-MD5 you specified did not match what was received", http.StatusBadRequest}
+	errBucketExists                 = &apiError{"BucketAlreadyOwnedByYou", "The bucket already exists", http.StatusConflict}
+	errBucketNotEmpty               = &apiError{"BucketNotEmpty", "The bucket you tried to delete is not empty", http.StatusConflict}
+	errContentSHA256Mismatch        = &apiError{"XAmzContentSHA256Mismatch", "The provided x-amz-content-sha256 header does not match what was computed", http.StatusBadRequest}
+	errEntityTooLarge               = &apiError{"MalformedXML", "The request body is too large", http.StatusBadRequest}
+	errEntityTooLargeUpload         = &apiError{"EntityTooLarge", "Your proposed upload exceeds the maximum allowed size", http.StatusRequestEntityTooLarge}
+	errExpiredRequest               = &apiError{"AccessDenied", "Request has expired", http.StatusForbidden}
+	errIncompleteBody               = &apiError{"IncompleteBody", "The request body is not valid aws-chunked data", http.StatusBadRequest}
+	errInsufficientStorage          = &apiError{"InsufficientStorage", "The quota or the disk has no room for this upload", http.StatusInsufficientStorage}
+	errInternal                     = &apiError{"InternalError", "We encountered an internal error, please try again", http.StatusInternalServerError}
+	errInvalidAccessKeyID           = &apiError{"InvalidAccessKeyId", "The access key ID does not exist", http.StatusForbidden}
+	errInvalidArgument              = &apiError{"InvalidArgument", "Invalid argument", http.StatusBadRequest}
+	errInvalidBucketName            = &apiError{"InvalidBucketName", "The specified bucket is not valid", http.StatusBadRequest}
+	errInvalidDigest                = &apiError{"InvalidDigest", "The digest you specified is not valid", http.StatusBadRequest}
+	errInvalidKey                   = &apiError{"InvalidArgument", "Keys must be plain slash separated paths", http.StatusBadRequest}
+	errInvalidPart                  = &apiError{"InvalidPart", "One or more of the specified parts could not be found", http.StatusBadRequest}
+	errInvalidPartOrder             = &apiError{"InvalidPartOrder", "The list of parts was not in ascending order", http.StatusBadRequest}
+	errKeyIsFolder                  = &apiError{"InvalidRequest", "A folder exists at this key", http.StatusConflict}
+	errMalformedXML                 = &apiError{"MalformedXML", "The XML you provided was not well-formed", http.StatusBadRequest}
+	errMethodNotAllowed             = &apiError{"MethodNotAllowed", "The specified method is not allowed against this resource", http.StatusMethodNotAllowed}
+	errMissingContentSHA256         = &apiError{"InvalidRequest", "Missing required header for this request: x-amz-content-sha256", http.StatusBadRequest}
+	errNoSuchBucket                 = &apiError{"NoSuchBucket", "The specified bucket does not exist", http.StatusNotFound}
+	errNoSuchKey                    = &apiError{"NoSuchKey", "The specified key does not exist", http.StatusNotFound}
+	errNoSuchUpload                 = &apiError{"NoSuchUpload", "The specified multipart upload does not exist", http.StatusNotFound}
+	errNotImplemented               = &apiError{"NotImplemented", "A header or query you provided implies functionality that is not implemented", http.StatusNotImplemented}
+	errRequestTimeTooSkewed         = &apiError{"RequestTimeTooSkewed", "The difference between the request time and the server's time is too large", http.StatusForbidden}
+	errSignatureDoesNotMatch        = &apiError{"SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided", http.StatusForbidden}
+)
+
+// request is one authenticated call, with the bucket and key it names
+type request struct {
+	w      http.ResponseWriter
+	r      *http.Request
+	user   types.User
+	fsys   storage.FS
+	bucket string
+	key    string
+}
+
+// Handler serves the S3 API. Buckets are the top-level folders of the
+// user's files (the mounts, when there are mounts) and keys are paths inside
+// them. Clients must use path-style addressing.
+func Handler() http.Handler {
+	return http.HandlerFunc(serve)
+}
+
+func serve(w http.ResponseWriter, r *http.Request) {
+	// 1. Authenticate with SigV4 (the access key is an API token ID)
+	user, scope, err := authenticate(r)
+	if err != nil {
+		if err == errSignatureDoesNotMatch || err == errInvalidAccessKeyID {
+			seclog.Log("token_rejected", "ip", utils.ClientIP(r), "path", r.URL.Path, "via", "s3")
+		}
+		writeError(w, r, err)
+		return
+	}
+	if scope == tokens.ScopeRead && r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, r, errAccessDenied)
+		return
+	}
+	q := r.URL.Query()
+	for _, sub := range unsupported {
+		if q.Has(sub) {
+			writeError(w, r, errNotImplemented)
+			return
+		}
+	}
+
+	// 2. Dispatch on bucket/key and method
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	req := &request{w: w, r: r, user: user, fsys: users.Files(user), bucket: bucket, key: key}
+	switch {
+	case bucket == "" && r.Method == http.MethodGet:
+		err = req.listBuckets()
+	case bucket == "":
+		err = errMethodNotAllowed
+	case key == "":
+		err = req.serveBucket()
+	default:
+		err = req.serveObject()
+	}
+	if err != nil {
+		writeError(w, r, err)
+	}
+}
+
+func (req *request) serveBucket() error {
+	q := req.r.URL.Query()
+	switch req.r.Method {
+	case http.MethodGet:
+		if q.Has("uploads") {
+			return errNotImplemented // ListMultipartUploads
+		}
+		if q.Has("location") {
+			if _, err := req.bucketPath(); err != nil {
+				return err
+			}
+			return writeXML(req.w, http.StatusOK, locationConstraint{})
+		}
+		return req.listObjects()
+	case http.MethodHead:
+		_, err := req.bucketPath()
+		return err
+	case http.MethodPut:
+		return req.createBucket()
+	case http.MethodDelete:
+		return req.deleteBucket()
+	case http.MethodPost:
+		if q.Has("delete") {
+			return req.deleteObjects()
+		}
+	}
+	return errMethodNotAllowed
+}
+
+func (req *request) serveObject() error {
+	q := req.r.URL.Query()
+	uploadID := q.Get("uploadId")
+	switch req.r.Method {
+	case http.MethodGet, http.MethodHead:
+		if uploadID != "" {
+			return errNotImplemented // ListParts
+		}
+		return req.getObject()
+	case http.MethodPut:
+		if uploadID != "" {
+			return req.uploadPart(uploadID)
+		}
+		if req.r.Header.Get("X-Amz-Copy-Source") != "" {
+			return req.copyObject()
+		}
+		return req.putObject()
+	case http.MethodPost:
+		if q.Has("uploads") {
+			return req.createMultipartUpload()
+		}
+		if uploadID != "" {
+			return req.completeMultipartUpload(uploadID)
+		}
+	case http.MethodDelete:
+		if uploadID != "" {
+			return req.abortMultipartUpload(uploadID)
+		}
+		return req.deleteObject(req.key)
+	}
+	return errMethodNotAllowed
+}
+
+// --- BUCKETS ---
+
+func (req *request) listBuckets() error {
+	entries, err := req.fsys.ReadDir(".")
+	if err != nil {
+		return err
+	}
+	result := listAllMyBucketsResult{Owner: owner{ID: req.user.Username, DisplayName: req.user.Username}}
+	for _, entry := range entries {
+		if !entry.IsDir() || !acl.CanSee(req.user, entry.Name()) || storage.IsInternal(req.fsys, entry.Name()) {
+			continue
+		}
+		created := req.user.CreatedAt
+		if info, err := entry.Info(); err == nil {
+			created = info.ModTime()
+		}
+		result.Buckets = append(result.Buckets, bucketXML{Name: entry.Name(), CreationDate: formatTime(created)})
+	}
+	return writeXML(req.w, http.StatusOK, result)
+}
+
+// bucketPath checks that the bucket is a folder the user can see
+func (req *request) bucketPath() (string, error) {
+	p, ok := storage.Clean(req.bucket)
+	if !ok || p != req.bucket || storage.IsInternal(req.fsys, p) {
+		return "", errInvalidBucketName
+	}
+	if !acl.CanSee(req.user, p) {
+		return "", errNoSuchBucket
+	}
+	info, err := req.fsys.Stat(p)
+	if err != nil || !info.IsDir() {
+		return "", errNoSuchBucket
+	}
+	return p, nil
+}
+
+func (req *request) createBucket() error {
+	p, ok := storage.Clean(req.bucket)
+	if !ok || p != req.bucket || storage.IsInternal(req.fsys, p) {
+		return errInvalidBucketName
+	}
+	if err := req.checkWrite(p, acl.PermWrite); err != nil {
+		return err
+	}
+	if _, err := req.fsys.Stat(p); err == nil {
+		return errBucketExists
+	}
+	if err := req.fsys.Mkdir(p, 0755); err != nil {
+		return err
+	}
+	req.w.Header().Set("Location", "/"+p)
+	req.w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// deleteBucket moves an empty bucket folder to the trash
+func (req *request) deleteBucket() error {
+	p, err := req.bucketPath()
+	if err != nil {
+		return err
+	}
+	if err := req.checkWrite(p, acl.PermDelete); err != nil {
+		return err
+	}
+	entries, err := req.fsys.ReadDir(p)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !storage.IsInternal(req.fsys, path.Join(p, entry.Name())) {
+			return errBucketNotEmpty
+		}
+	}
+	if err := trash.MoveToTrash(req.fsys, p); err != nil {
+		return err
+	}
+	req.w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// --- LISTING ---
+
+// listEntry is a key or a common prefix in a listing
+type listEntry struct {
+	key    string
+	info   fs.FileInfo // nil for common prefixes
+	prefix bool
+}
+
+// listObjects serves ListObjectsV2 (list-type=2) and the original ListObjects
+func (req *request) listObjects() error {
+	if _, err := req.bucketPath(); err != nil {
+		return err
+	}
+	q := req.r.URL.Query()
+	v2 := q.Get("list-type") == "2"
+	prefix, delimiter := q.Get("prefix"), q.Get("delimiter")
+	maxKeys := maxListKeys
+	if s := q.Get("max-keys"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return errInvalidArgument
+		}
+		maxKeys = min(n, maxListKeys)
+	}
+
+	after := q.Get("marker")
+	if v2 {
+		after = q.Get("start-after")
+		if token := q.Get("continuation-token"); token != "" {
+			decoded, err := base64.RawURLEncoding.DecodeString(token)
+			if err != nil {
+				return errInvalidArgument
+			}
+			after = string(decoded)
+		}
+	}
+
+	entries, err := req.collect(prefix, delimiter)
+	if err != nil {
+		return err
+	}
+	start := sort.Search(len(entries), func(i int) bool { return entries[i].key > after })
+	entries = entries[start:]
+	truncated := len(entries) > maxKeys
+	if truncated {
+		entries = entries[:maxKeys]
+	}
+
+	enc := func(s string) string { return s }
+	if q.Get("encoding-type") == "url" {
+		enc = url.QueryEscape
+	}
+	result := listBucketResult{
+		Name:         req.bucket,
+		Prefix:       enc(prefix),
+		Delimiter:    enc(delimiter),
+		MaxKeys:      maxKeys,
+		IsTruncated:  truncated,
+		EncodingType: q.Get("encoding-type"),
+	}
+	for _, entry := range entries {
+		if entry.prefix {
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: enc(entry.key)})
+			continue
+		}
+		result.Contents = append(result.Contents, objectXML{
+			Key:          enc(entry.key),
+			LastModified: formatTime(entry.info.ModTime()),
+			ETag:         storage.ETag(entry.info),
+			Size:         entry.info.Size(),
+			StorageClass: "STANDARD",
+		})
+	}
+	if v2 {
+		result.KeyCount = len(entries)
+		result.ContinuationToken = q.Get("continuation-token")
+		result.StartAfter = enc(q.Get("start-after"))
+		if truncated {
+			result.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(entries[len(entries)-1].key))
+		}
+	} else {
+		result.Marker = enc(q.Get("marker"))
+		if truncated {
+			result.NextMarker = enc(entries[len(entries)-1].key)
+		}
+	}
+	return writeXML(req.w, http.StatusOK, result)
+}
+
+// collect returns the sorted keys of the bucket starting with prefix,
+// grouped into common prefixes by the delimiter. With "/" as delimiter
+// (the usual case) only one folder is read.
+func (req *request) collect(prefix, delimiter string) ([]listEntry, error) {
+	dir := req.bucket
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		p, ok := storage.Clean(path.Join(req.bucket, prefix[:i]))
+		if !ok || p != path.Join(req.bucket, prefix[:i]) {
+			return nil, nil // No file has such a key
+		}
+		dir = p
+	}
+	visible := func(p string) bool {
+		return acl.CanSee(req.user, p) && !storage.IsInternal(req.fsys, p)
+	}
+
+	var entries []listEntry
+	if delimiter == "/" {
+		des, err := req.fsys.ReadDir(dir)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, storage.ErrNotDir) {
+			return nil, err
+		}
+		for _, de := range des {
+			p := path.Join(dir, de.Name())
+			key := storage.Rel(req.bucket, p)
+			if !strings.HasPrefix(key, prefix) || !visible(p) {
+				continue
+			}
+			if de.IsDir() {
+				entries = append(entries, listEntry{key: key + "/", prefix: true})
+			} else if info, err := de.Info(); err == nil {
+				entries = append(entries, listEntry{key: key, info: info})
+			}
+		}
+	} else {
+		err := storage.WalkDir(req.fsys, dir, func(p string, de fs.DirEntry, err error) error {
+			if err != nil {
+				if p == dir {
+					return fs.SkipAll // The prefix names no folder
+				}
+				return err
+			}
+			if p == dir {
+				return nil
+			}
+			key := storage.Rel(req.bucket, p)
+			if !visible(p) {
+				if de.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if de.IsDir() {
+				if !strings.HasPrefix(key+"/", prefix) && !strings.HasPrefix(prefix, key+"/") {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if !strings.HasPrefix(key, prefix) {
+				return nil
+			}
+			info, err := de.Info()
+			if err != nil {
+				return nil
+			}
+			if delimiter != "" {
+				if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+					entries = append(entries, listEntry{key: key[:len(prefix)+i+len(delimiter)], prefix: true})
+					return nil
+				}
+			}
+			entries = append(entries, listEntry{key: key, info: info})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+	// Several keys can share a common prefix
+	deduped := entries[:0]
+	for i, entry := range entries {
+		if i == 0 || entry.key != entries[i-1].key {
+			deduped = append(deduped, entry)
+		}
+	}
+	return deduped, nil
+}
+
+// --- OBJECTS ---
+
+// objectPath maps a key to a path inside the bucket. Keys ending in "/"
+// name folders.
+func (req *request) objectPath(key string) (string, error) {
+	if _, err := req.bucketPath(); err != nil {
+		return "", err
+	}
+	full := path.Join(req.bucket, strings.TrimSuffix(key, "/"))
+	p, ok := storage.Clean(full)
+	if !ok || p != req.bucket+"/"+strings.TrimSuffix(key, "/") || storage.IsInternal(req.fsys, p) {
+		return "", errInvalidKey
+	}
+	return p, nil
+}
+
+// checkWrite applies the access rules and read-only mode to a change
+func (req *request) checkWrite(p, perm string) error {
+	if !acl.Allowed(req.user, p, perm) || storage.IsReadOnly(req.fsys, p) {
+		return errAccessDenied
+	}
+	if perm == acl.PermDelete && storage.HoldsStateFile(req.fsys, p) {
+		return errAccessDenied
+	}
+	return nil
+}
+
+// getObject serves GetObject and HeadObject, including Range requests
+func (req *request) getObject() error {
+	p, err := req.objectPath(req.key)
+	if err != nil {
+		return err
+	}
+	if !acl.Allowed(req.user, p, acl.PermRead) {
+		if acl.CanSee(req.user, p) {
+			return errAccessDenied
+		}
+		return errNoSuchKey
+	}
+	file, err := req.fsys.Open(p)
+	if err != nil {
+		return errNoSuchKey
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		return errNoSuchKey
+	}
+
+	req.w.Header().Set("ETag", storage.ETag(info))
+	http.ServeContent(req.w, req.r, info.Name(), info.ModTime(), file)
+	return nil
+}
+
+// putObject stores the body at the key, creating folders on the way. An
+// empty key ending in "/" creates a folder.
+func (req *request) putObject() error {
+	p, err := req.objectPath(req.key)
+	if err != nil {
+		return err
+	}
+	if err := req.checkWrite(p, acl.PermWrite); err != nil {
+		return err
+	}
+	if strings.HasSuffix(req.key, "/") {
+		// Reading the body verifies the signed payload before anything changes
+		if _, err := io.Copy(io.Discard, req.r.Body); err != nil {
+			return err
+		}
+		if err := req.fsys.MkdirAll(p, 0755); err != nil {
+			return err
+		}
+		info, err := req.fsys.Stat(p)
+		if err != nil {
+			return err
+		}
+		req.w.Header().Set("ETag", storage.ETag(info))
+		req.w.WriteHeader(http.StatusOK)
+		return nil
+	}
+
+	var wantMD5 []byte
+	if s := req.r.Header.Get("Content-MD5"); s != "" {
+		if wantMD5, err = base64.StdEncoding.DecodeString(s); err != nil || len(wantMD5) != md5.Size {
+			return errInvalidDigest
+		}
+	}
+	info, err := req.writeFile(p, req.r.Body, wantMD5)
+	if err != nil {
+		return err
+	}
+	req.w.Header().Set("ETag", storage.ETag(info))
+	req.w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// writeFile streams body into p and returns the new object's info, whose
+// ETag is the one listings report. Nothing changes at p, not even an
+// existing object, if the body is incomplete or doesn't match wantMD5.
+func (req *request) writeFile(p string, body io.Reader, wantMD5 []byte) (fs.FileInfo, error) {
+	if info, err := req.fsys.Stat(p); err == nil && info.IsDir() {
+		return nil, errKeyIsFolder
+	}
+	budget, err := quota.NewBudget(req.user, p, true)
+	if err != nil {
+		return nil, err
+	}
+	if req.r.ContentLength > 0 {
+		if err := budget.Check(req.r.ContentLength); err != nil {
+			return nil, err
+		}
+	}
+	if err := req.fsys.MkdirAll(path.Dir(p), 0755); err != nil {
+		return nil, err
+	}
+	out, err := budget.Create(req.fsys, p)
+	if err != nil {
+		return nil, err
+	}
+	hash := md5.New()
+	_, err = io.Copy(io.MultiWriter(out, hash), body)
+	if err == nil && wantMD5 != nil && string(hash.Sum(nil)) != string(wantMD5) {
+		err = errBadDigest
+	}
+	if err != nil {
+		out.Abort()
+		return nil, err
+	}
+	if err := out.Commit(); err != nil {
+		return nil, err
+	}
+	return req.fsys.Stat(p)
+}
+
+// copyObject copies another object (x-amz-copy-source: /bucket/key)
+func (req *request) copyObject() error {
+	dst, err := req.objectPath(req.key)
+	if err != nil {
+		return err
+	}
+	source, err := url.PathUnescape(req.r.Header.Get("X-Amz-Copy-Source"))
+	if err != nil {
+		return errInvalidArgument
+	}
+	source, _, _ = strings.Cut(source, "?") // versionId
+	srcBucket, srcKey, _ := strings.Cut(strings.TrimPrefix(source, "/"), "/")
+	srcReq := &request{user: req.user, fsys: req.fsys, bucket: srcBucket}
+	src, err := srcReq.objectPath(srcKey)
+	if err != nil {
+		return err
+	}
+	if !acl.Allowed(req.user, src, acl.PermRead) {
+		return errAccessDenied
+	}
+	if err := req.checkWrite(dst, acl.PermWrite); err != nil {
+		return err
+	}
+	info, err := req.fsys.Stat(src)
+	if err != nil || info.IsDir() {
+		return errNoSuchKey
+	}
+
+	if src != dst { // Copying onto itself only replaces metadata, which isn't kept
+		budget, err := quota.NewBudget(req.user, dst, false)
+		if err != nil {
+			return err
+		}
+		if err := budget.Reserve(info.Size()); err != nil {
+			return err
+		}
+		if err := req.fsys.MkdirAll(path.Dir(dst), 0755); err != nil {
+			return err
+		}
+		if err := utils.CopyFile(req.fsys, src, dst); err != nil {
+			budget.Release(info.Size())
+			return err
+		}
+		if info, err = req.fsys.Stat(dst); err != nil {
+			return err
+		}
+	}
+	return writeXML(req.w, http.StatusOK, copyObjectResult{LastModified: formatTime(info.ModTime()), ETag: storage.ETag(info)})
+}
+
+// deleteObject moves a file (or an empty folder, for keys ending in "/") to
+// the trash. Like S3, deleting a missing key succeeds.
+func (req *request) deleteObject(key string) error {
+	if err := req.removeKey(key); err != nil {
+		return err
+	}
+	req.w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (req *request) removeKey(key string) error {
+	p, err := req.objectPath(key)
+	if err != nil {
+		return err
+	}
+	if err := req.checkWrite(p, acl.PermDelete); err != nil {
+		return err
+	}
+	info, err := req.fsys.Stat(p)
+	if err != nil || info.IsDir() != strings.HasSuffix(key, "/") {
+		return nil
+	}
+	if info.IsDir() {
+		if entries, err := req.fsys.ReadDir(p); err != nil || len(entries) > 0 {
+			return nil // Folders vanish with their last file in S3; keep them here
+		}
+	}
+	return trash.MoveToTrash(req.fsys, p)
+}
+
+// deleteObjects serves the multi-object delete (POST ?delete)
+func (req *request) deleteObjects() error {
+	if _, err := req.bucketPath(); err != nil {
+		return err
+	}
+	var body deleteRequest
+	if err := readXML(req.r.Body, &body); err != nil {
+		return err
+	}
+	var result deleteResult
+	for _, object := range body.Objects {
+		if err := req.removeKey(object.Key); err != nil {
+			e, ok := errIsS3(err)
+			if !ok {
+				e = errInternal
+			}
+			result.Errors = append(result.Errors, deleteError{Key: object.Key, Code: e.Code, Message: e.Message})
+		} else if !body.Quiet {
+			result.Deleted = append(result.Deleted, deletedObject{Key: object.Key})
+		}
+	}
+	return writeXML(req.w, http.StatusOK, result)
+}
+
+// --- HELPERS ---
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+// readXML parses a small request body. Reading it to the end also checks
+// its signature.
+func readXML(body io.Reader, v interface{}) error {
+	data, err := io.ReadAll(io.LimitReader(body, maxXMLBody+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > maxXMLBody {
+		return errEntityTooLarge
+	}
+	if err := xml.Unmarshal(data, v); err != nil {
+		return errMalformedXML
+	}
+	return nil
+}
+
+func writeXML(w http.ResponseWriter, status int, v interface{}) error {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	io.WriteString(w, xml.Header)
+	return xml.NewEncoder(w).Encode(v)
+}
+
+// writeError sends an S3 error document; storage errors are mapped to the
+// closest S3 error
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	e, ok := errIsS3(err)
+	switch {
+	case ok:
+	case errors.Is(err, fs.ErrNotExist):
+		e = errNoSuchKey
+	case errors.Is(err, fs.ErrPermission), errors.Is(err, storage.ErrReadOnly):
+		e = errAccessDenied
+	case errors.Is(err, storage.ErrNotDir), errors.Is(err, fs.ErrExist):
+		e = errKeyIsFolder
+	case errors.Is(err, quota.ErrTooLarge):
+		e = errEntityTooLargeUpload
+	case quota.IsLimit(err):
+		e = errInsufficientStorage
+	default:
+		fmt.Printf("S3 %s %s: %v\n", r.Method, r.URL.Path, err)
+		e = errInternal
+	}
+	if r.Method == http.MethodHead {
+		w.WriteHeader(e.Status) // No body for HEAD
+		return
+	}
+	writeXML(w, e.Status, errorResponse{Code: e.Code, Message: e.Message, Resource: r.URL.Path})
+}
+
+// --- XML DOCUMENTS ---
+
+type errorResponse struct {
+	XMLName  xml.Name `xml:"Error"`
+	Code     string   `xml:"Code"`
+	Message  string   `xml:"Message"`
+	Resource string   `xml:"Resource"`
+}
+
+type owner struct {
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName"`
+}
+
+type bucketXML struct {
+	Name         string `xml:"Name"`
+	CreationDate string `xml:"CreationDate"`
+}
+
+type listAllMyBucketsResult struct {
+	XMLName xml.Name    `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListAllMyBucketsResult"`
+	Owner   owner       `xml:"Owner"`
+	Buckets []bucketXML `xml:"Buckets>Bucket"`
+}
+
+type locationConstraint struct {
+	XMLName xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ LocationConstraint"`
+}
+
+type objectXML struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type commonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+type listBucketResult struct {
+	XMLName               xml.Name       `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name                  string         `xml:"Name"`
+	Prefix                string         `xml:"Prefix"`
+	Marker                string         `xml:"Marker,omitempty"`
+	NextMarker            string         `xml:"NextMarker,omitempty"`
+	ContinuationToken     string         `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+	StartAfter            string         `xml:"StartAfter,omitempty"`
+	KeyCount              int            `xml:"KeyCount,omitempty"`
+	Delimiter             string         `xml:"Delimiter,omitempty"`
+	MaxKeys               int            `xml:"MaxKeys"`
+	EncodingType          string         `xml:"EncodingType,omitempty"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	Contents              []objectXML    `xml:"Contents"`
+	CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+}
+
+type copyObjectResult struct {
+	XMLName      xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CopyObjectResult"`
+	LastModified string   `xml:"LastModified"`
+	ETag         string   `xml:"ETag"`
+}
+
+type deleteRequest struct {
+	Quiet   bool `xml:"Quiet"`
+	Objects []struct {
+		Key string `xml:"Key"`
+	} `xml:"Object"`
+}
+
+type deletedObject struct {
+	Key string `xml:"Key"`
+}
+
+type deleteError struct {
+	Key     string `xml:"Key"`
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+type deleteResult struct {
+	XMLName xml.Name        `xml:"http://s3.amazonaws.com/doc/2006-03-01/ DeleteResult"`
+	Deleted []deletedObject `xml:"Deleted"`
+	Errors  []deleteError   `xml:"Error"`
+}
@@ -0,0 +1,373 @@
+package s3api
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"GoFiles/internal/tokens"
+	"GoFiles/internal/types"
+	"GoFiles/internal/users"
+)
+
+const (
+	algorithm      = "AWS4-HMAC-SHA256"
+	amzDateFormat  = "20060102T150405Z"
+	maxClockSkew   = 15 * time.Minute
+	maxPresignTime = 7 * 24 * time.Hour
+	maxChunkSize   = 16 << 20
+
+	unsignedPayload   = "UNSIGNED-PAYLOAD"
+	streamingSigned   = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	streamingTrailer  = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD-TRAILER"
+	streamingUnsigned = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+)
+
+var emptySHA256 = hex.EncodeToString(sha256.New().Sum(nil))
+
+// signature holds what a verified request was signed with, for checking
+// the chunks of a streaming upload
+type signature struct {
+	key       []byte // Derived signing key
+	date      string // X-Amz-Date
+	scope     string // date/region/service/aws4_request
+	signature string
+}
+
+// authenticate checks the SigV4 signature of a request (Authorization header
+// or presigned URL) against the API token named by the access key. The body
+// is replaced by one that fails if it doesn't match what was signed.
+func authenticate(r *http.Request) (types.User, string, error) {
+	q := r.URL.Query()
+	presigned := q.Get("X-Amz-Algorithm") != ""
+
+	var credential, signedHeaders, sig, amzDate string
+	if presigned {
+		if q.Get("X-Amz-Algorithm") != algorithm {
+			return types.User{}, "", errAuthorizationHeaderMalformed
+		}
+		credential = q.Get("X-Amz-Credential")
+		signedHeaders = q.Get("X-Amz-SignedHeaders")
+		sig = q.Get("X-Amz-Signature")
+		amzDate = q.Get("X-Amz-Date")
+	} else {
+		header := r.Header.Get("Authorization")
+		if header == "" {
+			return types.User{}, "", errAccessDenied // Anonymous requests aren't supported
+		}
+		rest, ok := strings.CutPrefix(header, algorithm+" ")
+		if !ok {
+			return types.User{}, "", errAuthorizationHeaderMalformed
+		}
+		for _, part := range strings.Split(rest, ",") {
+			k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+			switch k {
+			case "Credential":
+				credential = v
+			case "SignedHeaders":
+				signedHeaders = v
+			case "Signature":
+				sig = v
+			}
+		}
+		amzDate = r.Header.Get("X-Amz-Date")
+	}
+
+	// 1. Credential = access key/date/region/s3/aws4_request
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 || parts[3] != "s3" || parts[4] != "aws4_request" || signedHeaders == "" || sig == "" {
+		return types.User{}, "", errAuthorizationHeaderMalformed
+	}
+	accessKey, scope := parts[0], strings.Join(parts[1:], "/")
+	signedAt, err := time.Parse(amzDateFormat, amzDate)
+	if err != nil || !strings.HasPrefix(amzDate, parts[1]) {
+		return types.User{}, "", errAuthorizationHeaderMalformed
+	}
+	if presigned {
+		expires, err := strconv.Atoi(q.Get("X-Amz-Expires"))
+		if err != nil || expires < 0 || time.Duration(expires)*time.Second > maxPresignTime {
+			return types.User{}, "", errAuthorizationHeaderMalformed
+		}
+		if time.Now().After(signedAt.Add(time.Duration(expires) * time.Second)) {
+			return types.User{}, "", errExpiredRequest
+		}
+	} else if d := time.Since(signedAt); d > maxClockSkew || d < -maxClockSkew {
+		return types.User{}, "", errRequestTimeTooSkewed
+	}
+
+	// 2. The access key is an API token ID
+	token, ok := tokens.ValidateID(accessKey)
+	secret := tokens.S3Secret(accessKey)
+	if !ok || secret == "" {
+		return types.User{}, "", errInvalidAccessKeyID
+	}
+	user, found := users.Get(token.Username)
+	if !found {
+		return types.User{}, "", errInvalidAccessKeyID
+	}
+
+	// 3. Recompute the signature
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	if presigned {
+		payloadHash = unsignedPayload
+	} else if payloadHash == "" {
+		return types.User{}, "", errMissingContentSHA256
+	}
+	canonical := canonicalRequest(r, signedHeaders, payloadHash, presigned)
+	stringToSign := algorithm + "\n" + amzDate + "\n" + scope + "\n" + hexSHA256([]byte(canonical))
+	key := signingKey(secret, parts[1], parts[2])
+	if !hmac.Equal([]byte(hex.EncodeToString(hmacSHA256(key, stringToSign))), []byte(sig)) {
+		return types.User{}, "", errSignatureDoesNotMatch
+	}
+
+	// 4. Check the body against what was signed
+	signed := signature{key: key, date: amzDate, scope: scope, signature: sig}
+	switch payloadHash {
+	case unsignedPayload:
+	case streamingSigned, streamingTrailer, streamingUnsigned:
+		r.Body = &chunkedReader{body: r.Body, r: bufio.NewReader(r.Body), sig: signed,
+			signed: payloadHash != streamingUnsigned, trailer: payloadHash != streamingSigned}
+		if n, err := strconv.ParseInt(r.Header.Get("X-Amz-Decoded-Content-Length"), 10, 64); err == nil {
+			r.ContentLength = n
+		} else {
+			r.ContentLength = -1
+		}
+	default:
+		want, err := hex.DecodeString(payloadHash)
+		if err != nil || len(want) != sha256.Size {
+			return types.User{}, "", errInvalidDigest
+		}
+		r.Body = &hashingReader{ReadCloser: r.Body, hash: sha256.New(), want: want}
+	}
+	return user, token.Scope, nil
+}
+
+// canonicalRequest builds the string SigV4 signs
+func canonicalRequest(r *http.Request, signedHeaders, payloadHash string, presigned bool) string {
+	var query []string
+	for k, values := range r.URL.Query() {
+		if presigned && k == "X-Amz-Signature" {
+			continue
+		}
+		for _, v := range values {
+			query = append(query, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	sort.Strings(query)
+
+	var headers strings.Builder
+	for _, name := range strings.Split(signedHeaders, ";") {
+		var value string
+		switch name {
+		case "host":
+			value = r.Host
+		case "content-length":
+			value = r.Header.Get("Content-Length")
+			if value == "" && r.ContentLength >= 0 {
+				value = strconv.FormatInt(r.ContentLength, 10)
+			}
+		default:
+			value = strings.Join(r.Header.Values(name), ",")
+		}
+		headers.WriteString(name + ":" + strings.Join(strings.Fields(value), " ") + "\n")
+	}
+
+	return strings.Join([]string{
+		r.Method,
+		uriEncode(r.URL.Path, false),
+		strings.Join(query, "&"),
+		headers.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+}
+
+// uriEncode escapes everything but the unreserved characters, like AWS does
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func signingKey(secret, date, region string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secret), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	return hmacSHA256(key, "aws4_request")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func hexSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// hashingReader fails at the end of the body if it doesn't match the
+// x-amz-content-sha256 the client signed
+type hashingReader struct {
+	io.ReadCloser
+	hash hash.Hash
+	want []byte
+}
+
+func (h *hashingReader) Read(p []byte) (int, error) {
+	n, err := h.ReadCloser.Read(p)
+	h.hash.Write(p[:n])
+	if err == io.EOF && !bytes.Equal(h.hash.Sum(nil), h.want) {
+		return n, errContentSHA256Mismatch
+	}
+	return n, err
+}
+
+// chunkedReader decodes an aws-chunked body ("size;chunk-signature=...\r\n
+// data\r\n", ending with an empty chunk and optional trailers), checking each
+// chunk's signature when the upload is signed
+type chunkedReader struct {
+	body    io.Closer
+	r       *bufio.Reader
+	signed  bool
+	trailer bool // Trailing headers follow the final chunk
+	sig     signature
+	chunk   []byte // Verified data not read yet
+	done    bool
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	for len(c.chunk) == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+		if err := c.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, c.chunk)
+	c.chunk = c.chunk[n:]
+	return n, nil
+}
+
+func (c *chunkedReader) Close() error {
+	return c.body.Close()
+}
+
+// next reads and verifies the following chunk. The body must end with the
+// empty final chunk: without it, a truncated upload would look complete.
+func (c *chunkedReader) next() error {
+	line, err := c.readLine()
+	if err != nil {
+		return errIncompleteBody
+	}
+	sizeHex, ext, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseInt(strings.TrimSpace(sizeHex), 16, 64)
+	if err != nil || size < 0 || size > maxChunkSize {
+		return errIncompleteBody
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return errIncompleteBody
+	}
+	if size > 0 {
+		if crlf, err := c.readLine(); err != nil || crlf != "" {
+			return errIncompleteBody
+		}
+	}
+
+	if c.signed {
+		chunkSig, _ := strings.CutPrefix(ext, "chunk-signature=")
+		stringToSign := algorithm + "-PAYLOAD\n" + c.sig.date + "\n" + c.sig.scope + "\n" +
+			c.sig.signature + "\n" + emptySHA256 + "\n" + hexSHA256(data)
+		if !c.verify(stringToSign, chunkSig) {
+			return errSignatureDoesNotMatch
+		}
+	}
+
+	if size == 0 {
+		if err := c.readTrailers(); err != nil {
+			return err
+		}
+		c.done = true
+	}
+	c.chunk = data
+	return nil
+}
+
+// readTrailers reads the headers after the final chunk (checksums) up to
+// the empty line that ends the body. A signed upload with trailers ends them
+// with a signature over them instead.
+func (c *chunkedReader) readTrailers() error {
+	signedTrailer := c.signed && c.trailer
+	var trailers strings.Builder
+	for {
+		line, err := c.readLine()
+		if err == io.EOF && !signedTrailer {
+			return nil // The final empty line is optional
+		}
+		if err != nil {
+			return errIncompleteBody
+		}
+		switch {
+		case line == "" && !signedTrailer:
+			return nil
+		case line == "":
+			// Some clients put an empty line before the signature
+		case strings.HasPrefix(line, "x-amz-trailer-signature:") && signedTrailer:
+			stringToSign := algorithm + "-TRAILER\n" + c.sig.date + "\n" + c.sig.scope + "\n" +
+				c.sig.signature + "\n" + hexSHA256([]byte(trailers.String()))
+			if !c.verify(stringToSign, strings.TrimPrefix(line, "x-amz-trailer-signature:")) {
+				return errSignatureDoesNotMatch
+			}
+			return nil
+		default:
+			trailers.WriteString(line + "\n")
+		}
+	}
+}
+
+// verify checks the signature of a chunk or the trailers. Each one is
+// chained to the one before it.
+func (c *chunkedReader) verify(stringToSign, sig string) bool {
+	want := hex.EncodeToString(hmacSHA256(c.sig.key, stringToSign))
+	if !hmac.Equal([]byte(want), []byte(sig)) {
+		return false
+	}
+	c.sig.signature = sig
+	return true
+}
+
+// readLine returns the next line without its line ending, or io.EOF if the
+// body ended right before it
+func (c *chunkedReader) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		if err == io.EOF && line == "" {
+			return "", io.EOF
+		}
+		return "", errIncompleteBody
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
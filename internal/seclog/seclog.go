@@ -0,0 +1,49 @@
+package seclog
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// logger writes security events; stdout until Init opens the log file
+var logger = log.New(os.Stdout, "", log.LstdFlags)
+var logFile *os.File
+
+// Init sends security events to the given file (appending)
+func Init(path string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	logger = log.New(file, "", log.LstdFlags)
+	logFile = file
+	return nil
+}
+
+// Close flushes the log file to disk and goes back to stdout
+func Close() error {
+	if logFile == nil {
+		return nil
+	}
+	logger = log.New(os.Stdout, "", log.LstdFlags)
+	err := logFile.Sync()
+	if cerr := logFile.Close(); err == nil {
+		err = cerr
+	}
+	logFile = nil
+	return err
+}
+
+// Log records a security event with key/value details, e.g.
+// Log("login_failed", "ip", "1.2.3.4", "user", "bob")
+func Log(event string, keyvals ...string) {
+	var b strings.Builder
+	b.WriteString("event=" + event)
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		fmt.Fprintf(&b, " %s=%q", keyvals[i], keyvals[i+1])
+	}
+	logger.Println(b.String())
+	fmt.Println("🚨 " + b.String())
+}
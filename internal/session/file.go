@@ -0,0 +1,107 @@
+package session
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"GoFiles/internal/config"
+)
+
+// renewFlushDelay is how long renewals may wait in memory before they are
+// written out. Losing them in a crash only shortens those sessions a bit.
+var renewFlushDelay = 5 * time.Minute
+
+// FileStore keeps sessions in memory and mirrors them to a JSON file,
+// so logins survive a restart.
+type FileStore struct {
+	*MemoryStore
+	path   string
+	diskMu sync.Mutex
+
+	timerMu sync.Mutex
+	timer   *time.Timer // Pending flush of renewals, nil if none
+}
+
+// NewFileStore loads existing sessions from path (if any)
+func NewFileStore(path string) (*FileStore, error) {
+	f := &FileStore{MemoryStore: NewMemoryStore(), path: path}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		var list []Session
+		if err := json.Unmarshal(data, &list); err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		for _, s := range list {
+			if now.Before(s.ExpiresAt) {
+				f.sessions[s.ID] = s
+			}
+		}
+	}
+	return f, nil
+}
+
+func (f *FileStore) Save(s Session) error {
+	f.MemoryStore.Save(s)
+	return f.flush()
+}
+
+// Renew keeps the renewal in memory and schedules a flush, so active
+// sessions don't rewrite the file on every request. Any other change
+// writes the pending renewals along with it.
+func (f *FileStore) Renew(s Session) error {
+	f.MemoryStore.Save(s)
+
+	f.timerMu.Lock()
+	defer f.timerMu.Unlock()
+	if f.timer == nil {
+		f.timer = time.AfterFunc(renewFlushDelay, func() {
+			f.timerMu.Lock()
+			f.timer = nil
+			f.timerMu.Unlock()
+			f.flush()
+		})
+	}
+	return nil
+}
+
+func (f *FileStore) Delete(id string) error {
+	f.MemoryStore.Delete(id)
+	return f.flush()
+}
+
+func (f *FileStore) DeleteExpired(now time.Time) int {
+	n := f.MemoryStore.DeleteExpired(now)
+	if n > 0 {
+		f.flush()
+	}
+	return n
+}
+
+func (f *FileStore) Close() error {
+	f.timerMu.Lock()
+	if f.timer != nil {
+		f.timer.Stop()
+		f.timer = nil
+	}
+	f.timerMu.Unlock()
+	return f.flush()
+}
+
+// flush writes all sessions to the file
+func (f *FileStore) flush() error {
+	f.diskMu.Lock()
+	defer f.diskMu.Unlock()
+
+	data, err := json.MarshalIndent(f.List(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return config.WriteFileAtomic(f.path, data)
+}
@@ -0,0 +1,169 @@
+package session
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"GoFiles/internal/config"
+	"GoFiles/internal/security"
+)
+
+// Session is a logged-in browser. Only a hash of the token is kept,
+// so a leaked sessions file can't be replayed.
+type Session struct {
+	ID        string    `json:"id"` // sha256 of the token
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"created_at"`
+	LastSeen  time.Time `json:"last_seen"`
+	ExpiresAt time.Time `json:"expires_at"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+}
+
+// Store persists sessions. Implementations must be safe for concurrent use.
+type Store interface {
+	Save(s Session) error
+	Renew(s Session) error // Save where only LastSeen and ExpiresAt changed
+	Get(id string) (Session, bool)
+	Delete(id string) error
+	List() []Session
+	DeleteExpired(now time.Time) int
+	Close() error
+}
+
+// Active store, set by Init
+var store Store = NewMemoryStore()
+
+// Signals the cleanup task to stop, and reports when it has
+var stopCleanup = make(chan struct{})
+var cleanupDone = make(chan struct{})
+var stopOnce sync.Once
+
+// renewAfter limits how often sliding renewal writes to the store
+const renewAfter = time.Minute
+
+// Init opens the configured session store and starts the expiry cleanup
+func Init() error {
+	if config.SessionStore == "file" {
+		fileStore, err := NewFileStore(config.SessionsFileName)
+		if err != nil {
+			return err
+		}
+		store = fileStore
+	}
+
+	go startCleanup()
+	return nil
+}
+
+// Close stops the cleanup task, then flushes and closes the active store
+func Close() error {
+	stopOnce.Do(func() {
+		close(stopCleanup)
+		<-cleanupDone
+	})
+	return store.Close()
+}
+
+// Create starts a new session and returns its secret token
+func Create(username, ip, userAgent string) (string, Session, error) {
+	token, err := security.RandomToken(32)
+	if err != nil {
+		return "", Session{}, err
+	}
+
+	now := time.Now()
+	s := Session{
+		ID:        security.HashToken(token),
+		Username:  username,
+		CreatedAt: now,
+		LastSeen:  now,
+		ExpiresAt: now.Add(config.SessionTTL),
+		IP:        ip,
+		UserAgent: userAgent,
+	}
+	return token, s, store.Save(s)
+}
+
+// Validate looks up a token, enforcing expiry and sliding the expiry forward
+func Validate(token string) (Session, bool) {
+	s, ok := store.Get(security.HashToken(token))
+	if !ok {
+		return Session{}, false
+	}
+
+	now := time.Now()
+	if now.After(s.ExpiresAt) {
+		store.Delete(s.ID)
+		return Session{}, false
+	}
+
+	// Sliding renewal, capped by the absolute lifetime
+	if now.Sub(s.LastSeen) > renewAfter {
+		s.LastSeen = now
+		s.ExpiresAt = now.Add(config.SessionTTL)
+		if limit := s.CreatedAt.Add(config.SessionMaxLifetime); s.ExpiresAt.After(limit) {
+			s.ExpiresAt = limit
+		}
+		store.Renew(s)
+	}
+	return s, true
+}
+
+// Destroy ends the session belonging to a token
+func Destroy(token string) {
+	store.Delete(security.HashToken(token))
+}
+
+// ListFor returns the active sessions of a user
+func ListFor(username string) []Session {
+	now := time.Now()
+	var list []Session
+	for _, s := range store.List() {
+		if s.Username == username && now.Before(s.ExpiresAt) {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+// Revoke ends one of the user's sessions by ID
+func Revoke(username, id string) bool {
+	s, ok := store.Get(id)
+	if !ok || s.Username != username {
+		return false
+	}
+	store.Delete(id)
+	return true
+}
+
+// RevokeAll ends every session of a user except the one with keepID (may be empty)
+func RevokeAll(username, keepID string) int {
+	n := 0
+	for _, s := range store.List() {
+		if s.Username == username && s.ID != keepID {
+			store.Delete(s.ID)
+			n++
+		}
+	}
+	return n
+}
+
+// startCleanup periodically removes expired sessions until Close is called
+func startCleanup() {
+	defer close(cleanupDone)
+
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCleanup:
+			return
+		case <-ticker.C:
+		}
+		if n := store.DeleteExpired(time.Now()); n > 0 {
+			fmt.Printf("🧹 Removed %d expired sessions\n", n)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package session
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"GoFiles/internal/config"
+)
+
+func newFileStore(t *testing.T) (*FileStore, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "sessions.json")
+	f, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, path
+}
+
+func TestFileStore(t *testing.T) {
+	f, path := newFileStore(t)
+	now := time.Now()
+	live := Session{ID: "live", Username: "alice", ExpiresAt: now.Add(time.Hour)}
+	expired := Session{ID: "expired", Username: "alice", ExpiresAt: now.Add(-time.Minute)}
+
+	// 1. Saves are written straight away
+	f.Save(live)
+	f.Save(expired)
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("Save didn't write the file: %v", err)
+	}
+
+	// 2. Reloading drops expired sessions
+	loaded, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := loaded.Get("live"); !ok {
+		t.Error("live session lost on reload")
+	}
+	if _, ok := loaded.Get("expired"); ok {
+		t.Error("expired session kept on reload")
+	}
+
+	// 3. Deletes are written straight away
+	f.Delete("live")
+	loaded, _ = NewFileStore(path)
+	if _, ok := loaded.Get("live"); ok {
+		t.Error("deleted session came back on reload")
+	}
+
+	// 4. No temporary files are left behind
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("got %d files next to the store, want 1", len(entries))
+	}
+}
+
+func TestFileStoreRenew(t *testing.T) {
+	f, path := newFileStore(t)
+	s := Session{ID: "id", Username: "alice", ExpiresAt: time.Now().Add(time.Hour)}
+	f.Save(s)
+
+	renewed := s
+	renewed.ExpiresAt = s.ExpiresAt.Add(time.Hour)
+	f.Renew(renewed)
+
+	// The renewal is visible at once but not written yet
+	if got, _ := f.Get("id"); !got.ExpiresAt.Equal(renewed.ExpiresAt) {
+		t.Error("renewal not visible in memory")
+	}
+	loaded, _ := NewFileStore(path)
+	if got, _ := loaded.Get("id"); !got.ExpiresAt.Equal(s.ExpiresAt) {
+		t.Error("renewal written before the flush delay")
+	}
+
+	// Close writes it out
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	loaded, _ = NewFileStore(path)
+	if got, _ := loaded.Get("id"); !got.ExpiresAt.Equal(renewed.ExpiresAt) {
+		t.Error("renewal not written on Close")
+	}
+}
+
+func TestFileStoreRenewFlushes(t *testing.T) {
+	saved := renewFlushDelay
+	renewFlushDelay = 10 * time.Millisecond
+	defer func() { renewFlushDelay = saved }()
+
+	f, path := newFileStore(t)
+	f.Renew(Session{ID: "id", ExpiresAt: time.Now().Add(time.Hour)})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if loaded, _ := NewFileStore(path); loaded != nil {
+			if _, ok := loaded.Get("id"); ok {
+				return
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("renewal never written")
+}
+
+func TestValidate(t *testing.T) {
+	saved := store
+	store = NewMemoryStore()
+	defer func() { store = saved }()
+
+	savedTTL, savedMax := config.SessionTTL, config.SessionMaxLifetime
+	config.SessionTTL, config.SessionMaxLifetime = time.Hour, 2*time.Hour
+	defer func() { config.SessionTTL, config.SessionMaxLifetime = savedTTL, savedMax }()
+
+	token, s, err := Create("alice", "127.0.0.1", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := Validate(token); !ok {
+		t.Fatal("new session rejected")
+	}
+	if _, ok := Validate("wrong"); ok {
+		t.Fatal("unknown token accepted")
+	}
+
+	tests := []struct {
+		name      string
+		age       time.Duration // Since creation
+		lastSeen  time.Duration // Since last renewal
+		ok        bool
+		expiresIn time.Duration // After renewal, 0 if unchanged
+	}{
+		{"recently renewed", 10 * time.Minute, 30 * time.Second, true, 0},
+		{"slides forward", 10 * time.Minute, 5 * time.Minute, true, time.Hour},
+		{"capped by lifetime", 90 * time.Minute, 5 * time.Minute, true, 30 * time.Minute},
+		{"expired", 3 * time.Hour, 2 * time.Hour, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Now()
+			s.CreatedAt = now.Add(-tt.age)
+			s.LastSeen = now.Add(-tt.lastSeen)
+			s.ExpiresAt = s.LastSeen.Add(time.Hour)
+			if limit := s.CreatedAt.Add(2 * time.Hour); s.ExpiresAt.After(limit) {
+				s.ExpiresAt = limit
+			}
+			store.Save(s)
+
+			got, ok := Validate(token)
+			if ok != tt.ok {
+				t.Fatalf("Validate ok = %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				if _, found := store.Get(s.ID); found {
+					t.Error("expired session not deleted")
+				}
+				return
+			}
+			want := s.ExpiresAt
+			if tt.expiresIn != 0 {
+				want = now.Add(tt.expiresIn)
+			}
+			if d := got.ExpiresAt.Sub(want); d < -time.Second || d > time.Second {
+				t.Errorf("expires at %v, want %v", got.ExpiresAt, want)
+			}
+		})
+	}
+}
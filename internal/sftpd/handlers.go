@@ -0,0 +1,385 @@
+package sftpd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sync"
+
+	"GoFiles/internal/acl"
+	"GoFiles/internal/quota"
+	"GoFiles/internal/storage"
+	"GoFiles/internal/tokens"
+	"GoFiles/internal/trash"
+	"GoFiles/internal/types"
+	"GoFiles/internal/users"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// maxPending caps how much data a client may send ahead of a gap in a file
+// it is uploading (clients pipeline writes, so they can arrive out of order)
+const maxPending = 32 << 20
+
+// session is the SFTP connection of one login. The account is looked up
+// again for every request, so changes to its access rules, home or quota
+// apply at once, and deleting it or changing what it logged in with (the
+// password, 2FA, the API token or the SSH key) cuts it off.
+type session struct {
+	username     string
+	readOnly     bool          // Logged in with a read-only API token
+	token        string        // The API token it logged in with, if any
+	publicKey    ssh.PublicKey // The SSH key it logged in with, if any
+	passwordHash string        // The password hash when it logged in with a password
+}
+
+// handler returns what serves one request for the account as it is now
+func (s *session) handler() (*handler, error) {
+	user, found := users.Get(s.username)
+	switch {
+	case !found:
+		return nil, sftp.ErrSSHFxPermissionDenied
+	case s.token != "":
+		token, ok := tokens.Validate(s.token)
+		found = ok && token.Username == user.Username
+	case s.publicKey != nil:
+		_, found = users.AuthenticateKey(user.Username, s.publicKey)
+	default:
+		found = user.Password == s.passwordHash && !users.HasTwoFactor(user)
+	}
+	if !found {
+		return nil, sftp.ErrSSHFxPermissionDenied
+	}
+	return &handler{user: user, fsys: users.Files(user), readOnly: s.readOnly}, nil
+}
+
+func (s *session) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	h, err := s.handler()
+	if err != nil {
+		return nil, err
+	}
+	return h.Fileread(r)
+}
+
+func (s *session) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	h, err := s.handler()
+	if err != nil {
+		return nil, err
+	}
+	return h.Filewrite(r)
+}
+
+func (s *session) Filecmd(r *sftp.Request) error {
+	h, err := s.handler()
+	if err != nil {
+		return err
+	}
+	return h.Filecmd(r)
+}
+
+func (s *session) PosixRename(r *sftp.Request) error {
+	h, err := s.handler()
+	if err != nil {
+		return err
+	}
+	return h.PosixRename(r)
+}
+
+func (s *session) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	h, err := s.handler()
+	if err != nil {
+		return nil, err
+	}
+	return h.Filelist(r)
+}
+
+// handler serves one SFTP request over the user's files, with the same
+// access rules, read-only checks and trash as the HTTP API
+type handler struct {
+	user     types.User
+	fsys     storage.FS
+	readOnly bool // Logged in with a read-only API token
+}
+
+// name cleans a request path and checks a permission on it
+func (h *handler) name(name, perm string) (string, error) {
+	p, ok := storage.Clean(name)
+	if !ok {
+		return "", sftp.ErrSSHFxPermissionDenied
+	}
+	if storage.IsInternal(h.fsys, p) {
+		return "", sftp.ErrSSHFxNoSuchFile
+	}
+	if perm == acl.PermDelete && storage.HoldsStateFile(h.fsys, p) {
+		return "", sftp.ErrSSHFxPermissionDenied
+	}
+	if perm == acl.PermRead {
+		if !acl.CanSee(h.user, p) {
+			return "", sftp.ErrSSHFxNoSuchFile // Hidden entries don't exist for the client
+		}
+		return p, nil
+	}
+	if !acl.Allowed(h.user, p, perm) || h.readOnly || storage.IsReadOnly(h.fsys, p) {
+		return "", sftp.ErrSSHFxPermissionDenied
+	}
+	return p, nil
+}
+
+// Fileread opens a file for download
+func (h *handler) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	p, err := h.name(r.Filepath, acl.PermRead)
+	if err != nil {
+		return nil, err
+	}
+	if !acl.Allowed(h.user, p, acl.PermRead) {
+		return nil, sftp.ErrSSHFxPermissionDenied
+	}
+	file, err := h.fsys.Open(p)
+	if err != nil {
+		return nil, status(err)
+	}
+	if info, err := file.Stat(); err != nil || info.IsDir() {
+		file.Close()
+		return nil, sftp.ErrSSHFxFailure
+	}
+	return file, nil
+}
+
+// Filewrite opens a file for upload. Files are always written from the
+// start: existing files can be replaced but not changed in place.
+func (h *handler) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	p, err := h.name(r.Filepath, acl.PermWrite)
+	if err != nil {
+		return nil, err
+	}
+	flags := r.Pflags()
+	info, err := h.fsys.Stat(p)
+	switch {
+	case err == nil && info.IsDir():
+		return nil, sftp.ErrSSHFxFailure
+	case err == nil && flags.Excl:
+		return nil, fmt.Errorf("%s already exists", r.Filepath)
+	case err == nil && (!flags.Trunc || flags.Append):
+		return nil, sftp.ErrSSHFxOpUnsupported
+	case err != nil && !flags.Creat:
+		return nil, status(err)
+	}
+
+	budget, err := quota.NewBudget(h.user, p, true)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := budget.Create(h.fsys, p)
+	if err != nil {
+		return nil, status(err)
+	}
+	return &writerAt{file: file, pending: map[int64][]byte{}}, nil
+}
+
+// Filecmd handles everything that isn't reading, writing or listing
+func (h *handler) Filecmd(r *sftp.Request) error {
+	switch r.Method {
+	case "Setstat":
+		return nil // Permissions and times are managed by the storage
+	case "Mkdir":
+		p, err := h.name(r.Filepath, acl.PermWrite)
+		if err != nil {
+			return err
+		}
+		return status(h.fsys.Mkdir(p, 0755))
+	case "Rename":
+		return h.rename(r.Filepath, r.Target, false)
+	case "Remove", "Rmdir":
+		p, err := h.name(r.Filepath, acl.PermDelete)
+		if err != nil {
+			return err
+		}
+		info, err := h.fsys.Stat(p)
+		if err != nil {
+			return status(err)
+		}
+		if r.Method == "Remove" && info.IsDir() {
+			return sftp.ErrSSHFxFailure
+		}
+		if r.Method == "Rmdir" {
+			if !info.IsDir() {
+				return sftp.ErrSSHFxFailure
+			}
+			if entries, err := h.fsys.ReadDir(p); err != nil || len(entries) > 0 {
+				return fmt.Errorf("%s is not empty", r.Filepath)
+			}
+		}
+		if p == "." {
+			return sftp.ErrSSHFxPermissionDenied
+		}
+		return status(trash.MoveToTrash(h.fsys, p))
+	}
+	return sftp.ErrSSHFxOpUnsupported // Links
+}
+
+// PosixRename is a rename that replaces an existing file
+func (h *handler) PosixRename(r *sftp.Request) error {
+	return h.rename(r.Filepath, r.Target, true)
+}
+
+func (h *handler) rename(oldName, newName string, replace bool) error {
+	oldPath, err := h.name(oldName, acl.PermDelete)
+	if err != nil {
+		return err
+	}
+	newPath, err := h.name(newName, acl.PermWrite)
+	if err != nil {
+		return err
+	}
+	// Rules deeper in a folder apply as well
+	if !acl.AllowedTree(h.user, h.fsys, oldPath, oldPath, acl.PermDelete) ||
+		!acl.AllowedTree(h.user, h.fsys, newPath, oldPath, acl.PermWrite) {
+		return sftp.ErrSSHFxPermissionDenied
+	}
+	if _, err := h.fsys.Stat(newPath); err == nil && !replace {
+		return fmt.Errorf("%s already exists", newName)
+	}
+	return status(h.fsys.Rename(oldPath, newPath))
+}
+
+// Filelist lists folders and stats files
+func (h *handler) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	p, err := h.name(r.Filepath, acl.PermRead)
+	if err != nil {
+		return nil, err
+	}
+
+	switch r.Method {
+	case "Stat":
+		info, err := h.fsys.Stat(p)
+		if err != nil {
+			return nil, status(err)
+		}
+		return listerAt{info}, nil
+	case "List":
+		entries, err := h.fsys.ReadDir(p)
+		if err != nil {
+			return nil, status(err)
+		}
+		var infos listerAt
+		for _, entry := range entries {
+			child := path.Join(p, entry.Name())
+			if !acl.CanSee(h.user, child) || storage.IsInternal(h.fsys, child) {
+				continue
+			}
+			if info, err := entry.Info(); err == nil {
+				infos = append(infos, info)
+			}
+		}
+		return infos, nil
+	}
+	return nil, sftp.ErrSSHFxOpUnsupported // Readlink
+}
+
+// status turns storage errors into SFTP status codes
+func status(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, fs.ErrNotExist):
+		return sftp.ErrSSHFxNoSuchFile
+	case errors.Is(err, fs.ErrPermission), errors.Is(err, storage.ErrReadOnly):
+		return sftp.ErrSSHFxPermissionDenied
+	case errors.Is(err, errors.ErrUnsupported):
+		return sftp.ErrSSHFxOpUnsupported
+	}
+	return err
+}
+
+type listerAt []os.FileInfo
+
+func (l listerAt) ListAt(list []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(list, l[offset:])
+	if n < len(list) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// writerAt turns the pipelined, possibly out of order writes of an SFTP
+// upload into the sequential stream storage.CreateAtomic expects
+type writerAt struct {
+	mu       sync.Mutex
+	file     storage.PendingFile // Replaces the old file on Close if complete, counted against the budget
+	offset   int64               // Where the next sequential write starts
+	pending  map[int64][]byte    // Writes that arrived ahead of offset
+	buffered int
+	err      error
+}
+
+func (f *writerAt) WriteAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return 0, f.err
+	}
+	switch {
+	case off < f.offset:
+		f.err = sftp.ErrSSHFxOpUnsupported // Rewriting what was already written
+		return 0, f.err
+	case off > f.offset:
+		if f.buffered+len(p) > maxPending {
+			f.err = errors.New("writes arrived too far out of order")
+			return 0, f.err
+		}
+		f.pending[off] = append([]byte(nil), p...)
+		f.buffered += len(p)
+		return len(p), nil
+	}
+
+	if f.err = f.write(p); f.err != nil {
+		return 0, f.err
+	}
+	for {
+		next, ok := f.pending[f.offset]
+		if !ok {
+			return len(p), nil
+		}
+		delete(f.pending, f.offset)
+		f.buffered -= len(next)
+		if f.err = f.write(next); f.err != nil {
+			return 0, f.err
+		}
+	}
+}
+
+func (f *writerAt) write(p []byte) error {
+	n, err := f.file.Write(p)
+	f.offset += int64(n)
+	return err
+}
+
+// TransferError is called before Close when the connection drops
+func (f *writerAt) TransferError(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err == nil {
+		f.err = err
+	}
+}
+
+func (f *writerAt) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err == nil && len(f.pending) > 0 {
+		f.err = errors.New("upload is missing data")
+	}
+	if f.err != nil {
+		f.file.Abort()
+		return f.err
+	}
+	return f.file.Commit()
+}
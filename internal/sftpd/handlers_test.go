@@ -0,0 +1,136 @@
+package sftpd
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+
+	"GoFiles/internal/acl"
+	"GoFiles/internal/config"
+	"GoFiles/internal/storage"
+	"GoFiles/internal/tokens"
+	"GoFiles/internal/types"
+	"GoFiles/internal/users"
+)
+
+// setup starts a test with no accounts and an empty storage root
+func setup(t *testing.T) {
+	t.Chdir(t.TempDir()) // The config and key files live in the working directory
+	config.AppConfig = types.ConfigFile{}
+	storage.Root = storage.NewMemory()
+}
+
+// TestSessionRechecksLogin checks that a change to the account cuts off the
+// sessions that logged in with what it replaced, and only those
+func TestSessionRechecksLogin(t *testing.T) {
+	setup(t)
+	if err := tokens.Init(); err != nil {
+		t.Fatal(err)
+	}
+	user, err := users.Create("alice", "password1", users.RoleUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, token, err := tokens.Create("alice", "laptop", tokens.ScopeRead, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := users.AddSSHKey("alice", "laptop", string(ssh.MarshalAuthorizedKey(sshPub)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	password := &session{username: "alice", passwordHash: user.Password}
+	withToken := &session{username: "alice", readOnly: true, token: secret}
+	withKey := &session{username: "alice", publicKey: sshPub}
+
+	tests := []struct {
+		name   string
+		change func() error
+		want   map[*session]bool // Sessions that may still make requests
+	}{
+		{"unchanged", func() error { return nil },
+			map[*session]bool{password: true, withToken: true, withKey: true}},
+		{"password changed", func() error { _, err := users.Modify("alice", users.Change{Password: "password2"}); return err },
+			map[*session]bool{password: false, withToken: true, withKey: true}},
+		{"token revoked", func() error { return tokens.Revoke("alice", token.ID) },
+			map[*session]bool{password: false, withToken: false, withKey: true}},
+		{"key removed", func() error { return users.RemoveSSHKey("alice", key.Fingerprint) },
+			map[*session]bool{password: false, withToken: false, withKey: false}},
+	}
+	for _, tt := range tests {
+		if err := tt.change(); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		for s, want := range tt.want {
+			h, err := s.handler()
+			if got := err == nil; got != want {
+				t.Errorf("%s: session (token %t, key %t) allowed = %v, want %v", tt.name, s.token != "", s.publicKey != nil, got, want)
+			}
+			if err != nil && err != sftp.ErrSSHFxPermissionDenied {
+				t.Errorf("%s: handler error = %v, want %v", tt.name, err, sftp.ErrSSHFxPermissionDenied)
+			}
+			if h != nil && h.readOnly != s.readOnly {
+				t.Errorf("%s: handler readOnly = %v, want %v", tt.name, h.readOnly, s.readOnly)
+			}
+		}
+	}
+}
+
+func TestSessionEndsWhenUserDeleted(t *testing.T) {
+	setup(t)
+	if _, err := users.Setup("admin", "password1"); err != nil {
+		t.Fatal(err)
+	}
+	user, err := users.Create("bob", "password1", users.RoleUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &session{username: "bob", passwordHash: user.Password}
+	if _, err := s.handler(); err != nil {
+		t.Fatalf("handler before delete: %v", err)
+	}
+	if err := users.Delete("bob"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.handler(); err != sftp.ErrSSHFxPermissionDenied {
+		t.Errorf("handler after delete = %v, want %v", err, sftp.ErrSSHFxPermissionDenied)
+	}
+}
+
+// TestRenameChecksWholeTree checks that a rule on a folder can't be bypassed
+// by renaming one of its parents
+func TestRenameChecksWholeTree(t *testing.T) {
+	setup(t)
+	storage.Root.MkdirAll("projects/locked", 0755)
+	storage.Root.MkdirAll("drafts/locked", 0755)
+	h := &handler{fsys: storage.Root, user: types.User{Username: "bob", Role: users.RoleUser, Rules: []types.ACLRule{
+		{Path: "/", Permissions: []string{acl.PermRead, acl.PermWrite, acl.PermDelete}},
+		{Path: "/projects/locked", Permissions: []string{acl.PermRead}},
+	}}}
+
+	tests := []struct {
+		old, new string
+		want     error
+	}{
+		{"/projects", "/archive", sftp.ErrSSHFxPermissionDenied},
+		{"/drafts", "/projects", sftp.ErrSSHFxPermissionDenied}, // Would replace the protected folder
+		{"/drafts", "/archive", nil},
+	}
+	for _, tt := range tests {
+		if err := h.rename(tt.old, tt.new, true); err != tt.want {
+			t.Errorf("rename(%q, %q) = %v, want %v", tt.old, tt.new, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package sftpd
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"GoFiles/internal/auth"
+	"GoFiles/internal/tokens"
+	"GoFiles/internal/users"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// handshakeTimeout is how long a client may take to log in
+const handshakeTimeout = 30 * time.Second
+
+// Running server state, set by Start
+var listener net.Listener
+var conns = map[net.Conn]struct{}{}
+var connsMu sync.Mutex
+var closed bool
+var wg sync.WaitGroup
+
+// EnsureHostKey makes sure keyFile holds an SSH host key, generating an
+// Ed25519 key on first run. Returns true if a new key was written.
+func EnsureHostKey(keyFile string) (bool, error) {
+	if _, err := os.Stat(keyFile); !os.IsNotExist(err) {
+		_, err := loadHostKey(keyFile)
+		return false, err
+	}
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return false, err
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "GoFiles host key")
+	if err != nil {
+		return false, err
+	}
+	return true, os.WriteFile(keyFile, pem.EncodeToMemory(block), 0600)
+}
+
+// Fingerprint returns the SHA-256 fingerprint of the host key, in the form
+// ssh shows when connecting for the first time
+func Fingerprint(keyFile string) (string, error) {
+	signer, err := loadHostKey(keyFile)
+	if err != nil {
+		return "", err
+	}
+	return ssh.FingerprintSHA256(signer.PublicKey()), nil
+}
+
+// Start serves SFTP on addr in the background. Users log in with their
+// password, an API token (read tokens give a read-only session) or one of
+// their registered public keys.
+func Start(addr, keyFile string) error {
+	signer, err := loadHostKey(keyFile)
+	if err != nil {
+		return err
+	}
+	serverConfig := &ssh.ServerConfig{
+		PasswordCallback:  checkPassword,
+		PublicKeyCallback: checkPublicKey,
+		ServerVersion:     "SSH-2.0-GoFiles",
+	}
+	serverConfig.AddHostKey(signer)
+
+	listener, err = net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return // Closed
+			}
+			connsMu.Lock()
+			if closed {
+				connsMu.Unlock()
+				conn.Close()
+				return
+			}
+			conns[conn] = struct{}{}
+			connsMu.Unlock()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				serveConn(conn, serverConfig)
+				connsMu.Lock()
+				delete(conns, conn)
+				connsMu.Unlock()
+			}()
+		}
+	}()
+	return nil
+}
+
+// Close stops accepting connections and ends the open sessions
+func Close() {
+	if listener == nil {
+		return
+	}
+	listener.Close()
+	connsMu.Lock()
+	closed = true
+	for conn := range conns {
+		conn.Close()
+	}
+	connsMu.Unlock()
+	wg.Wait()
+}
+
+// checkPassword accepts the account password or an API token, throttled
+// like every other login
+func checkPassword(meta ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	ip, _, _ := net.SplitHostPort(meta.RemoteAddr().String())
+	user, scope, wait, ok := auth.CheckCredentials(ip, meta.User(), string(password), "sftp")
+	if wait > 0 {
+		return nil, fmt.Errorf("too many failed attempts, try again in %s", wait.Round(time.Second))
+	}
+	if !ok {
+		return nil, errors.New("invalid username or password")
+	}
+	// Remember what the login relied on, so the session ends when it changes
+	if strings.HasPrefix(string(password), tokens.Prefix) {
+		return &ssh.Permissions{Extensions: map[string]string{"scope": scope, "token": string(password)}}, nil
+	}
+	return &ssh.Permissions{Extensions: map[string]string{"scope": scope, "password_hash": user.Password}}, nil
+}
+
+// checkPublicKey accepts the user's registered keys. Clients offer every key
+// they have, so a key that doesn't match is not a failed login.
+func checkPublicKey(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	if _, ok := users.AuthenticateKey(meta.User(), key); !ok {
+		return nil, errors.New("unknown public key")
+	}
+	return &ssh.Permissions{Extensions: map[string]string{"scope": "", "public_key": string(key.Marshal())}}, nil
+}
+
+// serveConn runs the SSH handshake and serves the sftp subsystem on the
+// session channels the client opens
+func serveConn(conn net.Conn, serverConfig *ssh.ServerConfig) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	sshConn, channels, requests, err := ssh.NewServerConn(conn, serverConfig)
+	if err != nil {
+		return
+	}
+	defer sshConn.Close()
+	conn.SetDeadline(time.Time{})
+	go ssh.DiscardRequests(requests)
+
+	login := sshConn.Permissions.Extensions
+	s := &session{
+		username:     sshConn.User(),
+		readOnly:     login["scope"] == tokens.ScopeRead,
+		token:        login["token"],
+		passwordHash: login["password_hash"],
+	}
+	if login["public_key"] != "" {
+		if s.publicKey, err = ssh.ParsePublicKey([]byte(login["public_key"])); err != nil {
+			return
+		}
+	}
+	if _, err := s.handler(); err != nil {
+		return
+	}
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sftp sessions are supported")
+			continue
+		}
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go serveSession(channel, channelRequests, s)
+	}
+}
+
+// serveSession waits for the client to ask for the sftp subsystem. Shells
+// and commands are refused.
+func serveSession(channel ssh.Channel, requests <-chan *ssh.Request, s *session) {
+	defer channel.Close()
+	for req := range requests {
+		var subsystem struct{ Name string }
+		if req.Type != "subsystem" || ssh.Unmarshal(req.Payload, &subsystem) != nil || subsystem.Name != "sftp" {
+			req.Reply(false, nil)
+			continue
+		}
+		req.Reply(true, nil)
+		go ssh.DiscardRequests(requests)
+
+		server := sftp.NewRequestServer(channel, sftp.Handlers{FileGet: s, FilePut: s, FileCmd: s, FileList: s})
+		if err := server.Serve(); err != nil && err != io.EOF {
+			fmt.Printf("SFTP session for %s ended: %v\n", s.username, err)
+		}
+		server.Close()
+		return
+	}
+}
+
+func loadHostKey(keyFile string) (ssh.Signer, error) {
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SSH host key %s: %w", keyFile, err)
+	}
+	return signer, nil
+}
@@ -0,0 +1,247 @@
+package sftpd
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+
+	"GoFiles/internal/auth"
+	"GoFiles/internal/config"
+	"GoFiles/internal/storage"
+	"GoFiles/internal/tokens"
+	"GoFiles/internal/users"
+)
+
+// startServer serves SFTP on a loopback port until the test ends
+func startServer(t *testing.T) (addr string, hostKey ssh.PublicKey) {
+	t.Helper()
+	auth.Init()
+	if err := tokens.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := EnsureHostKey("host_key"); err != nil {
+		t.Fatal(err)
+	}
+	signer, err := loadHostKey("host_key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed = false
+	if err := Start("127.0.0.1:0", "host_key"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(Close)
+	return listener.Addr().String(), signer.PublicKey()
+}
+
+// dial logs in over SSH and opens an SFTP client
+func dial(addr string, hostKey ssh.PublicKey, username string, method ssh.AuthMethod) (*sftp.Client, error) {
+	conn, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            username,
+		Auth:            []ssh.AuthMethod{method},
+		HostKeyCallback: ssh.FixedHostKey(hostKey),
+	})
+	if err != nil {
+		return nil, err
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// writeFile uploads data through the client
+func writeFile(client *sftp.Client, name string, data []byte) error {
+	f, err := client.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readFile downloads a file through the client
+func readFile(client *sftp.Client, name string) ([]byte, error) {
+	f, err := client.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// TestLogin checks the ways to log in over SSH
+func TestLogin(t *testing.T) {
+	setup(t)
+	addr, hostKey := startServer(t)
+	if _, err := users.Setup("alice", "password1"); err != nil {
+		t.Fatal(err)
+	}
+	secret, _, err := tokens.Create("alice", "laptop", tokens.ScopeRead, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.AddSSHKey("alice", "laptop", string(ssh.MarshalAuthorizedKey(signer.PublicKey()))); err != nil {
+		t.Fatal(err)
+	}
+	_, otherPriv, _ := ed25519.GenerateKey(rand.Reader)
+	otherSigner, _ := ssh.NewSignerFromKey(otherPriv)
+
+	tests := []struct {
+		name     string
+		username string
+		method   ssh.AuthMethod
+		ok       bool
+	}{
+		{"password", "alice", ssh.Password("password1"), true},
+		{"wrong password", "alice", ssh.Password("wrong"), false},
+		{"unknown user", "mallory", ssh.Password("password1"), false},
+		{"API token", "alice", ssh.Password(secret), true},
+		{"token of another user", "mallory", ssh.Password(secret), false},
+		{"registered key", "alice", ssh.PublicKeys(signer), true},
+		{"unknown key", "alice", ssh.PublicKeys(otherSigner), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := dial(addr, hostKey, tt.username, tt.method)
+			if (err == nil) != tt.ok {
+				t.Fatalf("login error = %v, want success %v", err, tt.ok)
+			}
+			if client != nil {
+				defer client.Close()
+				if _, err := client.ReadDir("/"); err != nil {
+					t.Errorf("listing after login: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// TestFiles checks confinement to the home folder, read-only tokens, the
+// trash and uploads over a real connection
+func TestFiles(t *testing.T) {
+	setup(t)
+	addr, hostKey := startServer(t)
+	if _, err := users.Setup("admin", "password1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.Create("bob", "password1", users.RoleUser); err != nil {
+		t.Fatal(err)
+	}
+	home := "homes/bob"
+	if _, err := users.Modify("bob", users.Change{Home: &home}); err != nil {
+		t.Fatal(err)
+	}
+	storage.WriteFile(storage.Root, "secret.txt", []byte("admin only"))
+	storage.WriteFile(storage.Root, "homes/bob/notes.txt", []byte("notes"))
+	secret, _, err := tokens.Create("bob", "backup", tokens.ScopeRead, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bob, err := dial(addr, hostKey, "bob", ssh.Password("password1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bob.Close()
+
+	t.Run("confined to the home", func(t *testing.T) {
+		if data, err := readFile(bob, "/notes.txt"); err != nil || string(data) != "notes" {
+			t.Errorf("read /notes.txt = %q, %v", data, err)
+		}
+		for _, name := range []string{"/secret.txt", "/../secret.txt", "../../secret.txt"} {
+			if _, err := readFile(bob, name); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("read %s = %v, want %v", name, err, fs.ErrNotExist)
+			}
+		}
+	})
+
+	t.Run("read-only token", func(t *testing.T) {
+		client, err := dial(addr, hostKey, "bob", ssh.Password(secret))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		if _, err := readFile(client, "/notes.txt"); err != nil {
+			t.Errorf("read with a read token: %v", err)
+		}
+		if err := writeFile(client, "/new.txt", []byte("x")); !errors.Is(err, os.ErrPermission) {
+			t.Errorf("upload with a read token = %v, want %v", err, os.ErrPermission)
+		}
+		if err := client.Remove("/notes.txt"); !errors.Is(err, os.ErrPermission) {
+			t.Errorf("delete with a read token = %v, want %v", err, os.ErrPermission)
+		}
+	})
+
+	t.Run("delete goes to the trash", func(t *testing.T) {
+		storage.WriteFile(storage.Root, "homes/bob/old.txt", []byte("old"))
+		if err := bob.Remove("/old.txt"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := storage.Root.Stat("homes/bob/old.txt"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("file still there after delete: %v", err)
+		}
+		entries, _ := storage.Root.ReadDir(path.Join(home, config.TrashFolder))
+		if len(entries) == 0 {
+			t.Error("nothing in the trash")
+		}
+		if _, err := bob.Stat("/" + config.TrashFolder); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("stat of the trash = %v, want %v", err, fs.ErrNotExist)
+		}
+	})
+
+	t.Run("writes out of order", func(t *testing.T) {
+		data := bytes.Repeat([]byte("0123456789"), 10000)
+		f, err := bob.Create("/upload.bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		half := int64(len(data) / 2)
+		if _, err := f.WriteAt(data[half:], half); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.WriteAt(data[:half], 0); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		got, err := storage.ReadFile(storage.Root, "homes/bob/upload.bin")
+		if err != nil || !bytes.Equal(got, data) {
+			t.Errorf("uploaded %d bytes (%v), want %d in order", len(got), err, len(data))
+		}
+	})
+
+	t.Run("gap in an upload", func(t *testing.T) {
+		f, err := bob.Create("/partial.bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteAt([]byte("end"), 100)
+		if err := f.Close(); err == nil {
+			t.Error("upload with missing data committed")
+		}
+		if _, err := storage.Root.Stat("homes/bob/partial.bin"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("incomplete upload saved: %v", err)
+		}
+	})
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"crypto/rand"
+	"io"
+	"path"
+	"strings"
+)
+
+// Temporary files are named ".gofiles-<random>.tmp" and live next to the
+// file they will replace, so the final rename stays on the same disk
+const tempPrefix = ".gofiles-"
+const tempSuffix = ".tmp"
+
+// PendingFile is a file being written out of sight. Commit puts it in place
+// in one step; Abort throws it away and leaves the old file as it was.
+type PendingFile interface {
+	io.Writer
+	Commit() error
+	Abort() error
+}
+
+// AtomicCreator is implemented by backends that stage writes themselves:
+// on disk with fsync and the permissions of the file being replaced, on S3
+// by not completing the upload.
+type AtomicCreator interface {
+	CreateAtomic(name string) (PendingFile, error)
+}
+
+// CreateAtomic starts writing name so that it is never seen half written,
+// not even after a crash. Backends without their own way write a temporary
+// file next to name and rename it.
+func CreateAtomic(fsys FS, name string) (PendingFile, error) {
+	if creator, ok := fsys.(AtomicCreator); ok {
+		return creator.CreateAtomic(name)
+	}
+	name, err := clean("create", name)
+	if err != nil {
+		return nil, err
+	}
+	tmp := path.Join(path.Dir(name), tempName())
+	w, err := fsys.Create(tmp)
+	if err != nil {
+		return nil, err
+	}
+	return &renamingFile{WriteCloser: w, fsys: fsys, tmp: tmp, name: name}, nil
+}
+
+// renamingFile is the PendingFile of backends without their own
+type renamingFile struct {
+	io.WriteCloser
+	fsys      FS
+	tmp, name string
+}
+
+func (f *renamingFile) Commit() error {
+	if err := f.Close(); err != nil {
+		f.fsys.Remove(f.tmp)
+		return err
+	}
+	if err := f.fsys.Rename(f.tmp, f.name); err != nil {
+		f.fsys.Remove(f.tmp)
+		return err
+	}
+	return nil
+}
+
+func (f *renamingFile) Abort() error {
+	f.Close()
+	return f.fsys.Remove(f.tmp)
+}
+
+// tempCleaner is implemented by backends that can be left with temporary
+// files by a crash
+type tempCleaner interface {
+	RemoveTempFiles() int
+}
+
+// RemoveTempFiles deletes the temporary files of writes that never finished
+// and returns how many there were. Read-only trees are left alone.
+func RemoveTempFiles(fsys FS) int {
+	switch f := fsys.(type) {
+	case *MountFS:
+		removed := 0
+		for _, name := range f.names {
+			removed += RemoveTempFiles(f.mounts[name])
+		}
+		return removed
+	case tempCleaner:
+		return f.RemoveTempFiles()
+	}
+	return 0
+}
+
+func tempName() string {
+	return tempPrefix + rand.Text() + tempSuffix
+}
+
+func isTempName(name string) bool {
+	return strings.HasPrefix(name, tempPrefix) && strings.HasSuffix(name, tempSuffix)
+}
@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestCreateAtomic checks that a pending file only replaces the old one
+// when committed, on a backend with its own staging and one without
+func TestCreateAtomic(t *testing.T) {
+	backends := []struct {
+		name string
+		fsys FS
+	}{
+		{"local", NewLocal(t.TempDir())},
+		{"memory", NewMemory()},
+	}
+	for _, tt := range backends {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := WriteFile(tt.fsys, "a.txt", []byte("old")); err != nil {
+				t.Fatal(err)
+			}
+
+			// 1. Aborted: the old content stays
+			file, err := CreateAtomic(tt.fsys, "a.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			file.Write([]byte("half"))
+			if data, _ := ReadFile(tt.fsys, "a.txt"); string(data) != "old" {
+				t.Errorf("before commit: %q, want %q", data, "old")
+			}
+			file.Abort()
+			if data, _ := ReadFile(tt.fsys, "a.txt"); string(data) != "old" {
+				t.Errorf("after abort: %q, want %q", data, "old")
+			}
+
+			// 2. Committed: the new content is in place
+			file, err = CreateAtomic(tt.fsys, "a.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			file.Write([]byte("new"))
+			if err := file.Commit(); err != nil {
+				t.Fatal(err)
+			}
+			if data, _ := ReadFile(tt.fsys, "a.txt"); string(data) != "new" {
+				t.Errorf("after commit: %q, want %q", data, "new")
+			}
+
+			// 3. No temporary file is left either way
+			entries, _ := tt.fsys.ReadDir(".")
+			if len(entries) != 1 {
+				t.Errorf("%d entries left, want only a.txt", len(entries))
+			}
+		})
+	}
+}
+
+// TestRemoveTempFiles checks that temporary files left by a crash are
+// removed, and nothing else
+func TestRemoveTempFiles(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "docs"), 0755)
+	os.WriteFile(filepath.Join(root, "docs", "a.txt"), nil, 0644)
+	os.WriteFile(filepath.Join(root, "docs", tempName()), nil, 0644)
+
+	if removed := RemoveTempFiles(NewLocal(root)); removed != 1 {
+		t.Errorf("removed %d files, want 1", removed)
+	}
+	entries, _ := os.ReadDir(filepath.Join(root, "docs"))
+	if len(entries) != 1 || entries[0].Name() != "a.txt" {
+		t.Errorf("left %v, want only a.txt", entries)
+	}
+}
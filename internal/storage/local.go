@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Local stores files in a folder on disk
+type Local struct {
+	root string
+	disk string // root made absolute with symlinks resolved, see diskPath
+}
+
+func NewLocal(root string) *Local {
+	return &Local{root: root, disk: resolvePath(root)}
+}
+
+// path maps an FS name to a path on disk
+func (l *Local) path(op, name string) (string, error) {
+	name, err := clean(op, name)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(l.root, filepath.FromSlash(name)), nil
+}
+
+func (l *Local) Stat(name string) (fs.FileInfo, error) {
+	p, err := l.path("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(p)
+}
+
+func (l *Local) ReadDir(name string) ([]fs.DirEntry, error) {
+	p, err := l.path("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadDir(p)
+}
+
+func (l *Local) Open(name string) (File, error) {
+	p, err := l.path("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(p)
+}
+
+func (l *Local) Create(name string) (io.WriteCloser, error) {
+	p, err := l.path("create", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Create(p)
+}
+
+func (l *Local) Mkdir(name string, perm os.FileMode) error {
+	p, err := l.path("mkdir", name)
+	if err != nil {
+		return err
+	}
+	return os.Mkdir(p, perm)
+}
+
+func (l *Local) MkdirAll(name string, perm os.FileMode) error {
+	p, err := l.path("mkdir", name)
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(p, perm)
+}
+
+func (l *Local) Rename(oldName, newName string) error {
+	oldPath, err := l.path("rename", oldName)
+	if err != nil {
+		return err
+	}
+	newPath, err := l.path("rename", newName)
+	if err != nil {
+		return err
+	}
+	return os.Rename(oldPath, newPath)
+}
+
+func (l *Local) Remove(name string) error {
+	p, err := l.removable(name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(p)
+}
+
+func (l *Local) RemoveAll(name string) error {
+	p, err := l.removable(name)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(p)
+}
+
+// removable refuses to delete the root folder itself
+func (l *Local) removable(name string) (string, error) {
+	cleaned, err := clean("remove", name)
+	if err != nil {
+		return "", err
+	}
+	if cleaned == "." {
+		return "", &fs.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
+	}
+	return l.path("remove", cleaned)
+}
+
+// CreateAtomic writes to a temporary file next to name, which Commit flushes
+// to disk and renames over name. A replaced file keeps its permissions.
+func (l *Local) CreateAtomic(name string) (PendingFile, error) {
+	p, err := l.path("create", name)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(p); err == nil && info.IsDir() {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrExist}
+	}
+	tmp := filepath.Join(filepath.Dir(p), tempName())
+	file, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(p); err == nil {
+		if err := file.Chmod(info.Mode().Perm()); err != nil {
+			file.Close()
+			os.Remove(tmp)
+			return nil, err
+		}
+	}
+	return &localPending{File: file, name: p}, nil
+}
+
+type localPending struct {
+	*os.File
+	name string
+}
+
+func (f *localPending) Commit() error {
+	err := f.Sync()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.File.Name(), f.name)
+	}
+	if err != nil {
+		os.Remove(f.File.Name())
+		return err
+	}
+	// Make the rename itself durable; not every system can sync a folder
+	if dir, err := os.Open(filepath.Dir(f.name)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
+
+func (f *localPending) Abort() error {
+	f.Close()
+	return os.Remove(f.File.Name())
+}
+
+// RemoveTempFiles deletes what writes interrupted by a crash left behind
+func (l *Local) RemoveTempFiles() int {
+	removed := 0
+	filepath.WalkDir(l.root, func(p string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && isTempName(d.Name()) && os.Remove(p) == nil {
+			removed++
+		}
+		return nil
+	})
+	return removed
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package storage
+
+import "errors"
+
+// FreeSpace isn't available here, so the free space check is skipped
+func (l *Local) FreeSpace(name string) (int64, error) {
+	return 0, errors.ErrUnsupported
+}
@@ -0,0 +1,14 @@
+//go:build unix
+
+package storage
+
+import "syscall"
+
+// FreeSpace returns the space available to the server on the disk of the root folder
+func (l *Local) FreeSpace(name string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(l.root, &stat); err != nil {
+		return 0, err
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
@@ -0,0 +1,278 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Memory keeps the whole tree in RAM. Useful for demos and for exercising
+// handlers without touching disk; everything is lost on restart.
+type Memory struct {
+	mu    sync.RWMutex
+	nodes map[string]*memNode // Keyed by cleaned name, "." is the root
+}
+
+type memNode struct {
+	data    []byte
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{nodes: map[string]*memNode{
+		".": {mode: fs.ModeDir | 0755, modTime: time.Now()},
+	}}
+}
+
+func (m *Memory) Stat(name string) (fs.FileInfo, error) {
+	name, err := clean("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	node, ok := m.nodes[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return node.info(name), nil
+}
+
+func (m *Memory) ReadDir(name string) ([]fs.DirEntry, error) {
+	name, err := clean("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := m.checkDir("readdir", name); err != nil {
+		return nil, err
+	}
+
+	var entries []fs.DirEntry
+	for child, node := range m.nodes {
+		if child != "." && path.Dir(child) == name {
+			entries = append(entries, fs.FileInfoToDirEntry(node.info(child)))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func (m *Memory) Open(name string) (File, error) {
+	name, err := clean("open", name)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	node, ok := m.nodes[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	// Files are replaced as a whole on write, so sharing the slice is safe
+	return &memFile{Reader: bytes.NewReader(node.data), info: node.info(name)}, nil
+}
+
+func (m *Memory) Create(name string) (io.WriteCloser, error) {
+	name, err := clean("create", name)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if node, ok := m.nodes[name]; ok && node.mode.IsDir() {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrExist}
+	}
+	if err := m.checkDir("create", path.Dir(name)); err != nil {
+		return nil, err
+	}
+	m.nodes[name] = &memNode{mode: 0644, modTime: time.Now()}
+	return &memWriter{fsys: m, name: name}, nil
+}
+
+func (m *Memory) Mkdir(name string, perm os.FileMode) error {
+	name, err := clean("mkdir", name)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.nodes[name]; ok {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	}
+	if err := m.checkDir("mkdir", path.Dir(name)); err != nil {
+		return err
+	}
+	m.nodes[name] = &memNode{mode: fs.ModeDir | perm.Perm(), modTime: time.Now()}
+	return nil
+}
+
+func (m *Memory) MkdirAll(name string, perm os.FileMode) error {
+	name, err := clean("mkdir", name)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Create missing folders from the top down
+	parts := strings.Split(name, "/")
+	for i := range parts {
+		dir := strings.Join(parts[:i+1], "/")
+		node, ok := m.nodes[dir]
+		if !ok {
+			m.nodes[dir] = &memNode{mode: fs.ModeDir | perm.Perm(), modTime: time.Now()}
+		} else if !node.mode.IsDir() {
+			return &fs.PathError{Op: "mkdir", Path: dir, Err: ErrNotDir}
+		}
+	}
+	return nil
+}
+
+func (m *Memory) Rename(oldName, newName string) error {
+	oldName, err := clean("rename", oldName)
+	if err != nil {
+		return err
+	}
+	newName, err = clean("rename", newName)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	node, ok := m.nodes[oldName]
+	if !ok || oldName == "." {
+		return &fs.PathError{Op: "rename", Path: oldName, Err: fs.ErrNotExist}
+	}
+	if oldName == newName {
+		return nil
+	}
+	if strings.HasPrefix(newName, oldName+"/") {
+		return &fs.PathError{Op: "rename", Path: newName, Err: fs.ErrInvalid}
+	}
+	if err := m.checkDir("rename", path.Dir(newName)); err != nil {
+		return err
+	}
+	if target, ok := m.nodes[newName]; ok && (target.mode.IsDir() || node.mode.IsDir()) {
+		return &fs.PathError{Op: "rename", Path: newName, Err: fs.ErrExist}
+	}
+
+	// Move the node and, for folders, everything below it
+	for name, n := range m.nodes {
+		if name == oldName || strings.HasPrefix(name, oldName+"/") {
+			delete(m.nodes, name)
+			m.nodes[newName+strings.TrimPrefix(name, oldName)] = n
+		}
+	}
+	return nil
+}
+
+func (m *Memory) Remove(name string) error {
+	name, err := clean("remove", name)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if name == "." {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
+	}
+	if _, ok := m.nodes[name]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	for child := range m.nodes {
+		if strings.HasPrefix(child, name+"/") {
+			return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrExist}
+		}
+	}
+	delete(m.nodes, name)
+	return nil
+}
+
+func (m *Memory) RemoveAll(name string) error {
+	name, err := clean("remove", name)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if name == "." {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
+	}
+	for child := range m.nodes {
+		if child == name || strings.HasPrefix(child, name+"/") {
+			delete(m.nodes, child)
+		}
+	}
+	return nil
+}
+
+// checkDir reports an error unless name is an existing folder (caller holds mu)
+func (m *Memory) checkDir(op, name string) error {
+	node, ok := m.nodes[name]
+	if !ok {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	if !node.mode.IsDir() {
+		return &fs.PathError{Op: op, Path: name, Err: ErrNotDir}
+	}
+	return nil
+}
+
+func (n *memNode) info(name string) fs.FileInfo {
+	return &memInfo{name: path.Base(name), size: int64(len(n.data)), mode: n.mode, modTime: n.modTime}
+}
+
+// memFile is an open file; reads see the contents at the time of Open
+type memFile struct {
+	*bytes.Reader
+	info fs.FileInfo
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *memFile) Close() error               { return nil }
+
+// memWriter buffers writes and stores them on Close
+type memWriter struct {
+	fsys *Memory
+	name string
+	buf  bytes.Buffer
+}
+
+func (w *memWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *memWriter) Close() error {
+	w.fsys.mu.Lock()
+	defer w.fsys.mu.Unlock()
+	// Like on disk, writes to a file that was removed meanwhile are lost
+	if node, ok := w.fsys.nodes[w.name]; ok && !node.mode.IsDir() {
+		node.data = w.buf.Bytes()
+		node.modTime = time.Now()
+	}
+	return nil
+}
+
+type memInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (i *memInfo) Name() string       { return i.name }
+func (i *memInfo) Size() int64        { return i.size }
+func (i *memInfo) Mode() fs.FileMode  { return i.mode }
+func (i *memInfo) ModTime() time.Time { return i.modTime }
+func (i *memInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i *memInfo) Sys() interface{}   { return nil }
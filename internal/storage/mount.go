@@ -0,0 +1,339 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"GoFiles/internal/config"
+)
+
+// MountFS joins several named trees into one: "photos/2024/a.jpg" is
+// "2024/a.jpg" on the photos mount. The top level only holds the mounts;
+// files and folders can't be created there.
+type MountFS struct {
+	mounts map[string]FS
+	names  []string // Sorted
+}
+
+func NewMountFS(mounts map[string]FS) *MountFS {
+	m := &MountFS{mounts: mounts}
+	for name := range mounts {
+		m.names = append(m.names, name)
+	}
+	sort.Strings(m.names)
+	return m
+}
+
+// Names lists the mounts in order
+func (m *MountFS) Names() []string {
+	return m.names
+}
+
+// resolve splits a name into its mount and the name inside it. A name that
+// is the mount itself resolves to ".".
+func (m *MountFS) resolve(op, name string) (FS, string, error) {
+	name, err := clean(op, name)
+	if err != nil {
+		return nil, "", err
+	}
+	if name == "." {
+		return nil, ".", nil
+	}
+	mount, inner, _ := strings.Cut(name, "/")
+	fsys, ok := m.mounts[mount]
+	if !ok {
+		// Nothing exists at the top level besides the mounts
+		return nil, name, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	if inner == "" {
+		inner = "."
+	}
+	return fsys, inner, nil
+}
+
+// writable resolves a name that is about to be created or removed, refusing
+// the top level and the mounts themselves
+func (m *MountFS) writable(op, name string) (FS, string, error) {
+	fsys, inner, err := m.resolve(op, name)
+	if fsys != nil && inner != "." {
+		return fsys, inner, nil
+	}
+	if err != nil && (inner == "" || strings.Contains(inner, "/")) {
+		return nil, "", err // Invalid, or below a mount that doesn't exist
+	}
+	// The top level, a mount, or a new entry next to the mounts
+	return nil, "", &fs.PathError{Op: op, Path: name, Err: fs.ErrPermission}
+}
+
+func (m *MountFS) Stat(name string) (fs.FileInfo, error) {
+	fsys, inner, err := m.resolve("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	if fsys == nil {
+		return dirInfo(".", time.Time{}), nil
+	}
+	info, err := fsys.Stat(inner)
+	if err != nil || inner != "." {
+		return info, err
+	}
+	// The root of a mount goes by the mount's name
+	return dirInfo(MountOf(m, name), info.ModTime()), nil
+}
+
+func (m *MountFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	fsys, inner, err := m.resolve("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if fsys != nil {
+		return fsys.ReadDir(inner)
+	}
+
+	entries := make([]fs.DirEntry, 0, len(m.names))
+	for _, mount := range m.names {
+		var modTime time.Time
+		if info, err := m.mounts[mount].Stat("."); err == nil {
+			modTime = info.ModTime()
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(dirInfo(mount, modTime)))
+	}
+	return entries, nil
+}
+
+func (m *MountFS) Open(name string) (File, error) {
+	fsys, inner, err := m.resolve("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if fsys == nil {
+		return &memFile{Reader: bytes.NewReader(nil), info: dirInfo(".", time.Time{})}, nil
+	}
+	return fsys.Open(inner)
+}
+
+func (m *MountFS) Create(name string) (io.WriteCloser, error) {
+	fsys, inner, err := m.writable("create", name)
+	if err != nil {
+		return nil, err
+	}
+	return fsys.Create(inner)
+}
+
+func (m *MountFS) CreateAtomic(name string) (PendingFile, error) {
+	fsys, inner, err := m.writable("create", name)
+	if err != nil {
+		return nil, err
+	}
+	return CreateAtomic(fsys, inner)
+}
+
+func (m *MountFS) Mkdir(name string, perm os.FileMode) error {
+	if _, inner, err := m.resolve("mkdir", name); err == nil && inner == "." {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist} // The top level or a mount
+	}
+	fsys, inner, err := m.writable("mkdir", name)
+	if err != nil {
+		return err
+	}
+	return fsys.Mkdir(inner, perm)
+}
+
+func (m *MountFS) MkdirAll(name string, perm os.FileMode) error {
+	fsys, inner, err := m.resolve("mkdir", name)
+	if fsys == nil && err == nil {
+		return nil // The top level
+	}
+	if fsys == nil {
+		_, _, err = m.writable("mkdir", name)
+		return err
+	}
+	return fsys.MkdirAll(inner, perm)
+}
+
+// Rename moves within a mount as usual. Across mounts the data is copied
+// and the source removed afterwards, so it is only lost if the copy worked.
+func (m *MountFS) Rename(oldName, newName string) error {
+	oldFS, oldInner, err := m.writable("rename", oldName)
+	if err != nil {
+		return err
+	}
+	newFS, newInner, err := m.writable("rename", newName)
+	if err != nil {
+		return err
+	}
+	if oldFS == newFS {
+		return oldFS.Rename(oldInner, newInner)
+	}
+
+	info, err := oldFS.Stat(oldInner)
+	if err != nil {
+		return err
+	}
+	// Like a rename, a file may replace a file but folders are never merged
+	target, err := newFS.Stat(newInner)
+	replacing := err == nil
+	if replacing && (target.IsDir() || info.IsDir()) {
+		return &fs.PathError{Op: "rename", Path: newName, Err: fs.ErrExist}
+	}
+	if err := copyTree(oldFS, oldInner, newFS, newInner); err != nil {
+		// Don't leave half a copy behind. A file being replaced is left as
+		// it was: copyTree only swaps it once the copy is complete.
+		if !replacing {
+			newFS.RemoveAll(newInner)
+		}
+		return err
+	}
+	return oldFS.RemoveAll(oldInner)
+}
+
+// Copy copies server-side when both names are on the same mount and its
+// backend supports it
+func (m *MountFS) Copy(srcName, dstName string) error {
+	srcFS, srcInner, err := m.resolve("copy", srcName)
+	if err != nil {
+		return err
+	}
+	dstFS, dstInner, err := m.writable("copy", dstName)
+	if err != nil {
+		return err
+	}
+	copier, ok := srcFS.(Copier)
+	if srcFS != dstFS || !ok {
+		return errors.ErrUnsupported
+	}
+	return copier.Copy(srcInner, dstInner)
+}
+
+// FreeSpace reports the free space of the mount name is on
+func (m *MountFS) FreeSpace(name string) (int64, error) {
+	fsys, inner, err := m.resolve("statfs", name)
+	if fsys == nil {
+		if err == nil {
+			err = errors.ErrUnsupported // The top level spans every mount
+		}
+		return 0, err
+	}
+	free, ok := FreeSpace(fsys, inner)
+	if !ok {
+		return 0, errors.ErrUnsupported
+	}
+	return free, nil
+}
+
+func (m *MountFS) Remove(name string) error {
+	fsys, inner, err := m.writable("remove", name)
+	if err != nil {
+		return err
+	}
+	return fsys.Remove(inner)
+}
+
+func (m *MountFS) RemoveAll(name string) error {
+	fsys, inner, err := m.writable("remove", name)
+	if err != nil {
+		return err
+	}
+	return fsys.RemoveAll(inner)
+}
+
+// MountOf returns the folder that holds per-tree data (trash, thumbnails)
+// for name: the mount it is on, or "." when fsys has no mounts
+func MountOf(fsys FS, name string) string {
+	if _, ok := fsys.(*MountFS); !ok {
+		return "."
+	}
+	name, _ = Clean(name)
+	mount, _, _ := strings.Cut(name, "/")
+	return mount
+}
+
+// MountRoots lists the folders of fsys that hold per-tree data: every mount,
+// or just "." when fsys has no mounts
+func MountRoots(fsys FS) []string {
+	if m, ok := fsys.(*MountFS); ok {
+		return m.Names()
+	}
+	return []string{"."}
+}
+
+// IsInternal reports whether name is inside the trash, thumbnail or uploads
+// folder of its mount or of an account's home, is the temporary file of a
+// write in progress or one of the server's own files. Those are managed by
+// GoFiles and hidden from every API.
+func IsInternal(fsys FS, name string) bool {
+	return isInternalFolder(Rel(MountOf(fsys, name), name)) || inHomeInternal(fsys, name) ||
+		isTempName(path.Base(name)) || isStateFile(fsys, name)
+}
+
+// isInternalFolder reports whether rel (relative to a mount or home) is
+// inside its trash, thumbnail or uploads folder
+func isInternalFolder(rel string) bool {
+	first, _, _ := strings.Cut(rel, "/")
+	return first == config.TrashFolder || first == config.ThumbsFolder || first == config.UploadsFolder
+}
+
+// inHomeInternal reports whether name is inside the internal folders of an
+// account's home. Its owner sees them at the root of their tree, everyone
+// else (e.g. admins) further down.
+func inHomeInternal(fsys FS, name string) bool {
+	p, ok := rootPath(fsys, name)
+	if !ok {
+		return false
+	}
+	for _, home := range config.Homes() {
+		if rel, ok := strings.CutPrefix(p, home+"/"); ok && isInternalFolder(rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// rootPath returns name as a path in Root, false if fsys is neither Root
+// nor a folder of it
+func rootPath(fsys FS, name string) (string, bool) {
+	name, ok := Clean(name)
+	if !ok {
+		return "", false
+	}
+	if s, ok := fsys.(*subFS); ok {
+		return rootPath(s.parent, path.Join(s.dir, name))
+	}
+	return name, fsys == Root
+}
+
+// copyTree streams a file or folder from one tree to another. Each file
+// replaces an existing one only once it's complete.
+func copyTree(src FS, srcName string, dst FS, dstName string) error {
+	return WalkDir(src, srcName, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		target := path.Join(dstName, Rel(srcName, p))
+		if d.IsDir() {
+			return dst.MkdirAll(target, 0755)
+		}
+
+		in, err := src.Open(p)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		out, err := CreateAtomic(dst, target)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, in); err != nil {
+			out.Abort()
+			return err
+		}
+		return out.Commit()
+	})
+}
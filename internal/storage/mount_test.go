@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+
+	"GoFiles/internal/config"
+	"GoFiles/internal/types"
+)
+
+var errBrokenRead = errors.New("broken read")
+
+// brokenFS fails every read of file contents
+type brokenFS struct {
+	FS
+}
+
+func (b brokenFS) Open(name string) (File, error) {
+	file, err := b.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return brokenFile{file}, nil
+}
+
+type brokenFile struct {
+	File
+}
+
+func (brokenFile) Read(p []byte) (int, error) {
+	return 0, errBrokenRead
+}
+
+func TestMountRenameFailureKeepsDestination(t *testing.T) {
+	src, dst := NewMemory(), NewMemory()
+	WriteFile(src, "new.txt", []byte("new"))
+	src.MkdirAll("dir", 0755)
+	WriteFile(src, "dir/a.txt", []byte("a"))
+	WriteFile(dst, "old.txt", []byte("old"))
+	m := NewMountFS(map[string]FS{"src": brokenFS{src}, "dst": dst})
+
+	if err := m.Rename("src/new.txt", "dst/old.txt"); !errors.Is(err, errBrokenRead) {
+		t.Fatalf("Rename = %v, want %v", err, errBrokenRead)
+	}
+	if data, err := ReadFile(dst, "old.txt"); err != nil || string(data) != "old" {
+		t.Errorf("replaced file = %q, %v; want it unchanged", data, err)
+	}
+	if data, err := ReadFile(src, "new.txt"); err != nil || string(data) != "new" {
+		t.Errorf("source = %q, %v; want it unchanged", data, err)
+	}
+
+	// A new folder is removed again, as half a copy is of no use
+	if err := m.Rename("src/dir", "dst/dir"); !errors.Is(err, errBrokenRead) {
+		t.Fatalf("Rename = %v, want %v", err, errBrokenRead)
+	}
+	if _, err := dst.Stat("dir"); err == nil {
+		t.Error("half copied folder was left behind")
+	}
+	if entries, _ := dst.ReadDir("."); len(entries) != 1 {
+		t.Errorf("destination holds %d entries, want only old.txt", len(entries))
+	}
+}
+
+func TestMountResolve(t *testing.T) {
+	photos, docs := NewMemory(), NewMemory()
+	m := NewMountFS(map[string]FS{"photos": photos, "docs": docs})
+
+	tests := []struct {
+		name  string
+		fsys  FS
+		inner string
+		err   error
+	}{
+		{"", nil, ".", nil},
+		{"/", nil, ".", nil},
+		{"photos", photos, ".", nil},
+		{"/photos/", photos, ".", nil},
+		{"photos/2024/a.jpg", photos, "2024/a.jpg", nil},
+		{"/docs/../photos/a.jpg", photos, "a.jpg", nil},
+		{"docs/a/../b.txt", docs, "b.txt", nil},
+		{"music", nil, "music", fs.ErrNotExist},
+		{"music/a.mp3", nil, "music/a.mp3", fs.ErrNotExist},
+		{"photosx/a.jpg", nil, "photosx/a.jpg", fs.ErrNotExist},
+		{"../photos", nil, "", fs.ErrInvalid},
+		{"photos/../../etc", nil, "", fs.ErrInvalid},
+	}
+	for _, tt := range tests {
+		fsys, inner, err := m.resolve("stat", tt.name)
+		if fsys != tt.fsys || inner != tt.inner || !errors.Is(err, tt.err) {
+			t.Errorf("resolve(%q) = %v, %q, %v; want %v, %q, %v", tt.name, fsys, inner, err, tt.fsys, tt.inner, tt.err)
+		}
+	}
+}
+
+func TestMountWritable(t *testing.T) {
+	photos := NewMemory()
+	m := NewMountFS(map[string]FS{"photos": photos})
+
+	tests := []struct {
+		name  string
+		inner string
+		err   error
+	}{
+		{"photos/a.jpg", "a.jpg", nil},
+		{"photos/2024/a.jpg", "2024/a.jpg", nil},
+		{"/", "", fs.ErrPermission},
+		{"photos", "", fs.ErrPermission},
+		{"new-folder", "", fs.ErrPermission},
+		{"music/a.mp3", "", fs.ErrNotExist},
+		{"../a", "", fs.ErrInvalid},
+	}
+	for _, tt := range tests {
+		_, inner, err := m.writable("create", tt.name)
+		if inner != tt.inner || !errors.Is(err, tt.err) {
+			t.Errorf("writable(%q) = %q, %v; want %q, %v", tt.name, inner, err, tt.inner, tt.err)
+		}
+	}
+}
+
+func TestIsInternal(t *testing.T) {
+	t.Chdir(t.TempDir()) // UpdateConfig writes the config file
+	saved := Root
+	Root = NewMemory()
+	defer func() { Root = saved }()
+	config.UpdateConfig(func(cfg *types.ConfigFile) error {
+		cfg.Users = []types.User{{Username: "alice", Home: "homes/alice"}, {Username: "bob"}}
+		return nil
+	})
+	defer config.UpdateConfig(func(cfg *types.ConfigFile) error {
+		cfg.Users = nil
+		return nil
+	})
+	mounts := NewMountFS(map[string]FS{"media": NewMemory()})
+
+	tests := []struct {
+		name string
+		fsys FS
+		path string
+		want bool
+	}{
+		{"root trash", Root, ".trash/a.txt", true},
+		{"root uploads", Root, ".uploads", true},
+		{"trash of a mount", mounts, "media/.thumbs/a.jpg", true},
+		{"mount folder name elsewhere", mounts, "media/docs/.trash", false},
+		{"home trash from the root", Root, "homes/alice/.trash/a.txt", true},
+		{"home uploads from the root", Root, "homes/alice/.uploads", true},
+		{"home thumbs from a parent", Sub(Root, "homes"), "alice/.thumbs/a.jpg", true},
+		{"home trash from the home", Sub(Root, "homes/alice"), ".trash", true},
+		{"deeper in a home", Root, "homes/alice/docs/.trash", false},
+		{"not a home", Root, "homes/bob/.trash", false},
+		{"home itself", Root, "homes/alice", false},
+		{"temporary file", Root, "docs/.gofiles-123.tmp", true},
+		{"plain file", Root, "docs/a.txt", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsInternal(tt.fsys, tt.path); got != tt.want {
+				t.Errorf("IsInternal(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
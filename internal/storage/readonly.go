@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+)
+
+// ErrReadOnly is returned by every write to a read-only tree
+var ErrReadOnly = errors.New("read-only file system")
+
+// readOnlyFS passes reads through and refuses everything else
+type readOnlyFS struct {
+	FS
+}
+
+// ReadOnly wraps fsys so that nothing in it can be changed
+func ReadOnly(fsys FS) FS {
+	return readOnlyFS{fsys}
+}
+
+// IsReadOnly reports whether name can't be changed in fsys. The top level of
+// a MountFS is read-only when all of its mounts are.
+func IsReadOnly(fsys FS, name string) bool {
+	switch f := fsys.(type) {
+	case readOnlyFS:
+		return true
+	case *subFS:
+		full, err := f.full("stat", name)
+		return err == nil && IsReadOnly(f.parent, full)
+	case *MountFS:
+		mount, inner, err := f.resolve("stat", name)
+		if mount != nil {
+			return IsReadOnly(mount, inner)
+		}
+		if err != nil {
+			return false
+		}
+		for _, name := range f.names {
+			if !IsReadOnly(f.mounts[name], ".") {
+				return false
+			}
+		}
+		return len(f.names) > 0
+	}
+	return false
+}
+
+func (r readOnlyFS) Create(name string) (io.WriteCloser, error) {
+	return nil, &fs.PathError{Op: "create", Path: name, Err: ErrReadOnly}
+}
+
+func (r readOnlyFS) Mkdir(name string, perm os.FileMode) error {
+	return &fs.PathError{Op: "mkdir", Path: name, Err: ErrReadOnly}
+}
+
+// MkdirAll succeeds for folders that already exist, like it does elsewhere
+func (r readOnlyFS) MkdirAll(name string, perm os.FileMode) error {
+	if info, err := r.FS.Stat(name); err == nil && info.IsDir() {
+		return nil
+	}
+	return &fs.PathError{Op: "mkdir", Path: name, Err: ErrReadOnly}
+}
+
+func (r readOnlyFS) Rename(oldName, newName string) error {
+	return &fs.PathError{Op: "rename", Path: oldName, Err: ErrReadOnly}
+}
+
+func (r readOnlyFS) Remove(name string) error {
+	return &fs.PathError{Op: "remove", Path: name, Err: ErrReadOnly}
+}
+
+func (r readOnlyFS) RemoveAll(name string) error {
+	return &fs.PathError{Op: "remove", Path: name, Err: ErrReadOnly}
+}
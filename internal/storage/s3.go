@@ -0,0 +1,524 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// s3PartSize is the chunk size for multipart uploads. Uploads are buffered
+// one part at a time, so this bounds memory per upload; smaller files go up
+// in a single request.
+const s3PartSize = 16 << 20
+
+// s3MaxCopySize is the largest object S3 copies in one request
+const s3MaxCopySize = 5 << 30
+
+// errAborted fails a streaming upload that was abandoned
+var errAborted = errors.New("upload aborted")
+
+// S3 stores files as objects in an S3-compatible bucket (AWS, MinIO, ...).
+// Folders are key prefixes; an empty "name/" object marks a folder that
+// has no files yet, the same convention the AWS and MinIO consoles use.
+type S3 struct {
+	client *minio.Client
+	bucket string
+	prefix string // Optional key prefix ("" or "gofiles/")
+}
+
+// S3Options configures NewS3
+type S3Options struct {
+	Endpoint  string // e.g. "https://s3.eu-west-1.amazonaws.com" or "http://localhost:9000"
+	Bucket    string
+	Prefix    string // Keep all files below this key prefix
+	Region    string
+	AccessKey string
+	SecretKey string
+}
+
+// NewS3 connects to the bucket, failing early if it is unreachable or missing
+func NewS3(opts S3Options) (*S3, error) {
+	endpoint, err := url.Parse(opts.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", opts.Endpoint)
+	}
+	client, err := minio.New(endpoint.Host, &minio.Options{
+		Creds:  credentials.NewStaticV4(opts.AccessKey, opts.SecretKey, ""),
+		Secure: endpoint.Scheme == "https",
+		Region: opts.Region,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	exists, err := client.BucketExists(ctx, opts.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("S3 bucket %q: %w", opts.Bucket, err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("S3 bucket %q does not exist", opts.Bucket)
+	}
+
+	prefix := strings.Trim(opts.Prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return &S3{client: client, bucket: opts.Bucket, prefix: prefix}, nil
+}
+
+// key maps a cleaned name to its object key
+func (s *S3) key(name string) string {
+	if name == "." {
+		return strings.TrimSuffix(s.prefix, "/")
+	}
+	return s.prefix + name
+}
+
+// dirPrefix is the key prefix of everything inside a folder
+func (s *S3) dirPrefix(name string) string {
+	if name == "." {
+		return s.prefix
+	}
+	return s.prefix + name + "/"
+}
+
+func (s *S3) Stat(name string) (fs.FileInfo, error) {
+	name, err := clean("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return s.stat("stat", name)
+}
+
+func (s *S3) stat(op, name string) (fs.FileInfo, error) {
+	ctx := context.Background()
+	if name != "." {
+		obj, err := s.client.StatObject(ctx, s.bucket, s.key(name), minio.StatObjectOptions{})
+		if err == nil {
+			return fileInfo(name, obj), nil
+		}
+		if !isNotFound(err) {
+			return nil, &fs.PathError{Op: op, Path: name, Err: err}
+		}
+	}
+	isDir, err := s.isDir(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	if !isDir {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return dirInfo(name, time.Time{}), nil
+}
+
+// isDir reports whether anything (a marker or a file) lives under name/
+func (s *S3) isDir(name string) (bool, error) {
+	if name == "." {
+		return true, nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for obj := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: s.dirPrefix(name), MaxKeys: 1}) {
+		if obj.Err != nil {
+			return false, obj.Err
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+func (s *S3) ReadDir(name string) ([]fs.DirEntry, error) {
+	name, err := clean("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	prefix := s.dirPrefix(name)
+
+	var entries []fs.DirEntry
+	seen := map[string]bool{} // Some servers list a folder's marker next to its prefix
+	found := name == "."
+	for obj := range s.client.ListObjects(context.Background(), s.bucket, minio.ListObjectsOptions{Prefix: prefix}) {
+		if obj.Err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: name, Err: obj.Err}
+		}
+		found = true
+		child := strings.TrimPrefix(obj.Key, prefix)
+		switch {
+		case child == "" || seen[strings.TrimSuffix(child, "/")]:
+			// The folder's own marker, or a folder already listed
+		case strings.HasSuffix(child, "/"):
+			seen[strings.TrimSuffix(child, "/")] = true
+			entries = append(entries, fs.FileInfoToDirEntry(dirInfo(strings.TrimSuffix(child, "/"), obj.LastModified)))
+		default:
+			entries = append(entries, fs.FileInfoToDirEntry(fileInfo(child, obj)))
+		}
+	}
+	if !found {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	// Keys are listed in order, so entries are already sorted by name
+	return entries, nil
+}
+
+func (s *S3) Open(name string) (File, error) {
+	name, err := clean("open", name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := s.stat("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return &memFile{Reader: bytes.NewReader(nil), info: info}, nil
+	}
+	// Reads are fetched lazily; Seek and ReadAt turn into ranged GETs
+	obj, err := s.client.GetObject(context.Background(), s.bucket, s.key(name), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &s3File{Object: obj, info: info}, nil
+}
+
+func (s *S3) Create(name string) (io.WriteCloser, error) {
+	name, err := clean("create", name)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkParent("create", name); err != nil {
+		return nil, err
+	}
+	if isDir, _ := s.isDir(name); isDir {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrExist}
+	}
+
+	return &s3Writer{fsys: s, key: s.key(name), contentType: contentType(name)}, nil
+}
+
+// CreateAtomic needs no temporary object: S3 only shows an upload once it
+// has completed
+func (s *S3) CreateAtomic(name string) (PendingFile, error) {
+	w, err := s.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return w.(*s3Writer), nil
+}
+
+func (s *S3) Mkdir(name string, perm os.FileMode) error {
+	name, err := clean("mkdir", name)
+	if err != nil {
+		return err
+	}
+	if _, err := s.stat("mkdir", name); err == nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	}
+	if err := s.checkParent("mkdir", name); err != nil {
+		return err
+	}
+	return s.putMarker(name)
+}
+
+func (s *S3) MkdirAll(name string, perm os.FileMode) error {
+	name, err := clean("mkdir", name)
+	if err != nil {
+		return err
+	}
+	info, err := s.stat("mkdir", name)
+	if err == nil {
+		if !info.IsDir() {
+			return &fs.PathError{Op: "mkdir", Path: name, Err: ErrNotDir}
+		}
+		return nil
+	}
+	// Parents exist implicitly as prefixes of the marker
+	return s.putMarker(name)
+}
+
+func (s *S3) putMarker(name string) error {
+	_, err := s.client.PutObject(context.Background(), s.bucket, s.dirPrefix(name), bytes.NewReader(nil), 0, minio.PutObjectOptions{})
+	if err != nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	return nil
+}
+
+// Rename copies server-side and then deletes the source, object by object for folders
+func (s *S3) Rename(oldName, newName string) error {
+	oldName, err := clean("rename", oldName)
+	if err != nil {
+		return err
+	}
+	newName, err = clean("rename", newName)
+	if err != nil {
+		return err
+	}
+	if oldName == "." || strings.HasPrefix(newName, oldName+"/") {
+		return &fs.PathError{Op: "rename", Path: newName, Err: fs.ErrInvalid}
+	}
+	if oldName == newName {
+		return nil
+	}
+	info, err := s.stat("rename", oldName)
+	if err != nil {
+		return err
+	}
+	if err := s.checkParent("rename", newName); err != nil {
+		return err
+	}
+	if err := s.Copy(oldName, newName); err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return s.RemoveAll(oldName)
+	}
+	return s.Remove(oldName)
+}
+
+// Copy duplicates a file or folder without downloading it (server-side copy)
+func (s *S3) Copy(srcName, dstName string) error {
+	srcName, err := clean("copy", srcName)
+	if err != nil {
+		return err
+	}
+	dstName, err = clean("copy", dstName)
+	if err != nil {
+		return err
+	}
+	info, err := s.stat("copy", srcName)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return s.copyObject(s.key(srcName), s.key(dstName), info.Size())
+	}
+
+	if err := s.putMarker(dstName); err != nil {
+		return err
+	}
+	srcPrefix, dstPrefix := s.dirPrefix(srcName), s.dirPrefix(dstName)
+	for obj := range s.client.ListObjects(context.Background(), s.bucket, minio.ListObjectsOptions{Prefix: srcPrefix, Recursive: true}) {
+		if obj.Err != nil {
+			return &fs.PathError{Op: "copy", Path: srcName, Err: obj.Err}
+		}
+		if obj.Key == srcPrefix {
+			continue
+		}
+		if err := s.copyObject(obj.Key, dstPrefix+strings.TrimPrefix(obj.Key, srcPrefix), obj.Size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyObject copies one object, in parts if it is over the single-copy limit
+func (s *S3) copyObject(srcKey, dstKey string, size int64) error {
+	dst := minio.CopyDestOptions{Bucket: s.bucket, Object: dstKey}
+	src := minio.CopySrcOptions{Bucket: s.bucket, Object: srcKey}
+	var err error
+	if size > s3MaxCopySize {
+		_, err = s.client.ComposeObject(context.Background(), dst, src)
+	} else {
+		_, err = s.client.CopyObject(context.Background(), dst, src)
+	}
+	if err != nil {
+		return &fs.PathError{Op: "copy", Path: srcKey, Err: err}
+	}
+	return nil
+}
+
+func (s *S3) Remove(name string) error {
+	name, err := clean("remove", name)
+	if err != nil {
+		return err
+	}
+	if name == "." {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
+	}
+	info, err := s.stat("remove", name)
+	if err != nil {
+		return err
+	}
+	key := s.key(name)
+	if info.IsDir() {
+		entries, err := s.ReadDir(name)
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrExist}
+		}
+		key = s.dirPrefix(name)
+	}
+	if err := s.client.RemoveObject(context.Background(), s.bucket, key, minio.RemoveObjectOptions{}); err != nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: err}
+	}
+	return nil
+}
+
+func (s *S3) RemoveAll(name string) error {
+	name, err := clean("remove", name)
+	if err != nil {
+		return err
+	}
+	if name == "." {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The object itself (if it is a file) plus everything under name/
+	keys := make(chan minio.ObjectInfo)
+	go func() {
+		defer close(keys)
+		keys <- minio.ObjectInfo{Key: s.key(name)}
+		for obj := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: s.dirPrefix(name), Recursive: true}) {
+			if obj.Err != nil {
+				return
+			}
+			keys <- obj
+		}
+	}()
+	for rerr := range s.client.RemoveObjects(ctx, s.bucket, keys, minio.RemoveObjectsOptions{}) {
+		if !isNotFound(rerr.Err) {
+			return &fs.PathError{Op: "remove", Path: rerr.ObjectName, Err: rerr.Err}
+		}
+	}
+	return nil
+}
+
+// checkParent makes sure the folder name would be created in exists
+func (s *S3) checkParent(op, name string) error {
+	parent := path.Dir(name)
+	if parent == "." {
+		return nil
+	}
+	info, err := s.stat(op, parent)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return &fs.PathError{Op: op, Path: parent, Err: ErrNotDir}
+	}
+	return nil
+}
+
+// s3File is an open object; its size and time come from the Stat done in Open
+type s3File struct {
+	*minio.Object
+	info fs.FileInfo
+}
+
+func (f *s3File) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+// s3Writer buffers the first part of an upload. Files that fit are sent in
+// one PUT on Close; larger ones are streamed as a multipart upload in the
+// background, and Close waits for it to finish.
+type s3Writer struct {
+	fsys        *S3
+	key         string
+	contentType string
+	buf         bytes.Buffer
+	pipe        *io.PipeWriter // Set once streaming has started
+	done        chan error
+	closed      bool
+	err         error
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	if w.pipe == nil {
+		if w.buf.Len()+len(p) <= s3PartSize {
+			return w.buf.Write(p)
+		}
+		w.stream()
+	}
+	return w.pipe.Write(p)
+}
+
+// stream starts a multipart upload and hands it what was buffered so far
+func (w *s3Writer) stream() {
+	pr, pw := io.Pipe()
+	w.pipe = pw
+	w.done = make(chan error, 1)
+	buffered := bytes.NewReader(w.buf.Bytes())
+	go func() {
+		_, err := w.fsys.client.PutObject(context.Background(), w.fsys.bucket, w.key, io.MultiReader(buffered, pr), -1, minio.PutObjectOptions{
+			ContentType: w.contentType,
+			PartSize:    s3PartSize,
+		})
+		pr.CloseWithError(err) // Unblocks the writer if the upload failed
+		w.done <- err
+	}()
+}
+
+func (w *s3Writer) Close() error {
+	if w.closed {
+		return w.err
+	}
+	w.closed = true
+	if w.pipe == nil {
+		_, w.err = w.fsys.client.PutObject(context.Background(), w.fsys.bucket, w.key, bytes.NewReader(w.buf.Bytes()), int64(w.buf.Len()), minio.PutObjectOptions{
+			ContentType: w.contentType,
+		})
+	} else {
+		w.pipe.Close()
+		w.err = <-w.done
+	}
+	return w.err
+}
+
+// Commit completes the upload, which makes the object appear all at once
+func (w *s3Writer) Commit() error {
+	return w.Close()
+}
+
+// Abort never completes the upload, so the old object stays as it was
+func (w *s3Writer) Abort() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if w.pipe != nil {
+		w.pipe.CloseWithError(errAborted) // Fails the upload, which drops its parts
+		<-w.done
+	}
+	return nil
+}
+
+func fileInfo(name string, obj minio.ObjectInfo) fs.FileInfo {
+	return &memInfo{name: path.Base(name), size: obj.Size, mode: 0644, modTime: obj.LastModified}
+}
+
+func dirInfo(name string, modTime time.Time) fs.FileInfo {
+	return &memInfo{name: path.Base(name), mode: fs.ModeDir | 0755, modTime: modTime}
+}
+
+func contentType(name string) string {
+	if t := mime.TypeByExtension(path.Ext(name)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+func isNotFound(err error) bool {
+	var resp minio.ErrorResponse
+	if errors.As(err, &resp) {
+		return resp.StatusCode == http.StatusNotFound || resp.Code == "NoSuchKey"
+	}
+	return false
+}
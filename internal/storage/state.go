@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"GoFiles/internal/config"
+)
+
+// The server's own files (users, sessions, keys, the security log) may sit
+// inside a folder it serves, e.g. with the default root ".". They are
+// recognized by their absolute path on disk and never served as files.
+
+// stateFiles holds the resolved paths of the server's own files, set by Init
+var stateFiles = map[string]bool{}
+
+// protectStateFiles resolves the paths of the configured state files
+func protectStateFiles() {
+	stateFiles = map[string]bool{}
+	for _, name := range config.StateFiles() {
+		if name != "" {
+			stateFiles[resolvePath(name)] = true
+		}
+	}
+}
+
+// resolvePath makes p absolute and resolves symlinks, so two spellings of
+// one file compare equal. Files that don't exist yet resolve their folder.
+func resolvePath(p string) string {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return filepath.Clean(p)
+	}
+	if real, err := filepath.EvalSymlinks(abs); err == nil {
+		return real
+	}
+	if real, err := filepath.EvalSymlinks(filepath.Dir(abs)); err == nil {
+		return filepath.Join(real, filepath.Base(abs))
+	}
+	return abs
+}
+
+// diskPath returns where name is stored on disk, false for backends that
+// don't store on a local disk
+func diskPath(fsys FS, name string) (string, bool) {
+	switch f := fsys.(type) {
+	case *Local:
+		name, err := clean("stat", name)
+		return filepath.Join(f.disk, filepath.FromSlash(name)), err == nil
+	case readOnlyFS:
+		return diskPath(f.FS, name)
+	case *subFS:
+		full, err := f.full("stat", name)
+		if err != nil {
+			return "", false
+		}
+		return diskPath(f.parent, full)
+	case *MountFS:
+		mount, inner, err := f.resolve("stat", name)
+		if mount == nil || err != nil {
+			return "", false
+		}
+		return diskPath(mount, inner)
+	}
+	return "", false
+}
+
+// isStateFile reports whether name is one of the server's own files
+func isStateFile(fsys FS, name string) bool {
+	p, ok := diskPath(fsys, name)
+	return ok && stateFiles[p]
+}
+
+// HoldsStateFile reports whether the folder name contains one of the
+// server's own files, so that removing or moving it would take them along.
+// The root never counts: it can't be removed anyway.
+func HoldsStateFile(fsys FS, name string) bool {
+	if name, ok := Clean(name); !ok || name == "." {
+		return false
+	}
+	dir, ok := diskPath(fsys, name)
+	if !ok {
+		return false
+	}
+	for p := range stateFiles {
+		if strings.HasPrefix(p, dir+string(os.PathSeparator)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"GoFiles/internal/config"
+)
+
+func TestStateFilesAreInternal(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "etc"), 0755)
+	os.MkdirAll(filepath.Join(root, "docs"), 0755)
+	os.WriteFile(filepath.Join(root, "docs", "a.txt"), nil, 0644)
+
+	saved := config.ConfigFileName
+	config.ConfigFileName = filepath.Join(root, "etc", "gofiles.json")
+	defer func() { config.ConfigFileName = saved }()
+	protectStateFiles()
+	defer func() { stateFiles = map[string]bool{} }()
+
+	local := NewLocal(root)
+	mounts := NewMountFS(map[string]FS{"data": local, "mem": NewMemory()})
+	tests := []struct {
+		name     string
+		fsys     FS
+		path     string
+		internal bool
+		holds    bool
+	}{
+		{"local", local, "etc/gofiles.json", true, false},
+		{"local unclean", local, "/docs/../etc/./gofiles.json", true, false},
+		{"local folder", local, "etc", false, true},
+		{"local other", local, "docs/a.txt", false, false},
+		{"local root", local, ".", false, false},
+		{"read-only", ReadOnly(local), "etc/gofiles.json", true, false},
+		{"home", Sub(local, "etc"), "gofiles.json", true, false},
+		{"home root", Sub(local, "etc"), "/", false, false},
+		{"mount", mounts, "data/etc/gofiles.json", true, false},
+		{"mount folder", mounts, "data/etc", false, true},
+		{"mount itself", mounts, "data", false, true},
+		{"memory", mounts, "mem/etc/gofiles.json", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsInternal(tt.fsys, tt.path); got != tt.internal {
+				t.Errorf("IsInternal(%q) = %v, want %v", tt.path, got, tt.internal)
+			}
+			if got := HoldsStateFile(tt.fsys, tt.path); got != tt.holds {
+				t.Errorf("HoldsStateFile(%q) = %v, want %v", tt.path, got, tt.holds)
+			}
+		})
+	}
+}
+
+func TestStateFilesThroughSymlink(t *testing.T) {
+	root := t.TempDir()
+	link := filepath.Join(t.TempDir(), "served")
+	if err := os.Symlink(root, link); err != nil {
+		t.Skip("symlinks not supported:", err)
+	}
+
+	saved := config.SessionsFileName
+	config.SessionsFileName = filepath.Join(root, "sessions.json")
+	defer func() { config.SessionsFileName = saved }()
+	protectStateFiles()
+	defer func() { stateFiles = map[string]bool{} }()
+
+	if !IsInternal(NewLocal(link), "sessions.json") {
+		t.Error("state file reached through a symlinked root is not internal")
+	}
+}
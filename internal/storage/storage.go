@@ -0,0 +1,342 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"GoFiles/internal/config"
+)
+
+// FS is a tree of files the server reads and writes. Names are slash
+// separated and relative to the root of the FS ("docs/a.txt"); "", "/" and
+// "." all mean the root. Names that climb out of the root are rejected.
+type FS interface {
+	Stat(name string) (fs.FileInfo, error)
+	ReadDir(name string) ([]fs.DirEntry, error)
+	Open(name string) (File, error)             // For reading
+	Create(name string) (io.WriteCloser, error) // Creates or truncates; contents are complete after Close
+	Mkdir(name string, perm os.FileMode) error
+	MkdirAll(name string, perm os.FileMode) error
+	Rename(oldName, newName string) error
+	Remove(name string) error    // A file or an empty folder
+	RemoveAll(name string) error // Everything under name; missing is not an error
+}
+
+// File is an open file. ReadAt and Seek allow ranged downloads and zip reading.
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+	io.Closer
+	Stat() (fs.FileInfo, error)
+}
+
+// Copier is implemented by backends that can copy without streaming the
+// data through the server (e.g. S3 server-side copy). Copy returns
+// errors.ErrUnsupported when the caller should fall back to Open/Create.
+type Copier interface {
+	Copy(srcName, dstName string) error
+}
+
+// SpaceReporter is implemented by backends that write to a disk that can
+// fill up. FreeSpace returns the bytes available there.
+type SpaceReporter interface {
+	FreeSpace(name string) (int64, error)
+}
+
+// FreeSpace returns the space left where name is stored. False when the
+// backend has no such limit or can't tell (memory, S3).
+func FreeSpace(fsys FS, name string) (int64, bool) {
+	reporter, ok := fsys.(SpaceReporter)
+	if !ok {
+		return 0, false
+	}
+	free, err := reporter.FreeSpace(name)
+	return free, err == nil
+}
+
+// ErrNotDir is returned when a folder operation hits a file
+var ErrNotDir = errors.New("not a directory")
+
+// Root is the tree all files are served from, set by Init
+var Root FS
+
+// Init opens the configured storage backend, or the mounts if any are set
+func Init() error {
+	protectStateFiles()
+	mounts, err := config.ParseMounts()
+	if err != nil {
+		return err
+	}
+	if len(mounts) > 0 {
+		Root, err = openMounts(mounts)
+		return err
+	}
+	Root, err = open(config.StorageBackend, config.RootFolder, config.S3Bucket, config.S3Prefix)
+	if err == nil && config.ReadOnly {
+		Root = ReadOnly(Root)
+	}
+	return err
+}
+
+// openMounts combines the configured mounts into a MountFS
+func openMounts(mounts []config.Mount) (FS, error) {
+
+	trees := map[string]FS{}
+	for _, mount := range mounts {
+		bucket, prefix, _ := strings.Cut(mount.Path, "/")
+		fsys, err := open(mount.Backend, mount.Path, bucket, prefix)
+		if err != nil {
+			return nil, fmt.Errorf("mount %q: %w", mount.Name, err)
+		}
+		if mount.ReadOnly || config.ReadOnly {
+			fsys = ReadOnly(fsys)
+		}
+		trees[mount.Name] = fsys
+	}
+	return NewMountFS(trees), nil
+}
+
+// open creates one backend; dir is used by local, bucket and prefix by s3
+func open(backend, dir, bucket, prefix string) (FS, error) {
+	switch backend {
+	case "memory":
+		return NewMemory(), nil
+	case "s3":
+		s3, err := NewS3(S3Options{
+			Endpoint:  config.S3Endpoint,
+			Bucket:    bucket,
+			Prefix:    prefix,
+			Region:    config.S3Region,
+			AccessKey: config.S3AccessKey,
+			SecretKey: config.S3SecretKey,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return s3, nil
+	default:
+		return NewLocal(dir), nil
+	}
+}
+
+// Clean turns a request path into a name for FS methods ("/docs/../a" -> "a").
+// Returns false if the path climbs out of the root.
+func Clean(name string) (string, bool) {
+	name = path.Clean(strings.TrimLeft(filepath.ToSlash(name), "/"))
+	if name == ".." || strings.HasPrefix(name, "../") {
+		return "", false
+	}
+	return name, true
+}
+
+// clean is Clean for FS implementations, reporting escapes as errors
+func clean(op, name string) (string, error) {
+	cleaned, ok := Clean(name)
+	if !ok {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return cleaned, nil
+}
+
+// Sub returns an FS rooted at dir inside fsys (e.g. a user's home)
+func Sub(fsys FS, dir string) FS {
+	dir, ok := Clean(dir)
+	if !ok || dir == "." {
+		return fsys
+	}
+	return &subFS{parent: fsys, dir: dir}
+}
+
+// ETag identifies the current version of a file without reading it, by its
+// modification time and size. It isn't an MD5, so S3 clients don't try to
+// verify downloads against it.
+func ETag(info fs.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size())
+}
+
+// ReadFile reads a whole file
+func ReadFile(fsys FS, name string) ([]byte, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// WriteFile creates or replaces a file with data. The old content stays
+// until the new one is complete (see CreateAtomic).
+func WriteFile(fsys FS, name string, data []byte) error {
+	file, err := CreateAtomic(fsys, name)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Abort()
+		return err
+	}
+	return file.Commit()
+}
+
+// WalkDir walks the tree at root, calling fn for every file and folder.
+// It behaves exactly like fs.WalkDir, including fs.SkipDir and fs.SkipAll.
+func WalkDir(fsys FS, root string, fn fs.WalkDirFunc) error {
+	root, ok := Clean(root)
+	if !ok {
+		return &fs.PathError{Op: "walk", Path: root, Err: fs.ErrInvalid}
+	}
+	return fs.WalkDir(ioFS{fsys}, root, fn)
+}
+
+// Rel returns name relative to the folder base, both as returned by Clean
+func Rel(base, name string) string {
+	if base == "." {
+		return name
+	}
+	if name == base {
+		return "."
+	}
+	return strings.TrimPrefix(name, base+"/")
+}
+
+// ioFS adapts an FS to io/fs so the standard walker can be used
+type ioFS struct{ fsys FS }
+
+func (f ioFS) Open(name string) (fs.File, error) {
+	return f.fsys.Open(name)
+}
+
+func (f ioFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return f.fsys.ReadDir(name)
+}
+
+func (f ioFS) Stat(name string) (fs.FileInfo, error) {
+	return f.fsys.Stat(name)
+}
+
+// subFS prefixes every name with dir
+type subFS struct {
+	parent FS
+	dir    string
+}
+
+func (s *subFS) full(op, name string) (string, error) {
+	name, err := clean(op, name)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(s.dir, name), nil
+}
+
+func (s *subFS) Stat(name string) (fs.FileInfo, error) {
+	full, err := s.full("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return s.parent.Stat(full)
+}
+
+func (s *subFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	full, err := s.full("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	return s.parent.ReadDir(full)
+}
+
+func (s *subFS) Open(name string) (File, error) {
+	full, err := s.full("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return s.parent.Open(full)
+}
+
+func (s *subFS) Create(name string) (io.WriteCloser, error) {
+	full, err := s.full("create", name)
+	if err != nil {
+		return nil, err
+	}
+	return s.parent.Create(full)
+}
+
+func (s *subFS) CreateAtomic(name string) (PendingFile, error) {
+	full, err := s.full("create", name)
+	if err != nil {
+		return nil, err
+	}
+	return CreateAtomic(s.parent, full)
+}
+
+func (s *subFS) Mkdir(name string, perm os.FileMode) error {
+	full, err := s.full("mkdir", name)
+	if err != nil {
+		return err
+	}
+	return s.parent.Mkdir(full, perm)
+}
+
+func (s *subFS) MkdirAll(name string, perm os.FileMode) error {
+	full, err := s.full("mkdir", name)
+	if err != nil {
+		return err
+	}
+	return s.parent.MkdirAll(full, perm)
+}
+
+func (s *subFS) Rename(oldName, newName string) error {
+	oldFull, err := s.full("rename", oldName)
+	if err != nil {
+		return err
+	}
+	newFull, err := s.full("rename", newName)
+	if err != nil {
+		return err
+	}
+	return s.parent.Rename(oldFull, newFull)
+}
+
+func (s *subFS) Copy(srcName, dstName string) error {
+	copier, ok := s.parent.(Copier)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	srcFull, err := s.full("copy", srcName)
+	if err != nil {
+		return err
+	}
+	dstFull, err := s.full("copy", dstName)
+	if err != nil {
+		return err
+	}
+	return copier.Copy(srcFull, dstFull)
+}
+
+func (s *subFS) Remove(name string) error {
+	full, err := s.full("remove", name)
+	if err != nil {
+		return err
+	}
+	if full == s.dir {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
+	}
+	return s.parent.Remove(full)
+}
+
+func (s *subFS) RemoveAll(name string) error {
+	full, err := s.full("remove", name)
+	if err != nil {
+		return err
+	}
+	if full == s.dir {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
+	}
+	return s.parent.RemoveAll(full)
+}
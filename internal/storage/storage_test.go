@@ -0,0 +1,51 @@
+package storage
+
+import "testing"
+
+func TestClean(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{"", ".", true},
+		{"/", ".", true},
+		{".", ".", true},
+		{"/docs", "docs", true},
+		{"docs/", "docs", true},
+		{"//docs//a.txt", "docs/a.txt", true},
+		{"/docs/../a", "a", true},
+		{"/docs/./a", "docs/a", true},
+		{"/a/b/../../c", "c", true},
+		{"/..", "", false},
+		{"..", "", false},
+		{"../etc/passwd", "", false},
+		{"/docs/../../etc", "", false},
+		{"/..a", "..a", true},
+	}
+	for _, tt := range tests {
+		got, ok := Clean(tt.in)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("Clean(%q) = %q, %v; want %q, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestSubStaysInside(t *testing.T) {
+	fsys := NewMemory()
+	if err := fsys.MkdirAll("home/alice", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(fsys, "secret.txt", []byte("root")); err != nil {
+		t.Fatal(err)
+	}
+	sub := Sub(fsys, "/home/alice")
+	for _, name := range []string{"../../secret.txt", "/../secret.txt", ".."} {
+		if _, err := sub.Stat(name); err == nil {
+			t.Errorf("Stat(%q) inside the sub tree reached the parent", name)
+		}
+	}
+	if Sub(fsys, "/") != fsys || Sub(fsys, "../x") != fsys {
+		t.Error("Sub of the root or an escaping path should return fsys itself")
+	}
+}
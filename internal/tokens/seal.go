@@ -0,0 +1,163 @@
+package tokens
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"GoFiles/internal/config"
+	"GoFiles/internal/security"
+	"GoFiles/internal/types"
+)
+
+// S3 clients sign requests with the secret key itself, so unlike the token
+// secret it can't be kept as a hash. Every token gets a random S3 secret,
+// stored in the config file sealed (AES-GCM) with a key that lives in its
+// own file (secrets_key). A copy of the config file alone reveals nothing.
+
+// sealKey seals and opens the S3 secrets, set by Init
+var sealKey []byte
+
+var errNoKey = errors.New("S3 secret key not loaded")
+var errUnchanged = errors.New("unchanged")
+
+// Init loads the sealing key, generating it on first run, and brings the S3
+// secrets of all tokens under it:
+//  1. Tokens made before the S3 API get a random secret.
+//  2. After a rotation (the previous key renamed to <secrets_key>.old and a
+//     new one generated) every secret is resealed with the new key.
+func Init() error {
+	key, created, err := loadKey(config.SecretsKeyFile, true)
+	if err != nil {
+		return err
+	}
+	old, _, err := loadKey(config.SecretsKeyFile+".old", false)
+	if err != nil {
+		return err
+	}
+	sealKey = key
+	if created {
+		fmt.Println("🔑 Generated a key for S3 secrets in " + config.SecretsKeyFile)
+	}
+
+	resealed, unreadable := 0, 0
+	err = config.UpdateConfig(func(cfg *types.ConfigFile) error {
+		changed := false
+		for i := range cfg.Tokens {
+			t := &cfg.Tokens[i]
+			var secret string
+			var err error
+			switch {
+			case t.S3Secret == "":
+				if secret, err = security.RandomToken(30); err != nil {
+					return err
+				}
+			default:
+				if _, err := open(key, t.ID, t.S3Secret); err == nil {
+					continue
+				}
+				if secret, err = open(old, t.ID, t.S3Secret); err != nil {
+					unreadable++
+					continue
+				}
+				resealed++
+			}
+			sealed, err := seal(key, t.ID, secret)
+			if err != nil {
+				return err
+			}
+			t.S3Secret = sealed
+			changed = true
+		}
+		if !changed {
+			return errUnchanged
+		}
+		return nil
+	})
+	if err != nil && err != errUnchanged {
+		return err
+	}
+
+	if resealed > 0 {
+		fmt.Printf("🔑 Resealed %d S3 secrets with the new key; %s.old can be deleted\n", resealed, config.SecretsKeyFile)
+	}
+	if unreadable > 0 {
+		fmt.Printf("⚠️  %d API tokens have S3 secrets sealed with another key. Restore %s (or put the old key in %s.old); until then they can't use the S3 API.\n",
+			unreadable, config.SecretsKeyFile, config.SecretsKeyFile)
+	}
+	return nil
+}
+
+// loadKey reads a sealing key. A missing file is generated when create is
+// set, and returns a nil key otherwise.
+func loadKey(path string, create bool) ([]byte, bool, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		if !create {
+			return nil, false, nil
+		}
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, false, err
+		}
+		encoded := base64.StdEncoding.EncodeToString(key) + "\n"
+		return key, true, os.WriteFile(path, []byte(encoded), 0600)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != 32 {
+		return nil, false, fmt.Errorf("%s: not a sealing key", path)
+	}
+	return key, false, nil
+}
+
+// seal encrypts an S3 secret, bound to the token ID so that sealed secrets
+// can't be swapped between tokens
+func seal(key []byte, id, secret string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(secret), []byte(id))
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// open decrypts what seal returned
+func open(key []byte, id, sealed string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	data, err := base64.RawURLEncoding.DecodeString(sealed)
+	if err != nil || len(data) < gcm.NonceSize() {
+		return "", errors.New("malformed S3 secret")
+	}
+	nonce, data := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	secret, err := gcm.Open(nil, nonce, data, []byte(id))
+	if err != nil {
+		return "", err
+	}
+	return string(secret), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if key == nil {
+		return nil, errNoKey
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
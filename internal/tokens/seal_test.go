@@ -0,0 +1,53 @@
+package tokens
+
+import (
+	"os"
+	"testing"
+
+	"GoFiles/internal/config"
+	"GoFiles/internal/types"
+)
+
+func TestSeal(t *testing.T) {
+	setup(t)
+	_, token, err := Create("alice", "laptop", ScopeRead, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := S3Secret(token.ID)
+	if secret == "" || token.S3Secret == secret {
+		t.Fatalf("S3 secret %q stored as %q", secret, token.S3Secret)
+	}
+
+	// 1. A sealed secret only opens for its own token
+	if _, err := open(sealKey, "other", token.S3Secret); err == nil {
+		t.Error("sealed secret opened for another token")
+	}
+
+	// 2. After a rotation the secret stays the same under the new key
+	if err := os.Rename(config.SecretsKeyFile, config.SecretsKeyFile+".old"); err != nil {
+		t.Fatal(err)
+	}
+	oldKey := sealKey
+	if err := Init(); err != nil {
+		t.Fatal(err)
+	}
+	if got := S3Secret(token.ID); got != secret {
+		t.Errorf("S3 secret after rotation = %q, want %q", got, secret)
+	}
+	config.ReadConfig(func(cfg *types.ConfigFile) {
+		if _, err := open(oldKey, token.ID, cfg.Tokens[0].S3Secret); err == nil {
+			t.Error("secret still sealed with the old key")
+		}
+	})
+
+	// 3. Without its key a secret can't be used
+	os.Remove(config.SecretsKeyFile + ".old")
+	os.Remove(config.SecretsKeyFile)
+	if err := Init(); err != nil {
+		t.Fatal(err)
+	}
+	if got := S3Secret(token.ID); got != "" {
+		t.Errorf("S3 secret opened without its key: %q", got)
+	}
+}
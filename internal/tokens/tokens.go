@@ -0,0 +1,213 @@
+package tokens
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"GoFiles/internal/config"
+	"GoFiles/internal/security"
+	"GoFiles/internal/types"
+)
+
+// Scopes, from least to most privileged
+const ScopeRead = "read"
+const ScopeWrite = "write"
+const ScopeAdmin = "admin"
+
+// Prefix makes GoFiles tokens easy to recognize (e.g. by secret scanners)
+const Prefix = "gf_"
+
+// lastUsedInterval limits how often LastUsed is written to disk
+const lastUsedInterval = 5 * time.Minute
+
+var (
+	ErrInvalidName  = errors.New("token name required")
+	ErrInvalidScope = errors.New("invalid scope")
+	ErrNotFound     = errors.New("token not found")
+)
+
+// Create issues a new token and returns its secret (shown to the user only once)
+func Create(username, name, scope string, expiresIn time.Duration) (string, types.APIToken, error) {
+	if strings.TrimSpace(name) == "" {
+		return "", types.APIToken{}, ErrInvalidName
+	}
+	if Rank(scope) == 0 {
+		return "", types.APIToken{}, ErrInvalidScope
+	}
+
+	id, err := security.RandomToken(9)
+	if err != nil {
+		return "", types.APIToken{}, err
+	}
+	secret, err := security.RandomToken(32)
+	if err != nil {
+		return "", types.APIToken{}, err
+	}
+	secret = Prefix + secret
+	s3Secret, err := security.RandomToken(30)
+	if err != nil {
+		return "", types.APIToken{}, err
+	}
+	sealed, err := seal(sealKey, id, s3Secret)
+	if err != nil {
+		return "", types.APIToken{}, err
+	}
+
+	token := types.APIToken{
+		ID:        id,
+		Name:      name,
+		Username:  username,
+		Hash:      security.HashToken(secret),
+		S3Secret:  sealed,
+		Scope:     scope,
+		CreatedAt: time.Now(),
+	}
+	if expiresIn > 0 {
+		token.ExpiresAt = token.CreatedAt.Add(expiresIn)
+	}
+
+	err = config.UpdateConfig(func(cfg *types.ConfigFile) error {
+		cfg.Tokens = append(cfg.Tokens, token)
+		return nil
+	})
+	return secret, token, err
+}
+
+// Validate resolves a secret to its token, rejecting expired ones
+func Validate(secret string) (types.APIToken, bool) {
+	if !strings.HasPrefix(secret, Prefix) {
+		return types.APIToken{}, false
+	}
+	hash := security.HashToken(secret)
+
+	var token types.APIToken
+	found := false
+	config.ReadConfig(func(cfg *types.ConfigFile) {
+		for _, t := range cfg.Tokens {
+			if t.Hash == hash {
+				token = t
+				found = true
+				return
+			}
+		}
+	})
+	if !found || (!token.ExpiresAt.IsZero() && time.Now().After(token.ExpiresAt)) {
+		return types.APIToken{}, false
+	}
+
+	if time.Since(token.LastUsed) > lastUsedInterval {
+		touch(token.ID)
+	}
+	return token, true
+}
+
+// ValidateID resolves an access key ID (the token ID, used by the S3 API) to
+// its token, rejecting expired ones
+func ValidateID(id string) (types.APIToken, bool) {
+	var token types.APIToken
+	found := false
+	config.ReadConfig(func(cfg *types.ConfigFile) {
+		for _, t := range cfg.Tokens {
+			if t.ID == id {
+				token = t
+				found = true
+				return
+			}
+		}
+	})
+	if !found || (!token.ExpiresAt.IsZero() && time.Now().After(token.ExpiresAt)) {
+		return types.APIToken{}, false
+	}
+
+	if time.Since(token.LastUsed) > lastUsedInterval {
+		touch(token.ID)
+	}
+	return token, true
+}
+
+// S3Secret returns the S3 secret access key of a token, opened with the
+// key loaded by Init. Empty if the token doesn't exist or its secret can't
+// be opened.
+func S3Secret(id string) string {
+	var sealed string
+	config.ReadConfig(func(cfg *types.ConfigFile) {
+		for _, t := range cfg.Tokens {
+			if t.ID == id {
+				sealed = t.S3Secret
+			}
+		}
+	})
+	secret, err := open(sealKey, id, sealed)
+	if err != nil {
+		return ""
+	}
+	return secret
+}
+
+// List returns a user's tokens without their hashes
+func List(username string) []types.APIToken {
+	list := []types.APIToken{}
+	config.ReadConfig(func(cfg *types.ConfigFile) {
+		for _, t := range cfg.Tokens {
+			if t.Username == username {
+				t.Hash = ""
+				t.S3Secret = ""
+				list = append(list, t)
+			}
+		}
+	})
+	return list
+}
+
+// Revoke deletes one of the user's tokens
+func Revoke(username, id string) error {
+	return config.UpdateConfig(func(cfg *types.ConfigFile) error {
+		for i, t := range cfg.Tokens {
+			if t.ID == id && t.Username == username {
+				cfg.Tokens = append(cfg.Tokens[:i], cfg.Tokens[i+1:]...)
+				return nil
+			}
+		}
+		return ErrNotFound
+	})
+}
+
+// RevokeAll deletes every token of a user (e.g. when the account is removed)
+func RevokeAll(username string) error {
+	return config.UpdateConfig(func(cfg *types.ConfigFile) error {
+		kept := cfg.Tokens[:0]
+		for _, t := range cfg.Tokens {
+			if t.Username != username {
+				kept = append(kept, t)
+			}
+		}
+		cfg.Tokens = kept
+		return nil
+	})
+}
+
+// Rank orders scopes so they can be compared. Unknown scopes rank 0.
+func Rank(scope string) int {
+	switch scope {
+	case ScopeRead:
+		return 1
+	case ScopeWrite:
+		return 2
+	case ScopeAdmin:
+		return 3
+	}
+	return 0
+}
+
+// touch records when a token was last used
+func touch(id string) {
+	config.UpdateConfig(func(cfg *types.ConfigFile) error {
+		for i := range cfg.Tokens {
+			if cfg.Tokens[i].ID == id {
+				cfg.Tokens[i].LastUsed = time.Now()
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,122 @@
+package tokens
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"GoFiles/internal/config"
+	"GoFiles/internal/types"
+)
+
+// setup starts a test with no tokens
+func setup(t *testing.T) {
+	t.Helper()
+	t.Chdir(t.TempDir()) // The config and key files live in the working directory
+	config.AppConfig = types.ConfigFile{}
+	if err := Init(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCreate(t *testing.T) {
+	setup(t)
+	tests := []struct {
+		name      string
+		tokenName string
+		scope     string
+		err       error
+	}{
+		{"read", "laptop", ScopeRead, nil},
+		{"admin", "scripts", ScopeAdmin, nil},
+		{"no name", " ", ScopeRead, ErrInvalidName},
+		{"unknown scope", "laptop", "root", ErrInvalidScope},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secret, token, err := Create("alice", tt.tokenName, tt.scope, 0)
+			if err != tt.err {
+				t.Fatalf("Create error = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if !strings.HasPrefix(secret, Prefix) || token.Hash == "" || strings.Contains(token.Hash, secret) {
+				t.Errorf("secret %q stored as %q", secret, token.Hash)
+			}
+			got, ok := Validate(secret)
+			if !ok || got.ID != token.ID || got.Scope != tt.scope {
+				t.Errorf("Validate = %+v, %v", got, ok)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	setup(t)
+	secret, token, err := Create("alice", "laptop", ScopeWrite, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, _, _ := Create("alice", "old", ScopeWrite, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	tests := []struct {
+		name   string
+		secret string
+		ok     bool
+	}{
+		{"valid", secret, true},
+		{"without prefix", strings.TrimPrefix(secret, Prefix), false},
+		{"changed", secret + "x", false},
+		{"expired", expired, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := Validate(tt.secret); ok != tt.ok {
+				t.Errorf("Validate ok = %v, want %v", ok, tt.ok)
+			}
+		})
+	}
+	if _, ok := ValidateID(token.ID); !ok {
+		t.Error("ValidateID refused a valid token")
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	setup(t)
+	aliceSecret, alice, _ := Create("alice", "laptop", ScopeRead, 0)
+	Create("alice", "phone", ScopeRead, 0)
+	bobSecret, _, _ := Create("bob", "laptop", ScopeRead, 0)
+
+	// 1. List hides the secrets
+	list := List("alice")
+	if len(list) != 2 {
+		t.Fatalf("List returned %d tokens, want 2", len(list))
+	}
+	for _, token := range list {
+		if token.Hash != "" || token.S3Secret != "" {
+			t.Errorf("List leaks the secrets of %s", token.Name)
+		}
+	}
+
+	// 2. Users can only revoke their own tokens
+	if err := Revoke("bob", alice.ID); err != ErrNotFound {
+		t.Errorf("revoking another user's token = %v, want %v", err, ErrNotFound)
+	}
+	if err := Revoke("alice", alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := Validate(aliceSecret); ok {
+		t.Error("revoked token still valid")
+	}
+
+	// 3. RevokeAll leaves other users' tokens alone
+	RevokeAll("alice")
+	if n := len(List("alice")); n != 0 {
+		t.Errorf("%d tokens left after RevokeAll", n)
+	}
+	if _, ok := Validate(bobSecret); !ok {
+		t.Error("RevokeAll removed another user's token")
+	}
+}
@@ -0,0 +1,87 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// The RFC 6238 test secret ("12345678901234567890") in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodeAt(t *testing.T) {
+	// RFC 6238 appendix B (SHA-1), last 6 of the 8 digits
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		code, err := CodeAt(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil || code != tt.code {
+			t.Errorf("CodeAt(%d) = %q, %v; want %q", tt.unix, code, err, tt.code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	saved := Now
+	Now = func() time.Time { return now }
+	defer func() { Now = saved }()
+
+	step := Step(now)
+	codeAt := func(step int64) string {
+		code, err := CodeAt(rfcSecret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		step   int64
+		ok     bool
+	}{
+		{"current", rfcSecret, codeAt(step), step, true},
+		{"previous period", rfcSecret, codeAt(step - 1), step - 1, true},
+		{"next period", rfcSecret, codeAt(step + 1), step + 1, true},
+		{"two periods ago", rfcSecret, codeAt(step - 2), 0, false},
+		{"two periods ahead", rfcSecret, codeAt(step + 2), 0, false},
+		{"spaces", rfcSecret, " 081 804 ", step, true},
+		{"lowercase secret", "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", "081804", step, true},
+		{"wrong code", rfcSecret, "000000", 0, false},
+		{"too short", rfcSecret, "08180", 0, false},
+		{"too long", rfcSecret, "0818040", 0, false},
+		{"empty", rfcSecret, "", 0, false},
+		{"invalid secret", "not base32!", "081804", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(tt.secret, tt.code)
+			if ok != tt.ok || step != tt.step {
+				t.Errorf("Validate(%q) = %d, %v; want %d, %v", tt.code, step, ok, tt.step, tt.ok)
+			}
+		})
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secret) != 32 {
+		t.Errorf("secret %q has %d characters, want 32 (160 bits)", secret, len(secret))
+	}
+	if _, err := CodeAt(secret, 1); err != nil {
+		t.Errorf("CodeAt with a generated secret: %v", err)
+	}
+}
@@ -3,127 +3,213 @@ package trash
 import (
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"os"
-	"path/filepath"
+	"path"
 	"strings"
+	"sync"
 	"time"
 
 	"GoFiles/internal/config"
+	"GoFiles/internal/storage"
 	"GoFiles/internal/types"
 )
 
-// InitTrash creates the hidden trash folder if it doesn't exist
+// Signals the cleanup task to stop, and reports when it has
+var stopCleanup = make(chan struct{})
+var cleanupDone = make(chan struct{})
+var stopOnce sync.Once
+
+// InitTrash creates the hidden trash folders if they don't exist
 // and starts the background cleanup task.
 func InitTrash() {
-	trashPath := filepath.Join(config.RootFolder, config.TrashFolder)
-	if _, err := os.Stat(trashPath); os.IsNotExist(err) {
-		os.Mkdir(trashPath, 0755)
+	for _, dir := range Folders(storage.Root) {
+		if _, err := storage.Root.Stat(dir); os.IsNotExist(err) {
+			storage.Root.Mkdir(dir, 0755)
+		}
 	}
 
 	// Start background cleanup (Runs in a separate thread)
 	go startTrashCleanup()
 }
 
-// MoveToTrash performs a "Soft Delete"
-func MoveToTrash(relativePath string) error {
-	fullSourcePath := filepath.Join(config.RootFolder, relativePath)
-	trashRoot := filepath.Join(config.RootFolder, config.TrashFolder)
+// Folders lists the trash folders of a tree: one per mount, so deleting
+// never has to copy a file to another mount
+func Folders(fsys storage.FS) []string {
+	var dirs []string
+	for _, root := range storage.MountRoots(fsys) {
+		dirs = append(dirs, path.Join(root, config.TrashFolder))
+	}
+	return dirs
+}
+
+// MoveToTrash performs a "Soft Delete".
+// fsys is the tree relativePath is based on (the user's home); each one has its own trash.
+func MoveToTrash(fsys storage.FS, relativePath string) error {
+	trashDir := path.Join(storage.MountOf(fsys, relativePath), config.TrashFolder)
+	fsys.MkdirAll(trashDir, 0755)
 
 	// 1. Generate unique name (file.txt -> file.txt_1739281)
-	info, err := os.Stat(fullSourcePath)
+	info, err := fsys.Stat(relativePath)
 	if err != nil {
 		return err
 	}
 	timestamp := fmt.Sprintf("%d", time.Now().UnixNano())
 	trashName := info.Name() + "_" + timestamp
-	trashPath := filepath.Join(trashRoot, trashName)
+	trashPath := path.Join(trashDir, trashName)
 
 	// 2. Create Metadata File (.json)
 	meta := types.TrashInfo{
-		OriginalPath: relativePath,
+		OriginalPath: "/" + strings.TrimPrefix(relativePath, "/"),
 		DeletedAt:    time.Now(),
 		Filename:     trashName,
 	}
 	metaBytes, _ := json.MarshalIndent(meta, "", "  ")
 
 	// Save metadata: .trash/file.txt_1739281.json
-	err = ioutil.WriteFile(trashPath+".json", metaBytes, 0644)
+	err = storage.WriteFile(fsys, trashPath+".json", metaBytes)
 	if err != nil {
 		return err
 	}
 
 	// 3. Move the actual file
-	return os.Rename(fullSourcePath, trashPath)
+	if err := fsys.Rename(relativePath, trashPath); err != nil {
+		fsys.Remove(trashPath + ".json")
+		return err
+	}
+	return nil
 }
 
 // RestoreFromTrash moves a file back to its original location
-func RestoreFromTrash(trashFilename string) error {
-	trashRoot := filepath.Join(config.RootFolder, config.TrashFolder)
-	trashFilePath := filepath.Join(trashRoot, trashFilename)
-	metaFilePath := trashFilePath + ".json"
-
+func RestoreFromTrash(fsys storage.FS, trashFilename string) error {
 	// 1. Read Metadata
-	metaBytes, err := ioutil.ReadFile(metaFilePath)
+	meta, err := ReadMeta(fsys, trashFilename)
 	if err != nil {
-		return fmt.Errorf("metadata not found")
+		return err
 	}
-	var meta types.TrashInfo
-	json.Unmarshal(metaBytes, &meta)
+	trashFilePath := path.Join(find(fsys, trashFilename), trashFilename)
+	metaFilePath := trashFilePath + ".json"
 
 	// 2. Check if original folder still exists
-	destPath := filepath.Join(config.RootFolder, meta.OriginalPath)
-	destDir := filepath.Dir(destPath)
-	if _, err := os.Stat(destDir); os.IsNotExist(err) {
+	destPath, ok := storage.Clean(meta.OriginalPath)
+	if !ok {
+		return fmt.Errorf("invalid original path")
+	}
+	destDir := path.Dir(destPath)
+	if _, err := fsys.Stat(destDir); os.IsNotExist(err) {
 		// If original folder is gone, recreate it
-		os.MkdirAll(destDir, 0755)
+		fsys.MkdirAll(destDir, 0755)
 	}
 
 	// 3. Move File Back
-	if err := os.Rename(trashFilePath, destPath); err != nil {
+	if err := fsys.Rename(trashFilePath, destPath); err != nil {
 		return err
 	}
 
 	// 4. Delete Metadata File
-	os.Remove(metaFilePath)
+	fsys.Remove(metaFilePath)
 	return nil
 }
 
-// startTrashCleanup runs forever, checking for old files every hour
+// ReadMeta loads the metadata stored next to a trashed file
+func ReadMeta(fsys storage.FS, trashFilename string) (types.TrashInfo, error) {
+	var meta types.TrashInfo
+	metaFilePath := path.Join(find(fsys, trashFilename), trashFilename+".json")
+	metaBytes, err := storage.ReadFile(fsys, metaFilePath)
+	if err != nil {
+		return meta, fmt.Errorf("metadata not found")
+	}
+	err = json.Unmarshal(metaBytes, &meta)
+	return meta, err
+}
+
+// find returns the trash folder holding an item (the first one if none does)
+func find(fsys storage.FS, trashFilename string) string {
+	dirs := Folders(fsys)
+	for _, dir := range dirs {
+		if _, err := fsys.Stat(path.Join(dir, trashFilename+".json")); err == nil {
+			return dir
+		}
+	}
+	return dirs[0]
+}
+
+// StopTrash stops the background cleanup, waiting for a running pass to finish
+func StopTrash() {
+	stopOnce.Do(func() {
+		close(stopCleanup)
+		<-cleanupDone
+	})
+}
+
+// startTrashCleanup checks for old files every hour until StopTrash is called
 func startTrashCleanup() {
+	defer close(cleanupDone)
+
+	// Wait first to let server start up
+	ticker := time.NewTicker(1 * time.Hour)
+	defer ticker.Stop()
 	for {
-		// Sleep first to let server start up
-		time.Sleep(1 * time.Hour)
+		select {
+		case <-stopCleanup:
+			return
+		case <-ticker.C:
+		}
 
 		fmt.Println("🧹 Running Auto-Trash Cleanup...")
-		trashRoot := filepath.Join(config.RootFolder, config.TrashFolder)
-		files, _ := ioutil.ReadDir(trashRoot)
-
-		for _, f := range files {
-			// specific logic: only check .json files to find age
-			if strings.HasSuffix(f.Name(), ".json") {
-				continue
+		for _, fsys := range Roots() {
+			for _, dir := range Folders(fsys) {
+				cleanupTrash(fsys, dir)
 			}
+		}
+	}
+}
 
-			// Check the corresponding JSON file for the date
-			metaBytes, err := ioutil.ReadFile(filepath.Join(trashRoot, f.Name()+".json"))
-			if err != nil {
-				// No metadata? Just rely on file mod time
-				if time.Since(f.ModTime()) > config.TrashRetention {
-					os.RemoveAll(filepath.Join(trashRoot, f.Name()))
-				}
-				continue
+// Roots lists every tree that has its own trash and other hidden folders: the
+// root and each user's home
+func Roots() []storage.FS {
+	roots := []storage.FS{storage.Root}
+	seen := map[string]bool{".": true}
+	config.ReadConfig(func(cfg *types.ConfigFile) {
+		for _, u := range cfg.Users {
+			home, ok := storage.Clean(u.Home)
+			if ok && !seen[home] {
+				seen[home] = true
+				roots = append(roots, storage.Sub(storage.Root, home))
 			}
+		}
+	})
+	return roots
+}
 
-			var meta types.TrashInfo
-			json.Unmarshal(metaBytes, &meta)
+// cleanupTrash permanently removes expired items from one trash folder
+func cleanupTrash(fsys storage.FS, trashDir string) {
+	files, _ := fsys.ReadDir(trashDir)
 
-			if time.Since(meta.DeletedAt) > config.TrashRetention {
-				fmt.Printf("🗑️ Auto-deleting old file: %s\n", f.Name())
-				// Delete File AND Metadata
-				os.RemoveAll(filepath.Join(trashRoot, f.Name()))
-				os.Remove(filepath.Join(trashRoot, f.Name()+".json"))
+	for _, f := range files {
+		// specific logic: only check .json files to find age
+		if strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		itemPath := path.Join(trashDir, f.Name())
+
+		// Check the corresponding JSON file for the date
+		metaBytes, err := storage.ReadFile(fsys, itemPath+".json")
+		if err != nil {
+			// No metadata? Just rely on file mod time
+			if info, err := f.Info(); err == nil && time.Since(info.ModTime()) > config.TrashRetention {
+				fsys.RemoveAll(itemPath)
 			}
+			continue
+		}
+
+		var meta types.TrashInfo
+		json.Unmarshal(metaBytes, &meta)
+
+		if time.Since(meta.DeletedAt) > config.TrashRetention {
+			fmt.Printf("🗑️ Auto-deleting old file: %s\n", f.Name())
+			// Delete File AND Metadata
+			fsys.RemoveAll(itemPath)
+			fsys.Remove(itemPath + ".json")
 		}
 	}
 }
@@ -13,8 +13,54 @@ type FileInfo struct {
 
 // ConfigFile represents the structure of the configuration file
 type ConfigFile struct {
+	Users     []User     `json:"users"`
+	Tokens    []APIToken `json:"tokens,omitempty"`
+	Username  string     `json:"username,omitempty"` // Legacy single-user login, migrated into Users
+	Password  string     `json:"password,omitempty"` // Legacy single-user login, migrated into Users
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// User represents an account stored in the configuration file
+type User struct {
+	Username  string    `json:"username"`
+	Password  string    `json:"password"`       // bcrypt hash
+	Role      string    `json:"role"`           // "admin" or "user"
+	Home      string    `json:"home,omitempty"` // Base folder inside the root; empty means the whole root
+	Rules     []ACLRule `json:"rules,omitempty"`
+	Quota     int64     `json:"quota,omitempty"` // Storage quota in bytes; 0 uses default_quota
+	CreatedAt time.Time `json:"created_at"`
+
+	// Two-factor authentication (TOTP)
+	TOTPSecret    string   `json:"totp_secret,omitempty"`    // Set once enrollment is confirmed
+	TOTPPending   string   `json:"totp_pending,omitempty"`   // Secret awaiting its first code
+	TOTPLastStep  int64    `json:"totp_last_step,omitempty"` // Last accepted time step (prevents replays)
+	RecoveryCodes []string `json:"recovery_codes,omitempty"` // Hashes of unused recovery codes
+
+	SSHKeys []SSHKey `json:"ssh_keys,omitempty"` // Public keys accepted by the SFTP server
+}
+
+// SSHKey is a public key a user can log in to the SFTP server with
+type SSHKey struct {
+	Name        string    `json:"name"`
+	Key         string    `json:"key"`         // authorized_keys format, without the comment
+	Fingerprint string    `json:"fingerprint"` // SHA256:..., as shown by ssh-keygen -l
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// ACLRule grants permissions ("read", "write", "delete") on a path prefix
+type ACLRule struct {
+	Path        string   `json:"path"`
+	Permissions []string `json:"permissions"`
+}
+
+// UserInfo is the public view of a User (never includes the password hash)
+type UserInfo struct {
 	Username  string    `json:"username"`
-	Password  string    `json:"password"`
+	Role      string    `json:"role"`
+	Home      string    `json:"home,omitempty"`
+	Rules     []ACLRule `json:"rules,omitempty"`
+	Quota     int64     `json:"quota,omitempty"`
+	TwoFactor bool      `json:"two_factor"`
 	CreatedAt time.Time `json:"created_at"`
 }
 
@@ -38,6 +84,16 @@ type TrashInfo struct {
 	Filename     string    `json:"filename"`
 }
 
+// TusUpload is the state of an unfinished resumable upload, stored next to
+// the data received so far
+type TusUpload struct {
+	Username  string    `json:"username"`
+	Path      string    `json:"path"`     // Where the file goes once complete
+	Length    int64     `json:"length"`   // Upload-Length
+	Metadata  string    `json:"metadata"` // Upload-Metadata as sent by the client
+	CreatedAt time.Time `json:"created_at"`
+}
+
 // ArchiveRequest represents the request for Zip/Unzip operations
 type ArchiveRequest struct {
 	SourcePath string `json:"sourcePath"` // File/Folder to zip, or Zip file to unzip
@@ -45,6 +101,48 @@ type ArchiveRequest struct {
 	Password   string `json:"password"`   // Optional: Leave empty for no password
 }
 
+// UploadResult reports what happened to one file of an upload
+type UploadResult struct {
+	Name   string `json:"name"`           // File name or relative path sent by the client
+	Path   string `json:"path,omitempty"` // Where it was stored
+	Status string `json:"status"`         // "created", "overwritten", "renamed", "skipped", "conflict" or "failed"
+	Size   int64  `json:"size,omitempty"`
+	ETag   string `json:"etag,omitempty"` // Version of the stored file, for If-Match
+	Error  string `json:"error,omitempty"`
+}
+
+// UploadResponse lists the results of an upload in the order the files were sent
+type UploadResponse struct {
+	Files []UploadResult `json:"files"`
+	Error string         `json:"error,omitempty"` // Why the upload stopped early (size limit, quota, disk full)
+}
+
+// UsageResponse reports a user's storage usage against their limits (bytes)
+type UsageResponse struct {
+	Username      string      `json:"username"`
+	Used          int64       `json:"used"`
+	Quota         int64       `json:"quota"`           // 0 = unlimited
+	MaxUploadSize int64       `json:"max_upload_size"` // 0 = no limit
+	Disks         []DiskUsage `json:"disks,omitempty"`
+}
+
+// DiskUsage is the room left on the disk behind one mount
+type DiskUsage struct {
+	Path      string `json:"path"`
+	Free      int64  `json:"free"`
+	Available int64  `json:"available"` // Free minus min_free_space
+}
+
+// VersionConflict is sent with 412 when If-Match names a version that is
+// no longer current. ETag is empty if the file doesn't exist anymore.
+type VersionConflict struct {
+	Error   string `json:"error"`
+	Path    string `json:"path"`
+	ETag    string `json:"etag,omitempty"`
+	Size    int64  `json:"size,omitempty"`
+	ModTime string `json:"mod_time,omitempty"`
+}
+
 // SaveFileRequest represents the request to save a text file
 type SaveFileRequest struct {
 	Path    string `json:"path"`
@@ -56,3 +154,91 @@ type LoginRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
 }
+
+// LoginTwoFactorRequest completes a login that requires a second factor
+type LoginTwoFactorRequest struct {
+	Challenge string `json:"challenge"`
+	Code      string `json:"code"` // TOTP code or recovery code
+}
+
+// TwoFactorRequest carries a code (and the password where required) for 2FA settings
+type TwoFactorRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+// TwoFactorEnrollResponse returns the secret to add to an authenticator app
+type TwoFactorEnrollResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// RecoveryCodesResponse returns one-time recovery codes (shown only once)
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// UserRequest represents the body for creating or updating a user
+type UserRequest struct {
+	Username string     `json:"username"`
+	Password string     `json:"password"` // Optional on update
+	Role     string     `json:"role"`     // Optional on update
+	Home     *string    `json:"home"`     // Optional; "" gives access to the whole root
+	Rules    *[]ACLRule `json:"rules"`    // Optional; an empty list removes all restrictions
+	Quota    *string    `json:"quota"`    // Optional; e.g. "10GB", "" or "0" to use the default
+
+	ResetTwoFactor bool `json:"resetTwoFactor"` // Turn off 2FA for a locked-out user
+}
+
+// AddSSHKeyRequest registers a public key for SFTP
+type AddSSHKeyRequest struct {
+	Name string `json:"name"` // Optional; defaults to the key's comment
+	Key  string `json:"key"`  // A line from authorized_keys or a .pub file
+}
+
+// ChangePasswordRequest represents a user changing their own password
+type ChangePasswordRequest struct {
+	OldPassword string `json:"oldPassword"`
+	NewPassword string `json:"newPassword"`
+}
+
+// SessionInfo describes an active login session
+type SessionInfo struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	LastSeen  time.Time `json:"last_seen"`
+	ExpiresAt time.Time `json:"expires_at"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Current   bool      `json:"current"`
+}
+
+// APIToken is a long-lived credential for scripts. Only the hash of the secret is stored.
+type APIToken struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Username  string    `json:"username"`
+	Hash      string    `json:"hash,omitempty"`
+	S3Secret  string    `json:"s3_secret,omitempty"` // Sealed with the secrets_key file, see tokens.Init
+	Scope     string    `json:"scope"`               // "read", "write" or "admin"
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"` // Zero means never
+	LastUsed  time.Time `json:"last_used,omitzero"`
+}
+
+// CreateTokenRequest represents the body for creating an API token
+type CreateTokenRequest struct {
+	Name          string `json:"name"`
+	Scope         string `json:"scope"`
+	ExpiresInDays int    `json:"expiresInDays"` // 0 means never
+}
+
+// CreateTokenResponse returns the secret once; it can't be retrieved later
+type CreateTokenResponse struct {
+	Token  string   `json:"token"`
+	Detail APIToken `json:"detail"`
+
+	// Credentials for the S3 API, when it is enabled
+	S3AccessKey string `json:"s3_access_key,omitempty"`
+	S3SecretKey string `json:"s3_secret_key,omitempty"`
+}
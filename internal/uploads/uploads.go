@@ -0,0 +1,106 @@
+package uploads
+
+import (
+	"fmt"
+	"path"
+	"sync"
+	"time"
+
+	"GoFiles/internal/config"
+	"GoFiles/internal/storage"
+	"GoFiles/internal/trash"
+)
+
+// Unfinished uploads (tus and S3 multipart) are staged in a folder per upload
+// inside the uploads folder of the mount they are going to, so completing one
+// is a rename on the same mount.
+
+// Signals the cleanup task to stop, and reports when it has
+var stopCleanup = make(chan struct{})
+var cleanupDone = make(chan struct{})
+var stopOnce sync.Once
+
+// Dir returns the uploads folder of the mount name is on
+func Dir(fsys storage.FS, name string) string {
+	return path.Join(storage.MountOf(fsys, name), config.UploadsFolder)
+}
+
+// Find returns the staging folder of an upload, looking in the uploads
+// folder of every mount of the tree
+func Find(fsys storage.FS, id string) (string, bool) {
+	for _, root := range storage.MountRoots(fsys) {
+		dir := path.Join(root, config.UploadsFolder, id)
+		if info, err := fsys.Stat(dir); err == nil && info.IsDir() {
+			return dir, true
+		}
+	}
+	return "", false
+}
+
+// LastActivity returns when anything in a staging folder was last written
+func LastActivity(fsys storage.FS, dir string) time.Time {
+	var last time.Time
+	if info, err := fsys.Stat(dir); err == nil {
+		last = info.ModTime()
+	}
+	entries, _ := fsys.ReadDir(dir)
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil && info.ModTime().After(last) {
+			last = info.ModTime()
+		}
+	}
+	return last
+}
+
+// ExpiresAt returns when a staging folder will be removed if nothing more is
+// written to it
+func ExpiresAt(fsys storage.FS, dir string) time.Time {
+	return LastActivity(fsys, dir).Add(config.UploadExpiry)
+}
+
+// Start runs the background task removing expired uploads
+func Start() {
+	go startCleanup()
+}
+
+// Stop stops the background cleanup, waiting for a running pass to finish
+func Stop() {
+	stopOnce.Do(func() {
+		close(stopCleanup)
+		<-cleanupDone
+	})
+}
+
+// startCleanup checks for stale uploads every hour until Stop is called
+func startCleanup() {
+	defer close(cleanupDone)
+
+	ticker := time.NewTicker(1 * time.Hour)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCleanup:
+			return
+		case <-ticker.C:
+		}
+
+		for _, fsys := range trash.Roots() {
+			for _, root := range storage.MountRoots(fsys) {
+				cleanupUploads(fsys, path.Join(root, config.UploadsFolder))
+			}
+		}
+	}
+}
+
+// cleanupUploads removes the uploads of one folder that have seen no
+// activity for longer than the expiry
+func cleanupUploads(fsys storage.FS, uploadsDir string) {
+	entries, _ := fsys.ReadDir(uploadsDir)
+	for _, entry := range entries {
+		dir := path.Join(uploadsDir, entry.Name())
+		if time.Now().After(ExpiresAt(fsys, dir)) {
+			fmt.Printf("🧹 Removing expired upload: %s\n", dir)
+			fsys.RemoveAll(dir)
+		}
+	}
+}
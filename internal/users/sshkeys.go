@@ -0,0 +1,93 @@
+package users
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"time"
+
+	"GoFiles/internal/config"
+	"GoFiles/internal/types"
+
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	ErrInvalidSSHKey = errors.New("invalid public key")
+	ErrSSHKeyExists  = errors.New("this key is already registered")
+	ErrSSHKeyMissing = errors.New("key not found")
+)
+
+// SSHKeys returns the public keys registered for a user
+func SSHKeys(username string) []types.SSHKey {
+	user, _ := Get(username)
+	if user.SSHKeys == nil {
+		return []types.SSHKey{}
+	}
+	return user.SSHKeys
+}
+
+// AddSSHKey registers a public key (one authorized_keys line) for SFTP logins
+func AddSSHKey(username, name, line string) (types.SSHKey, error) {
+	pub, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+	if err != nil {
+		return types.SSHKey{}, ErrInvalidSSHKey
+	}
+	if name = strings.TrimSpace(name); name == "" {
+		name = comment
+	}
+	key := types.SSHKey{
+		Name:        name,
+		Key:         strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub))),
+		Fingerprint: ssh.FingerprintSHA256(pub),
+		CreatedAt:   time.Now(),
+	}
+
+	err = config.UpdateConfig(func(cfg *types.ConfigFile) error {
+		i := indexOf(cfg, username)
+		if i < 0 {
+			return ErrUserNotFound
+		}
+		for _, k := range cfg.Users[i].SSHKeys {
+			if k.Fingerprint == key.Fingerprint {
+				return ErrSSHKeyExists
+			}
+		}
+		cfg.Users[i].SSHKeys = append(cfg.Users[i].SSHKeys, key)
+		return nil
+	})
+	return key, err
+}
+
+// RemoveSSHKey unregisters the key with the given fingerprint
+func RemoveSSHKey(username, fingerprint string) error {
+	return config.UpdateConfig(func(cfg *types.ConfigFile) error {
+		i := indexOf(cfg, username)
+		if i < 0 {
+			return ErrUserNotFound
+		}
+		keys := cfg.Users[i].SSHKeys
+		for j, k := range keys {
+			if k.Fingerprint == fingerprint {
+				cfg.Users[i].SSHKeys = append(keys[:j], keys[j+1:]...)
+				return nil
+			}
+		}
+		return ErrSSHKeyMissing
+	})
+}
+
+// AuthenticateKey returns the user if pub is one of their registered keys
+func AuthenticateKey(username string, pub ssh.PublicKey) (types.User, bool) {
+	user, found := Get(username)
+	if !found {
+		return types.User{}, false
+	}
+	for _, k := range user.SSHKeys {
+		registered, _, _, _, err := ssh.ParseAuthorizedKey([]byte(k.Key))
+		if err == nil && bytes.Equal(registered.Marshal(), pub.Marshal()) {
+			return user, true
+		}
+	}
+	return types.User{}, false
+}
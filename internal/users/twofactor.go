@@ -0,0 +1,198 @@
+package users
+
+import (
+	"crypto/rand"
+	"errors"
+	"strings"
+
+	"GoFiles/internal/config"
+	"GoFiles/internal/security"
+	"GoFiles/internal/totp"
+	"GoFiles/internal/types"
+)
+
+// Issuer is shown next to the account in authenticator apps
+const Issuer = "GoFiles"
+
+// recoveryCodeCount is how many one-time codes are issued on enrollment
+const recoveryCodeCount = 10
+
+var (
+	ErrTwoFactorEnabled  = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorDisabled = errors.New("two-factor authentication is not enabled")
+	ErrNoEnrollment      = errors.New("start enrollment first")
+	ErrInvalidCode       = errors.New("invalid code")
+)
+
+// HasTwoFactor reports whether a user must enter a second factor at login
+func HasTwoFactor(u types.User) bool {
+	return u.TOTPSecret != ""
+}
+
+// EnrollTOTP creates a pending secret. 2FA is only enabled once ConfirmTOTP succeeds.
+func EnrollTOTP(username string) (types.TwoFactorEnrollResponse, error) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return types.TwoFactorEnrollResponse{}, err
+	}
+
+	err = config.UpdateConfig(func(cfg *types.ConfigFile) error {
+		i := indexOf(cfg, username)
+		if i < 0 {
+			return ErrUserNotFound
+		}
+		if HasTwoFactor(cfg.Users[i]) {
+			return ErrTwoFactorEnabled
+		}
+		cfg.Users[i].TOTPPending = secret
+		return nil
+	})
+	if err != nil {
+		return types.TwoFactorEnrollResponse{}, err
+	}
+	return types.TwoFactorEnrollResponse{Secret: secret, URI: totp.URI(Issuer, username, secret)}, nil
+}
+
+// ConfirmTOTP enables 2FA once the user proves their app produces valid codes.
+// Returns the recovery codes in plaintext; only their hashes are stored.
+func ConfirmTOTP(username, code string) ([]string, error) {
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	err = config.UpdateConfig(func(cfg *types.ConfigFile) error {
+		i := indexOf(cfg, username)
+		if i < 0 {
+			return ErrUserNotFound
+		}
+		u := &cfg.Users[i]
+		if HasTwoFactor(*u) {
+			return ErrTwoFactorEnabled
+		}
+		if u.TOTPPending == "" {
+			return ErrNoEnrollment
+		}
+		step, ok := totp.Validate(u.TOTPPending, code)
+		if !ok {
+			return ErrInvalidCode
+		}
+		u.TOTPSecret = u.TOTPPending
+		u.TOTPPending = ""
+		u.TOTPLastStep = step
+		u.RecoveryCodes = hashes
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTOTP turns 2FA off and discards the secret and recovery codes
+func DisableTOTP(username string) error {
+	return config.UpdateConfig(func(cfg *types.ConfigFile) error {
+		i := indexOf(cfg, username)
+		if i < 0 {
+			return ErrUserNotFound
+		}
+		clearTwoFactor(&cfg.Users[i])
+		return nil
+	})
+}
+
+// clearTwoFactor discards the TOTP secret and recovery codes of a user
+func clearTwoFactor(u *types.User) {
+	u.TOTPSecret = ""
+	u.TOTPPending = ""
+	u.TOTPLastStep = 0
+	u.RecoveryCodes = nil
+}
+
+// VerifySecondFactor accepts a current TOTP code or an unused recovery code.
+// Each TOTP code and recovery code only works once.
+func VerifySecondFactor(username, code string) bool {
+	err := config.UpdateConfig(func(cfg *types.ConfigFile) error {
+		i := indexOf(cfg, username)
+		if i < 0 {
+			return ErrUserNotFound
+		}
+		u := &cfg.Users[i]
+		if !HasTwoFactor(*u) {
+			return ErrTwoFactorDisabled
+		}
+
+		// 1. Authenticator code
+		if step, ok := totp.Validate(u.TOTPSecret, code); ok {
+			if step <= u.TOTPLastStep {
+				return ErrInvalidCode // Replay of an already used code
+			}
+			u.TOTPLastStep = step
+			return nil
+		}
+
+		// 2. Recovery code (consumed on use)
+		hash := security.HashToken(normalizeRecoveryCode(code))
+		for j, h := range u.RecoveryCodes {
+			if h == hash {
+				u.RecoveryCodes = append(u.RecoveryCodes[:j], u.RecoveryCodes[j+1:]...)
+				return nil
+			}
+		}
+		return ErrInvalidCode
+	})
+	return err == nil
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes with a fresh set
+func RegenerateRecoveryCodes(username string) ([]string, error) {
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	err = config.UpdateConfig(func(cfg *types.ConfigFile) error {
+		i := indexOf(cfg, username)
+		if i < 0 {
+			return ErrUserNotFound
+		}
+		if !HasTwoFactor(cfg.Users[i]) {
+			return ErrTwoFactorDisabled
+		}
+		cfg.Users[i].RecoveryCodes = hashes
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// newRecoveryCodes returns codes formatted like "abcde-fghij" and their hashes
+func newRecoveryCodes() ([]string, []string, error) {
+	// 32 symbols without look-alikes (no l, o, 0, 1), so each byte maps without bias
+	const alphabet = "abcdefghijkmnpqrstuvwxyz23456789"
+	var codes, hashes []string
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw := make([]byte, 10)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+		var b strings.Builder
+		for j, c := range raw {
+			if j == 5 {
+				b.WriteByte('-')
+			}
+			b.WriteByte(alphabet[c&31])
+		}
+		code := b.String()
+		codes = append(codes, code)
+		hashes = append(hashes, security.HashToken(normalizeRecoveryCode(code)))
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode ignores case, spaces and dashes when comparing
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
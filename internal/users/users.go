@@ -0,0 +1,284 @@
+package users
+
+import (
+	"errors"
+	"io/fs"
+	"strings"
+	"time"
+
+	"GoFiles/internal/acl"
+	"GoFiles/internal/config"
+	"GoFiles/internal/security"
+	"GoFiles/internal/storage"
+	"GoFiles/internal/types"
+)
+
+// Roles
+const RoleAdmin = "admin"
+const RoleUser = "user"
+
+var (
+	ErrAlreadySetup  = errors.New("system is already configured")
+	ErrInvalidInput  = errors.New("username and password required")
+	ErrInvalidName   = errors.New("invalid username")
+	ErrInvalidRole   = errors.New("invalid role")
+	ErrUserExists    = errors.New("user already exists")
+	ErrUserNotFound  = errors.New("user not found")
+	ErrLastAdmin     = errors.New("cannot remove the last admin")
+	ErrWrongPassword = errors.New("current password is incorrect")
+	ErrInvalidHome   = errors.New("home folder must be inside the root folder or a mount")
+	ErrInvalidQuota  = errors.New(`quota must be a size like "10GB", or 0 for the default`)
+	ErrQuotaNoHome   = errors.New("a quota needs a home folder, whose contents it limits")
+)
+
+// Get returns the user with the given name
+func Get(username string) (types.User, bool) {
+	var user types.User
+	found := false
+	config.ReadConfig(func(cfg *types.ConfigFile) {
+		if i := indexOf(cfg, username); i >= 0 {
+			user = cfg.Users[i]
+			found = true
+		}
+	})
+	return user, found
+}
+
+// List returns the public info of all users
+func List() []types.UserInfo {
+	var list []types.UserInfo
+	config.ReadConfig(func(cfg *types.ConfigFile) {
+		for _, u := range cfg.Users {
+			list = append(list, ToInfo(u))
+		}
+	})
+	return list
+}
+
+// Authenticate checks a username/password pair.
+// The hash comparison always runs so timing doesn't reveal valid usernames.
+func Authenticate(username, password string) (types.User, bool) {
+	user, found := Get(username)
+	hash := user.Password
+	if !found {
+		hash = dummyHash
+	}
+	if !security.CheckPassword(hash, password) || !found {
+		return types.User{}, false
+	}
+	return user, true
+}
+
+// Setup creates the first account as admin. Fails once any user exists.
+func Setup(username, password string) (types.User, error) {
+	return create(username, password, RoleAdmin, true)
+}
+
+// Create adds a new account
+func Create(username, password, role string) (types.User, error) {
+	if role == "" {
+		role = RoleUser
+	}
+	return create(username, password, role, false)
+}
+
+func create(username, password, role string, firstRun bool) (types.User, error) {
+	if username == "" || password == "" {
+		return types.User{}, ErrInvalidInput
+	}
+	if !validName(username) {
+		return types.User{}, ErrInvalidName
+	}
+	if role != RoleAdmin && role != RoleUser {
+		return types.User{}, ErrInvalidRole
+	}
+	hash, err := security.HashPassword(password)
+	if err != nil {
+		return types.User{}, err
+	}
+
+	user := types.User{Username: username, Password: hash, Role: role, CreatedAt: time.Now()}
+	err = config.UpdateConfig(func(cfg *types.ConfigFile) error {
+		if firstRun && len(cfg.Users) > 0 {
+			return ErrAlreadySetup
+		}
+		if indexOf(cfg, username) >= 0 {
+			return ErrUserExists
+		}
+		cfg.Users = append(cfg.Users, user)
+		return nil
+	})
+	return user, err
+}
+
+// Change is an update to an account. Empty or nil fields are left as they are.
+type Change struct {
+	Password       string
+	Role           string
+	Rules          *[]types.ACLRule // An empty list means full access
+	Home           *string          // "" gives access to the whole root
+	Quota          *int64           // In bytes; 0 falls back to default_quota
+	ResetTwoFactor bool             // Turn off 2FA for a locked-out user
+	RemoveSSHKeys  bool
+}
+
+// Modify applies a change to a user in one step: if any part of it is
+// invalid, nothing is saved. The home folder is created if needed. Only
+// users with a home folder can have a quota.
+func Modify(username string, change Change) (types.User, error) {
+	if change.Role != "" && change.Role != RoleAdmin && change.Role != RoleUser {
+		return types.User{}, ErrInvalidRole
+	}
+	hash := ""
+	if change.Password != "" {
+		var err error
+		if hash, err = security.HashPassword(change.Password); err != nil {
+			return types.User{}, err
+		}
+	}
+	var rules []types.ACLRule
+	if change.Rules != nil {
+		var err error
+		if rules, err = acl.ValidateRules(*change.Rules); err != nil {
+			return types.User{}, err
+		}
+	}
+	var home string
+	if change.Home != nil {
+		var ok bool
+		if home, ok = storage.Clean(*change.Home); !ok {
+			return types.User{}, ErrInvalidHome
+		}
+		if home == "." {
+			home = ""
+		}
+	}
+	if change.Quota != nil && *change.Quota < 0 {
+		return types.User{}, ErrInvalidQuota
+	}
+
+	var user types.User
+	err := config.UpdateConfig(func(cfg *types.ConfigFile) error {
+		i := indexOf(cfg, username)
+		if i < 0 {
+			return ErrUserNotFound
+		}
+		if change.Role == RoleUser && cfg.Users[i].Role == RoleAdmin && countAdmins(cfg) == 1 {
+			return ErrLastAdmin
+		}
+
+		u := cfg.Users[i]
+		if hash != "" {
+			u.Password = hash
+		}
+		if change.Role != "" {
+			u.Role = change.Role
+		}
+		if change.Rules != nil {
+			u.Rules = rules
+		}
+		if change.Home != nil {
+			u.Home = home
+		}
+		if change.Quota != nil {
+			u.Quota = *change.Quota
+		}
+		if change.ResetTwoFactor {
+			clearTwoFactor(&u)
+		}
+		if change.RemoveSSHKeys {
+			u.SSHKeys = nil
+		}
+		if u.Quota > 0 && u.Home == "" {
+			return ErrQuotaNoHome
+		}
+		if change.Home != nil {
+			if err := storage.Root.MkdirAll(u.Home, 0755); errors.Is(err, fs.ErrPermission) {
+				return ErrInvalidHome // e.g. next to the mounts instead of inside one
+			} else if err != nil {
+				return err
+			}
+		}
+		cfg.Users[i] = u
+		user = u
+		return nil
+	})
+	return user, err
+}
+
+// Files returns the part of the storage a user is confined to
+func Files(u types.User) storage.FS {
+	return storage.Sub(storage.Root, u.Home)
+}
+
+// ChangePassword lets a user replace their own password after re-entering the current one
+func ChangePassword(username, oldPassword, newPassword string) error {
+	if newPassword == "" {
+		return ErrInvalidInput
+	}
+	if _, ok := Authenticate(username, oldPassword); !ok {
+		return ErrWrongPassword
+	}
+	_, err := Modify(username, Change{Password: newPassword})
+	return err
+}
+
+// Delete removes an account. The last admin can't be deleted.
+func Delete(username string) error {
+	return config.UpdateConfig(func(cfg *types.ConfigFile) error {
+		i := indexOf(cfg, username)
+		if i < 0 {
+			return ErrUserNotFound
+		}
+		if cfg.Users[i].Role == RoleAdmin && countAdmins(cfg) == 1 {
+			return ErrLastAdmin
+		}
+		cfg.Users = append(cfg.Users[:i], cfg.Users[i+1:]...)
+		return nil
+	})
+}
+
+// ToInfo strips private fields from a User
+func ToInfo(u types.User) types.UserInfo {
+	return types.UserInfo{
+		Username:  u.Username,
+		Role:      u.Role,
+		Home:      u.Home,
+		Rules:     u.Rules,
+		Quota:     u.Quota,
+		TwoFactor: HasTwoFactor(u),
+		CreatedAt: u.CreatedAt,
+	}
+}
+
+// --- HELPERS ---
+
+// dummyHash is compared against when the username doesn't exist
+var dummyHash, _ = security.HashPassword("gofiles-dummy-password")
+
+func indexOf(cfg *types.ConfigFile, username string) int {
+	for i, u := range cfg.Users {
+		if u.Username == username {
+			return i
+		}
+	}
+	return -1
+}
+
+func countAdmins(cfg *types.ConfigFile) int {
+	n := 0
+	for _, u := range cfg.Users {
+		if u.Role == RoleAdmin {
+			n++
+		}
+	}
+	return n
+}
+
+// validName keeps usernames safe to use in paths and logs
+func validName(name string) bool {
+	if len(name) > 64 || name == "." || name == ".." {
+		return false
+	}
+	return !strings.ContainsAny(name, "/\\:\x00 \t\r\n")
+}
@@ -0,0 +1,107 @@
+package users
+
+import (
+	"testing"
+
+	"GoFiles/internal/acl"
+	"GoFiles/internal/config"
+	"GoFiles/internal/storage"
+	"GoFiles/internal/types"
+)
+
+// setup starts a test with no accounts and an empty storage root
+func setup(t *testing.T) {
+	t.Chdir(t.TempDir()) // The config file lives in the working directory
+	config.AppConfig = types.ConfigFile{}
+	storage.Root = storage.NewMemory()
+}
+
+func TestModify(t *testing.T) {
+	setup(t)
+	if _, err := Setup("admin", "password1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Create("bob", "password1", RoleUser); err != nil {
+		t.Fatal(err)
+	}
+	str := func(s string) *string { return &s }
+	size := func(n int64) *int64 { return &n }
+
+	tests := []struct {
+		name   string
+		user   string
+		change Change
+		err    error
+	}{
+		{"quota without home", "bob", Change{Role: RoleAdmin, Quota: size(1 << 20)}, ErrQuotaNoHome},
+		{"invalid rule", "bob", Change{Home: str("bob"), Rules: &[]types.ACLRule{{Path: ""}}}, acl.ErrInvalidRule},
+		{"home escapes root", "bob", Change{Role: RoleAdmin, Home: str("../bob")}, ErrInvalidHome},
+		{"negative quota", "bob", Change{Home: str("bob"), Quota: size(-1)}, ErrInvalidQuota},
+		{"invalid role", "bob", Change{Home: str("bob"), Role: "root"}, ErrInvalidRole},
+		{"last admin", "admin", Change{Role: RoleUser, Home: str("admin")}, ErrLastAdmin},
+		{"missing user", "carol", Change{Role: RoleAdmin}, ErrUserNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before, _ := Get(tt.user)
+			_, err := Modify(tt.user, tt.change)
+			if err != tt.err {
+				t.Fatalf("Modify = %v, want %v", err, tt.err)
+			}
+			after, _ := Get(tt.user)
+			if after.Role != before.Role || after.Home != before.Home || after.Quota != before.Quota || len(after.Rules) != len(before.Rules) {
+				t.Errorf("a failed change was partly saved: %+v -> %+v", before, after)
+			}
+		})
+	}
+
+	// Home and quota together, and a home created on the way
+	user, err := Modify("bob", Change{Home: str("/homes/bob/"), Quota: size(1 << 20), Rules: &[]types.ACLRule{{Path: "docs", Permissions: []string{"read"}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Home != "homes/bob" || user.Quota != 1<<20 || len(user.Rules) != 1 || user.Rules[0].Path != "/docs" {
+		t.Errorf("Modify saved %+v", user)
+	}
+	if info, err := storage.Root.Stat("homes/bob"); err != nil || !info.IsDir() {
+		t.Errorf("home folder not created: %v", err)
+	}
+	// The home can only be removed together with the quota
+	if _, err := Modify("bob", Change{Home: str("")}); err != ErrQuotaNoHome {
+		t.Errorf("removing the home of a user with a quota = %v, want %v", err, ErrQuotaNoHome)
+	}
+	if user, err := Modify("bob", Change{Home: str(""), Quota: size(0)}); err != nil || user.Home != "" {
+		t.Errorf("removing home and quota = %+v, %v", user, err)
+	}
+}
+
+func TestModifyPassword(t *testing.T) {
+	setup(t)
+	if _, err := Create("bob", "password1", RoleUser); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AddSSHKey("bob", "laptop", testKey); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Modify("bob", Change{Password: "password2"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := Authenticate("bob", "password1"); ok {
+		t.Error("the old password still works")
+	}
+	if _, ok := Authenticate("bob", "password2"); !ok {
+		t.Error("the new password doesn't work")
+	}
+	if len(SSHKeys("bob")) != 1 {
+		t.Error("SSH keys were removed without RemoveSSHKeys")
+	}
+	if _, err := Modify("bob", Change{Password: "password3", RemoveSSHKeys: true}); err != nil {
+		t.Fatal(err)
+	}
+	if len(SSHKeys("bob")) != 0 {
+		t.Error("RemoveSSHKeys kept the keys")
+	}
+}
+
+const testKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIGr6FXn0zL6dDUW6xWdXwJqz5B0kq0xvP2m+q3gc0tyr laptop"
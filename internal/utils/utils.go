@@ -1,93 +1,85 @@
 package utils
 
 import (
+	"errors"
 	"io"
+	"net"
 	"net/http"
-	"os"
-	"path/filepath"
+	"path"
 
-	"GoFiles/internal/config"
+	"GoFiles/internal/storage"
 )
 
-// EnableCors allows your future Frontend to talk to this backend
-func EnableCors(w *http.ResponseWriter) {
-	// 1. Allow the specific origin sending the request (Dynamic Origin)
-	// This is required when withCredentials is set to true
-	(*w).Header().Set("Access-Control-Allow-Origin", "http://localhost:5173")
-
-	// 2. Allow credentials (cookies)
-	(*w).Header().Set("Access-Control-Allow-Credentials", "true")
-
-	// 3. Allowed Methods
-	(*w).Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
-
-	// 4. Allowed Headers
-	(*w).Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-}
-
-// IsPathSafe ensures the user doesn't try to access protected folders
-func IsPathSafe(path string) bool {
-	root, err := filepath.Abs(config.RootFolder)
-	if err != nil {
-		return false
-	}
-	target, err := filepath.Abs(path)
-	if err != nil {
-		return false
-	}
-	rel, err := filepath.Rel(root, target)
+// ClientIP returns the remote address of the request without the port
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
-		return false
+		return r.RemoteAddr
 	}
-	isOutside := len(rel) >= 2 && rel[0:2] == ".."
-	return !isOutside
+	return host
 }
 
-func CopyFile(src, dst string) error {
-	sourceFile, err := os.Open(src)
+func CopyFile(fsys storage.FS, src, dst string) error {
+	// Let the backend copy by itself when it can (no download/upload round trip)
+	if copier, ok := fsys.(storage.Copier); ok {
+		if err := copier.Copy(src, dst); !errors.Is(err, errors.ErrUnsupported) {
+			return err
+		}
+	}
+
+	sourceFile, err := fsys.Open(src)
 	if err != nil {
 		return err
 	}
 	defer sourceFile.Close()
 
-	destFile, err := os.Create(dst)
+	// An existing file at dst is replaced only once the copy is complete
+	destFile, err := storage.CreateAtomic(fsys, dst)
 	if err != nil {
 		return err
 	}
-	defer destFile.Close()
 
-	_, err = io.Copy(destFile, sourceFile)
-	return err
+	if _, err = io.Copy(destFile, sourceFile); err != nil {
+		destFile.Abort()
+		return err
+	}
+	return destFile.Commit()
 }
 
-// CopyDir recursively copies a directory tree
-func CopyDir(src, dst string) error {
+// CopyDir recursively copies a directory tree, except the internal files
+// (trash, thumbnails, uploads and server files) inside it
+func CopyDir(fsys storage.FS, src, dst string) error {
 	// Get properties of source dir
-	srcInfo, err := os.Stat(src)
+	srcInfo, err := fsys.Stat(src)
 	if err != nil {
 		return err
 	}
 
 	// Create the destination directory
-	if err := os.MkdirAll(dst, srcInfo.Mode()); err != nil {
+	if err := fsys.MkdirAll(dst, srcInfo.Mode().Perm()); err != nil {
 		return err
 	}
 
-	entries, err := os.ReadDir(src)
+	entries, err := fsys.ReadDir(src)
 	if err != nil {
 		return err
 	}
 
 	for _, entry := range entries {
-		srcPath := filepath.Join(src, entry.Name())
-		dstPath := filepath.Join(dst, entry.Name())
+		srcPath := path.Join(src, entry.Name())
+		dstPath := path.Join(dst, entry.Name())
+
+		// Trash, thumbnails and server files stay where they are
+		if storage.IsInternal(fsys, srcPath) {
+			continue
+		}
 
 		if entry.IsDir() {
-			if err := CopyDir(srcPath, dstPath); err != nil {
+			if err := CopyDir(fsys, srcPath, dstPath); err != nil {
 				return err
 			}
 		} else {
-			if err := CopyFile(srcPath, dstPath); err != nil {
+			if err := CopyFile(fsys, srcPath, dstPath); err != nil {
 				return err
 			}
 		}
@@ -1,34 +1,134 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"path"
+	"strings"
+	"syscall"
 
 	"GoFiles/internal/auth"
+	"GoFiles/internal/certs"
 	"GoFiles/internal/config"
+	"GoFiles/internal/cors"
+	"GoFiles/internal/dav"
 	"GoFiles/internal/handlers"
+	"GoFiles/internal/s3api"
+	"GoFiles/internal/seclog"
+	"GoFiles/internal/session"
+	"GoFiles/internal/sftpd"
+	"GoFiles/internal/storage"
+	"GoFiles/internal/tokens"
 	"GoFiles/internal/trash"
+	"GoFiles/internal/uploads"
 )
 
 func main() {
-	// 1. Initialize Sub-systems
+	// 1. Load settings (file, GOFILES_* env vars, flags)
+	if err := config.LoadSettings(os.Args[1:]); err != nil {
+		if err == flag.ErrHelp {
+			return
+		}
+		log.Fatal("Invalid configuration: ", err)
+	}
+
+	// 2. Initialize Sub-systems
+	if err := storage.Init(); err != nil {
+		log.Fatal("Failed to open storage: ", err)
+	}
+	if removed := storage.RemoveTempFiles(storage.Root); removed > 0 {
+		fmt.Printf("🧹 Removed %d unfinished writes\n", removed)
+	}
 	trash.InitTrash()
+	uploads.Start()
 	config.InitConfig()
+	if err := tokens.Init(); err != nil {
+		log.Fatal("Failed to load the key for S3 secrets: ", err)
+	}
+	if err := session.Init(); err != nil {
+		log.Fatal("Failed to open session store: ", err)
+	}
+	if err := seclog.Init(config.SecurityLogFile); err != nil {
+		log.Fatal("Failed to open security log: ", err)
+	}
+	auth.Init()
 
 	// Ensure Thumbs folder exists
-	os.MkdirAll(filepath.Join(config.RootFolder, config.ThumbsFolder), 0755)
+	for _, root := range storage.MountRoots(storage.Root) {
+		storage.Root.MkdirAll(path.Join(root, config.ThumbsFolder), 0755)
+	}
+
+	// HTTPS certificate (generated on first run in self-signed mode)
+	switch config.TLSMode {
+	case "self-signed":
+		created, err := certs.EnsureSelfSigned(config.TLSCert, config.TLSKey)
+		if err != nil {
+			log.Fatal("Failed to prepare self-signed certificate: ", err)
+		}
+		if fingerprint, err := certs.Fingerprint(config.TLSCert); err == nil {
+			if created {
+				fmt.Println("🔏 Generated a self-signed certificate in " + config.TLSCert)
+			}
+			fmt.Println("🔏 Certificate fingerprint (SHA-256): " + fingerprint)
+		}
+	case "files":
+		if err := certs.Check(config.TLSCert, config.TLSKey); err != nil {
+			log.Fatal("Failed to load TLS certificate: ", err)
+		}
+	}
+
+	// SFTP host key (generated on first run)
+	if config.SFTPListen != "" {
+		created, err := sftpd.EnsureHostKey(config.SFTPHostKey)
+		if err != nil {
+			log.Fatal("Failed to prepare SSH host key: ", err)
+		}
+		if created {
+			fmt.Println("🔑 Generated an SSH host key in " + config.SFTPHostKey)
+		}
+	}
 
 	// --- PUBLIC ROUTES ---
 	http.HandleFunc("/api/system/status", auth.HandleSystemStatus)
 	http.HandleFunc("/api/setup", auth.HandleSetup)
 	http.HandleFunc("/api/login", auth.HandleLogin)
+	http.HandleFunc("/api/login/2fa", auth.HandleLoginTwoFactor)
 	http.HandleFunc("/api/logout", auth.HandleLogout)
 
 	// --- PROTECTED ROUTES ---
 	http.HandleFunc("/api/me", auth.AuthMiddleware(auth.HandleCheckAuth))
+	http.HandleFunc("/api/me/password", auth.AuthMiddleware(handlers.HandleChangePassword))
+	http.HandleFunc("/api/sessions/list", auth.AuthMiddleware(handlers.HandleListSessions))
+	http.HandleFunc("/api/sessions/revoke", auth.AuthMiddleware(handlers.HandleRevokeSession))
+	http.HandleFunc("/api/usage", auth.AuthMiddleware(handlers.HandleUsage))
+
+	// Two-Factor Authentication
+	http.HandleFunc("/api/2fa/enroll", auth.CredentialsMiddleware(handlers.HandleTwoFactorEnroll))
+	http.HandleFunc("/api/2fa/confirm", auth.CredentialsMiddleware(handlers.HandleTwoFactorConfirm))
+	http.HandleFunc("/api/2fa/disable", auth.CredentialsMiddleware(handlers.HandleTwoFactorDisable))
+	http.HandleFunc("/api/2fa/recovery-codes", auth.CredentialsMiddleware(handlers.HandleRecoveryCodes))
+
+	// API Tokens
+	http.HandleFunc("/api/tokens/list", auth.AuthMiddleware(handlers.HandleListTokens))
+	http.HandleFunc("/api/tokens/create", auth.CredentialsMiddleware(handlers.HandleCreateToken))
+	http.HandleFunc("/api/tokens/revoke", auth.AuthMiddleware(handlers.HandleRevokeToken))
+
+	// SSH Keys (SFTP logins)
+	http.HandleFunc("/api/ssh-keys/list", auth.AuthMiddleware(handlers.HandleListSSHKeys))
+	http.HandleFunc("/api/ssh-keys/add", auth.CredentialsMiddleware(handlers.HandleAddSSHKey))
+	http.HandleFunc("/api/ssh-keys/remove", auth.AuthMiddleware(handlers.HandleRemoveSSHKey))
+
+	// User Management (Admin only)
+	http.HandleFunc("/api/users/list", auth.AdminMiddleware(handlers.HandleListUsers))
+	http.HandleFunc("/api/users/create", auth.AdminMiddleware(handlers.HandleCreateUser))
+	http.HandleFunc("/api/users/update", auth.AdminMiddleware(handlers.HandleUpdateUser))
+	http.HandleFunc("/api/users/delete", auth.AdminMiddleware(handlers.HandleDeleteUser))
 
 	// Media
 	http.HandleFunc("/api/thumbnail", auth.AuthMiddleware(handlers.HandleThumbnail))
@@ -50,6 +150,8 @@ func main() {
 
 	// Write
 	http.HandleFunc("/api/upload", auth.AuthMiddleware(handlers.HandleUploadFile))
+	http.HandleFunc(handlers.TusPath, auth.AuthMiddleware(handlers.HandleTus)) // Resumable uploads (tus)
+	http.HandleFunc(handlers.TusPath+"/", auth.AuthMiddleware(handlers.HandleTus))
 	http.HandleFunc("/api/save", auth.AuthMiddleware(handlers.HandleSaveFile)) // NEW: Text Save
 	http.HandleFunc("/api/mkdir", auth.AuthMiddleware(handlers.HandleCreateDir))
 	http.HandleFunc("/api/delete", auth.AuthMiddleware(handlers.HandleDelete))
@@ -59,12 +161,173 @@ func main() {
 	http.HandleFunc("/api/move", auth.AuthMiddleware(handlers.HandleMove))
 	http.HandleFunc("/api/copy", auth.AuthMiddleware(handlers.HandleCopy))
 
-	fmt.Println("🚀 GoFiles Server started on http://localhost:8080")
-	if !config.IsConfigured {
-		fmt.Println("⚠️  SYSTEM NOT CONFIGURED. Go to http://localhost:8080 to set up.")
+	// --- WEBDAV ---
+	// Network drive for desktop file managers, same files and rules as the API
+	if config.WebDAV {
+		http.Handle(dav.Prefix+"/", dav.Handler())
+		http.Handle(dav.Prefix, dav.Handler())
+	}
+
+	// --- FRONTEND ---
+	// Everything that isn't an API route is the embedded React app
+	http.HandleFunc("/", handlers.HandleUI)
+
+	scheme := "http://"
+	if config.TLSEnabled() {
+		scheme = "https://"
+	}
+	url := scheme + displayAddr(config.ListenAddr)
+	fmt.Println("🚀 GoFiles Server started on " + url)
+	switch {
+	case len(config.Mounts) > 0:
+		for _, mount := range config.Mounts {
+			fmt.Println("📂 Mounted " + mount)
+		}
+	case config.StorageBackend == "memory":
+		fmt.Println("📂 Serving files from memory (lost on restart)")
+	case config.StorageBackend == "s3":
+		fmt.Println("📂 Serving s3://" + config.S3Bucket + "/" + strings.Trim(config.S3Prefix, "/"))
+	default:
+		fmt.Println("📂 Serving " + config.RootFolder)
+	}
+	if config.ReadOnly {
+		fmt.Println("🔒 Read-only mode: files can't be changed")
+	}
+	if config.WebDAV {
+		fmt.Println("🗄️  WebDAV at " + url + dav.Prefix + "/")
+		if !config.TLSEnabled() {
+			fmt.Println("⚠️  No TLS: WebDAV only accepts passwords from this machine, other clients need an API token")
+		}
+	}
+	if !config.IsConfigured() {
+		fmt.Println("⚠️  SYSTEM NOT CONFIGURED. Go to " + url + " to set up.")
 	} else {
 		fmt.Println("✅ System configured.")
 	}
 
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	server := &http.Server{
+		Addr:              config.ListenAddr,
+		Handler:           cors.Middleware(http.DefaultServeMux),
+		ReadHeaderTimeout: config.ReadHeaderTimeout,
+		ReadTimeout:       config.ReadTimeout,
+		WriteTimeout:      config.WriteTimeout,
+		IdleTimeout:       config.IdleTimeout,
+		MaxHeaderBytes:    config.MaxHeaderBytes,
+	}
+
+	// 3. Serve until SIGINT/SIGTERM, then shut down gracefully
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	servers := []*http.Server{server}
+	serverErr := make(chan error, 3)
+	if config.TLSEnabled() {
+		go func() { serverErr <- server.ListenAndServeTLS(config.TLSCert, config.TLSKey) }()
+	} else {
+		go func() { serverErr <- server.ListenAndServe() }()
+	}
+
+	// Optional plain HTTP listener that only redirects to HTTPS
+	if config.HTTPRedirectAddr != "" {
+		redirect := &http.Server{
+			Addr:              config.HTTPRedirectAddr,
+			Handler:           http.HandlerFunc(redirectToHTTPS),
+			ReadHeaderTimeout: config.ReadHeaderTimeout,
+			IdleTimeout:       config.IdleTimeout,
+			MaxHeaderBytes:    config.MaxHeaderBytes,
+		}
+		servers = append(servers, redirect)
+		go func() { serverErr <- redirect.ListenAndServe() }()
+		fmt.Println("↪️  Redirecting http://" + displayAddr(config.HTTPRedirectAddr) + " to HTTPS")
+	}
+
+	// Optional S3-compatible API on its own port (same certificate as the UI)
+	if config.S3APIListen != "" {
+		s3Server := &http.Server{
+			Addr:              config.S3APIListen,
+			Handler:           s3api.Handler(),
+			ReadHeaderTimeout: config.ReadHeaderTimeout,
+			ReadTimeout:       config.ReadTimeout,
+			WriteTimeout:      config.WriteTimeout,
+			IdleTimeout:       config.IdleTimeout,
+			MaxHeaderBytes:    config.MaxHeaderBytes,
+		}
+		servers = append(servers, s3Server)
+		if config.TLSEnabled() {
+			go func() { serverErr <- s3Server.ListenAndServeTLS(config.TLSCert, config.TLSKey) }()
+		} else {
+			go func() { serverErr <- s3Server.ListenAndServe() }()
+		}
+		fmt.Println("🪣 S3 API on " + scheme + displayAddr(config.S3APIListen))
+	}
+
+	// Optional SFTP server on its own port
+	if config.SFTPListen != "" {
+		if err := sftpd.Start(config.SFTPListen, config.SFTPHostKey); err != nil {
+			log.Fatal("Failed to start SFTP server: ", err)
+		}
+		fmt.Println("📡 SFTP on sftp://" + displayAddr(config.SFTPListen))
+		if fingerprint, err := sftpd.Fingerprint(config.SFTPHostKey); err == nil {
+			fmt.Println("🔑 SSH host key fingerprint: " + fingerprint)
+		}
+	}
+
+	select {
+	case err := <-serverErr:
+		log.Fatal(err)
+	case <-ctx.Done():
+	}
+	stop() // A second signal kills the process right away
+
+	fmt.Println("🛑 Shutting down, waiting for in-flight requests...")
+	shutdown(servers)
+	fmt.Println("👋 Bye.")
+}
+
+// shutdown drains in-flight requests, stops background tasks and flushes state to disk
+func shutdown(servers []*http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			fmt.Println("⚠️  Some requests did not finish in time:", err)
+			server.Close()
+		}
+	}
+
+	sftpd.Close()
+	trash.StopTrash()
+	uploads.Stop()
+	if err := session.Close(); err != nil {
+		fmt.Println("⚠️  Failed to save sessions:", err)
+	}
+	if err := seclog.Close(); err != nil {
+		fmt.Println("⚠️  Failed to close security log:", err)
+	}
+}
+
+// redirectToHTTPS sends plain HTTP requests to the same host on the HTTPS port
+func redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(r.Host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+
+	_, port, _ := net.SplitHostPort(config.ListenAddr)
+	if port != "443" {
+		host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]" // IPv6 literal
+	}
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+}
+
+// displayAddr turns a listen address like ":8080" into something clickable
+func displayAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host == "" || host == "0.0.0.0" || host == "::" {
+		return "localhost:" + port
+	}
+	return addr
 }